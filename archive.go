@@ -0,0 +1,461 @@
+package sftp
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strings"
+)
+
+// archiveReadAheadSize is the size up to which files are read ahead while an archive is written.
+// Larger files are read when their turn comes, with concurrent requests.
+const archiveReadAheadSize = 1 << 20
+
+// An ArchiveOption is a function which applies configuration to WriteTar, WriteZip and ExtractTar.
+type ArchiveOption func(*archiver) error
+
+// ArchiveConcurrency sets how many directories are listed at the same time,
+// and how many small files are read ahead of the one being written to the archive.
+//
+// The default is 8.
+func ArchiveConcurrency(n int) ArchiveOption {
+	return func(a *archiver) error {
+		if n < 1 {
+			return errors.New("n must be greater or equal to 1")
+		}
+		a.concurrency = n
+		return nil
+	}
+}
+
+// ArchivePreserveSpecialBits sets whether ExtractTar restores the setuid, setgid and sticky bits of entries.
+//
+// The default is false, and only the permission bits are restored,
+// so that an archive cannot plant setuid executables on the server.
+func ArchivePreserveSpecialBits(preserve bool) ArchiveOption {
+	return func(a *archiver) error {
+		a.specialBits = preserve
+		return nil
+	}
+}
+
+type archiver struct {
+	c           *Client
+	concurrency int
+	specialBits bool
+}
+
+// archiveEntry is a file to add to an archive.
+type archiveEntry struct {
+	path   string // on the server.
+	name   string // in the archive.
+	info   os.FileInfo
+	target string // of a symbolic link.
+
+	// The content of a file read ahead, once done is closed.
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+// WriteTar writes the tree at remoteRoot to w as a tar archive, and returns once the archive is complete.
+// It does not close w.
+//
+// Entries are named relative to remoteRoot, or after its base name if it is not a directory,
+// and carry the modes, modification times, owners and symbolic link targets of the files.
+// Directories are listed, and small files are read, concurrently, see ArchiveConcurrency.
+func (c *Client) WriteTar(w io.Writer, remoteRoot string, opts ...ArchiveOption) error {
+	tw := tar.NewWriter(w)
+
+	err := c.writeArchive(remoteRoot, opts, func(e *archiveEntry, r io.Reader) error {
+		hdr, err := tar.FileInfoHeader(e.info, e.target)
+		if err != nil {
+			return err
+		}
+
+		hdr.Name = e.name
+		if e.info.IsDir() {
+			hdr.Name += "/"
+		}
+
+		if stat, ok := e.info.Sys().(*FileStat); ok {
+			hdr.Uid, hdr.Gid = int(stat.UID), int(stat.GID)
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		return copyArchiveData(tw, r, e)
+	})
+	if err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+// WriteZip writes the tree at remoteRoot to w as a zip archive, and returns once the archive is complete.
+// It does not close w.
+//
+// Entries are named as by WriteTar, and regular files are compressed with Deflate.
+// Symbolic links are stored with their target as content, as zip tools expect.
+func (c *Client) WriteZip(w io.Writer, remoteRoot string, opts ...ArchiveOption) error {
+	zw := zip.NewWriter(w)
+
+	err := c.writeArchive(remoteRoot, opts, func(e *archiveEntry, r io.Reader) error {
+		hdr, err := zip.FileInfoHeader(e.info)
+		if err != nil {
+			return err
+		}
+
+		hdr.Name = e.name
+		switch mode := e.info.Mode(); {
+		case mode.IsDir():
+			hdr.Name += "/"
+		case mode.IsRegular():
+			hdr.Method = zip.Deflate
+		}
+
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+
+		if e.info.Mode()&os.ModeSymlink != 0 {
+			_, err := io.WriteString(fw, e.target)
+			return err
+		}
+
+		return copyArchiveData(fw, r, e)
+	})
+	if err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+// copyArchiveData copies the content of the regular file e from r to w,
+// and checks that it still has the size it was listed with.
+func copyArchiveData(w io.Writer, r io.Reader, e *archiveEntry) error {
+	if r == nil {
+		return nil
+	}
+
+	n, err := io.Copy(w, r)
+	if err != nil {
+		return err
+	}
+
+	if n != e.info.Size() {
+		return fmt.Errorf("sftp: %s changed size while it was archived", e.path)
+	}
+
+	return nil
+}
+
+// writeArchive lists the tree at root, and calls add for every entry in order,
+// with the content of regular files, and nil for anything else.
+func (c *Client) writeArchive(root string, opts []ArchiveOption, add func(e *archiveEntry, r io.Reader) error) error {
+	a := &archiver{
+		c:           c,
+		concurrency: 8,
+	}
+
+	for _, opt := range opts {
+		if err := opt(a); err != nil {
+			return err
+		}
+	}
+
+	root = path.Clean(root)
+
+	rootInfo, tree, err := c.listTree(context.Background(), root, a.concurrency)
+	if err != nil {
+		return err
+	}
+
+	var entries []*archiveEntry
+	if !rootInfo.IsDir() {
+		entries = append(entries, &archiveEntry{path: root, name: path.Base(root), info: rootInfo})
+	}
+
+	prefix := strings.TrimSuffix(root, "/") + "/"
+	for p, fi := range tree {
+		entries = append(entries, &archiveEntry{path: p, name: strings.TrimPrefix(p, prefix), info: fi})
+	}
+
+	// Directories come before their content.
+	slices.SortFunc(entries, func(a, b *archiveEntry) int { return strings.Compare(a.name, b.name) })
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	// Small files are read ahead, up to concurrency of them at the same time.
+	sem := make(chan struct{}, a.concurrency)
+	queue := make(chan *archiveEntry, a.concurrency)
+
+	go func() {
+		defer close(queue)
+
+		for _, e := range entries {
+			if e.info.Mode().IsRegular() && e.info.Size() <= archiveReadAheadSize {
+				select {
+				case sem <- struct{}{}:
+				case <-stop:
+					return
+				}
+
+				e.done = make(chan struct{})
+				go a.readAhead(e)
+			}
+
+			select {
+			case queue <- e:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	for e := range queue {
+		err := a.add(e, add)
+		if e.done != nil {
+			e.data = nil
+			<-sem
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a *archiver) readAhead(e *archiveEntry) {
+	defer close(e.done)
+
+	f, err := a.c.Open(e.path)
+	if err != nil {
+		e.err = err
+		return
+	}
+	defer f.Close()
+
+	buf := bytes.NewBuffer(make([]byte, 0, e.info.Size()))
+	if _, err := f.WriteTo(buf); err != nil {
+		e.err = err
+		return
+	}
+
+	e.data = buf.Bytes()
+}
+
+func (a *archiver) add(e *archiveEntry, add func(e *archiveEntry, r io.Reader) error) error {
+	switch mode := e.info.Mode(); {
+	case mode.IsDir():
+		return add(e, nil)
+
+	case mode&os.ModeSymlink != 0:
+		target, err := a.c.ReadLink(e.path)
+		if err != nil {
+			return err
+		}
+		e.target = target
+		return add(e, nil)
+
+	case mode.IsRegular():
+		if e.done != nil {
+			<-e.done
+			if e.err != nil {
+				return e.err
+			}
+			return add(e, bytes.NewReader(e.data))
+		}
+
+		f, err := a.c.Open(e.path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		return add(e, f)
+
+	default:
+		// Devices, pipes and sockets cannot be read over SFTP.
+		return nil
+	}
+}
+
+// ExtractTar unpacks the tar archive read from r under remoteRoot on the server,
+// restoring the modes and modification times of files and directories, and symbolic and hard links.
+// The setuid, setgid and sticky bits are cleared, unless ArchivePreserveSpecialBits is given.
+//
+// Entries that would land outside remoteRoot, directly or through a symbolic link of the archive,
+// and hard links to or through such a symbolic link, are rejected with an error matching os.ErrPermission.
+// An entry with the same name as a symbolic link extracted before it replaces the link.
+// Devices, pipes and other special files are skipped.
+// Owners are not restored, as user and group IDs are rarely shared between systems.
+func (c *Client) ExtractTar(r io.Reader, remoteRoot string, opts ...ArchiveOption) error {
+	a := &archiver{c: c}
+	for _, opt := range opts {
+		if err := opt(a); err != nil {
+			return err
+		}
+	}
+
+	tr := tar.NewReader(r)
+
+	if err := c.MkdirAll(remoteRoot); err != nil {
+		return err
+	}
+
+	// Modes and times of directories are applied last,
+	// as adding their content changes their times, and they might not be writable.
+	var dirs []*tar.Header
+	symlinks := make(map[string]bool)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		name := "/" + hdr.Name
+		if !withinRoot(name) || throughSymlink(symlinks, path.Clean(name)) {
+			return &os.PathError{Op: "extract", Path: hdr.Name, Err: os.ErrPermission}
+		}
+		name = path.Clean(name)
+
+		p := path.Join(remoteRoot, name)
+		if symlinks[name] && hdr.Typeflag != tar.TypeSymlink {
+			// Replace the symbolic link, rather than writing through it.
+			if err := c.removeExisting(p); err != nil {
+				return err
+			}
+			delete(symlinks, name)
+		}
+		if hdr.Typeflag != tar.TypeDir {
+			if err := c.MkdirAll(path.Dir(p)); err != nil {
+				return err
+			}
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := c.MkdirAll(p); err != nil {
+				return err
+			}
+			dirs = append(dirs, hdr)
+			continue
+
+		case tar.TypeReg:
+			if err := c.extractFile(p, tr); err != nil {
+				return err
+			}
+
+		case tar.TypeSymlink:
+			if err := c.removeExisting(p); err != nil {
+				return err
+			}
+			if err := c.Symlink(hdr.Linkname, p); err != nil {
+				return err
+			}
+			symlinks[name] = true
+			continue
+
+		case tar.TypeLink:
+			target := "/" + hdr.Linkname
+			if !withinRoot(target) {
+				return &os.PathError{Op: "extract", Path: hdr.Name, Err: os.ErrPermission}
+			}
+			target = path.Clean(target)
+			if symlinks[target] || throughSymlink(symlinks, target) {
+				return &os.PathError{Op: "extract", Path: hdr.Name, Err: os.ErrPermission}
+			}
+			if err := c.removeExisting(p); err != nil {
+				return err
+			}
+			if err := c.Link(path.Join(remoteRoot, target), p); err != nil {
+				return err
+			}
+			continue
+
+		default:
+			continue
+		}
+
+		if err := a.restoreMetadata(p, hdr); err != nil {
+			return err
+		}
+	}
+
+	for _, hdr := range slices.Backward(dirs) {
+		if err := a.restoreMetadata(path.Join(remoteRoot, path.Clean("/"+hdr.Name)), hdr); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// throughSymlink reports whether a parent directory of name is one of the symbolic links extracted so far.
+func throughSymlink(symlinks map[string]bool, name string) bool {
+	for dir := path.Dir(name); dir != "/"; dir = path.Dir(dir) {
+		if symlinks[dir] {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Client) extractFile(p string, r io.Reader) error {
+	f, err := c.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.ReadFromWithConcurrency(r, 0); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// removeExisting removes the file at p, if there is one, so that a link can be created in its place.
+func (c *Client) removeExisting(p string) error {
+	if err := c.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (a *archiver) restoreMetadata(p string, hdr *tar.Header) error {
+	mask := os.ModePerm
+	if a.specialBits {
+		mask |= os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+	}
+
+	if err := a.c.Chmod(p, hdr.FileInfo().Mode()&mask); err != nil {
+		return err
+	}
+
+	atime := hdr.AccessTime
+	if atime.IsZero() {
+		atime = hdr.ModTime
+	}
+
+	return a.c.Chtimes(p, atime, hdr.ModTime)
+}
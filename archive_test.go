@@ -0,0 +1,244 @@
+package sftp
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func archiveTree(t *testing.T) (string, map[string]string) {
+	dir := t.TempDir()
+
+	big := make([]byte, archiveReadAheadSize+100)
+	rand.New(rand.NewSource(1)).Read(big)
+
+	files := map[string]string{
+		"a.txt":         "hello",
+		"sub/b.txt":     "world",
+		"sub/deep/c":    "",
+		"sub/large.bin": string(big),
+	}
+
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0o755))
+		require.NoError(t, os.WriteFile(p, []byte(content), 0o640))
+	}
+
+	require.NoError(t, os.Chmod(filepath.Join(dir, "a.txt"), 0o600))
+	require.NoError(t, os.Symlink("../a.txt", filepath.Join(dir, "sub", "link")))
+
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "a.txt"), mtime, mtime))
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "sub"), mtime, mtime))
+
+	return dir, files
+}
+
+func TestClientWriteTarExtractTar(t *testing.T) {
+	skipIfWindows(t)
+
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	dir, files := archiveTree(t)
+
+	var buf bytes.Buffer
+	require.NoError(t, client.WriteTar(&buf, dir, ArchiveConcurrency(2)))
+
+	var names []string
+	tr := tar.NewReader(bytes.NewReader(buf.Bytes()))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		names = append(names, hdr.Name)
+
+		switch hdr.Name {
+		case "sub/link":
+			assert.Equal(t, byte(tar.TypeSymlink), hdr.Typeflag)
+			assert.Equal(t, "../a.txt", hdr.Linkname)
+		case "a.txt":
+			assert.EqualValues(t, 0o600, hdr.Mode&0o777)
+		}
+
+		if content, ok := files[hdr.Name]; ok {
+			got, err := io.ReadAll(tr)
+			require.NoError(t, err)
+			assert.True(t, content == string(got), "content of %s differs", hdr.Name)
+		}
+	}
+	assert.Equal(t, []string{"a.txt", "sub/", "sub/b.txt", "sub/deep/", "sub/deep/c", "sub/large.bin", "sub/link"}, names)
+
+	dst := filepath.Join(t.TempDir(), "extracted")
+	require.NoError(t, client.ExtractTar(bytes.NewReader(buf.Bytes()), dst))
+
+	for name, content := range files {
+		got, err := os.ReadFile(filepath.Join(dst, filepath.FromSlash(name)))
+		require.NoError(t, err)
+		assert.True(t, content == string(got), "content of %s differs", name)
+	}
+
+	target, err := os.Readlink(filepath.Join(dst, "sub", "link"))
+	require.NoError(t, err)
+	assert.Equal(t, "../a.txt", target)
+
+	for _, name := range []string{"a.txt", "sub"} {
+		want, err := os.Stat(filepath.Join(dir, name))
+		require.NoError(t, err)
+		got, err := os.Stat(filepath.Join(dst, name))
+		require.NoError(t, err)
+		assert.Equal(t, want.Mode(), got.Mode(), name)
+		assert.True(t, want.ModTime().Equal(got.ModTime()), name)
+	}
+}
+
+func TestClientWriteZip(t *testing.T) {
+	skipIfWindows(t)
+
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	dir, files := archiveTree(t)
+
+	var buf bytes.Buffer
+	require.NoError(t, client.WriteZip(&buf, dir))
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+
+	var names []string
+	for _, zf := range zr.File {
+		names = append(names, zf.Name)
+
+		r, err := zf.Open()
+		require.NoError(t, err)
+		got, err := io.ReadAll(r)
+		require.NoError(t, err)
+		r.Close()
+
+		if content, ok := files[zf.Name]; ok {
+			assert.True(t, content == string(got), "content of %s differs", zf.Name)
+		}
+		if zf.Name == "sub/link" {
+			assert.NotZero(t, zf.Mode()&os.ModeSymlink)
+			assert.Equal(t, "../a.txt", string(got))
+		}
+	}
+	assert.Equal(t, []string{"a.txt", "sub/", "sub/b.txt", "sub/deep/", "sub/deep/c", "sub/large.bin", "sub/link"}, names)
+
+	// A single file is named after itself.
+	buf.Reset()
+	require.NoError(t, client.WriteZip(&buf, filepath.Join(dir, "a.txt")))
+	zr, err = zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	require.Len(t, zr.File, 1)
+	assert.Equal(t, "a.txt", zr.File[0].Name)
+}
+
+func TestClientExtractTarOutsideRoot(t *testing.T) {
+	skipIfWindows(t)
+
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	tarOf := func(hdrs ...*tar.Header) io.Reader {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for _, hdr := range hdrs {
+			require.NoError(t, tw.WriteHeader(hdr))
+		}
+		require.NoError(t, tw.Close())
+		return &buf
+	}
+
+	base := t.TempDir()
+	dst := filepath.Join(base, "dst")
+
+	err := client.ExtractTar(tarOf(&tar.Header{Name: "../evil", Typeflag: tar.TypeReg, Mode: 0o644}), dst)
+	assert.ErrorIs(t, err, os.ErrPermission)
+
+	err = client.ExtractTar(tarOf(
+		&tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: base},
+		&tar.Header{Name: "link/evil", Typeflag: tar.TypeReg, Mode: 0o644},
+	), dst)
+	assert.ErrorIs(t, err, os.ErrPermission)
+
+	_, err = os.Stat(filepath.Join(base, "evil"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	secret := filepath.Join(base, "secret")
+	require.NoError(t, os.WriteFile(secret, []byte("secret"), 0o644))
+
+	// A file with the name of an earlier symbolic link replaces the link, rather than being written through it.
+	err = client.ExtractTar(tarOf(
+		&tar.Header{Name: "file", Typeflag: tar.TypeSymlink, Linkname: secret},
+		&tar.Header{Name: "file", Typeflag: tar.TypeReg, Mode: 0o644},
+	), dst)
+	require.NoError(t, err)
+
+	fi, err := os.Lstat(filepath.Join(dst, "file"))
+	require.NoError(t, err)
+	assert.True(t, fi.Mode().IsRegular())
+
+	err = client.ExtractTar(tarOf(
+		&tar.Header{Name: "dir", Typeflag: tar.TypeSymlink, Linkname: base},
+		&tar.Header{Name: "hard", Typeflag: tar.TypeLink, Linkname: "dir/secret"},
+	), dst)
+	assert.ErrorIs(t, err, os.ErrPermission)
+
+	err = client.ExtractTar(tarOf(
+		&tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: secret},
+		&tar.Header{Name: "hard", Typeflag: tar.TypeLink, Linkname: "link"},
+	), dst)
+	assert.ErrorIs(t, err, os.ErrPermission)
+
+	_, err = os.Lstat(filepath.Join(dst, "hard"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	b, err := os.ReadFile(secret)
+	require.NoError(t, err)
+	assert.Equal(t, "secret", string(b))
+}
+
+func TestClientExtractTarSpecialBits(t *testing.T) {
+	skipIfWindows(t)
+
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	tarOf := func() io.Reader {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: "setuid", Typeflag: tar.TypeReg, Mode: 0o4755}))
+		require.NoError(t, tw.Close())
+		return &buf
+	}
+
+	dst := t.TempDir()
+
+	require.NoError(t, client.ExtractTar(tarOf(), dst))
+	fi, err := os.Stat(filepath.Join(dst, "setuid"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o755), fi.Mode(), "special bits are cleared by default")
+
+	require.NoError(t, client.ExtractTar(tarOf(), dst, ArchivePreserveSpecialBits(true)))
+	fi, err = os.Stat(filepath.Join(dst, "setuid"))
+	require.NoError(t, err)
+	assert.Equal(t, os.ModeSetuid|0o755, fi.Mode())
+}
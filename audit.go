@@ -0,0 +1,221 @@
+package sftp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// An AuditSink receives a record of every operation handled by a server,
+// see WithAuditSink and WithRSAuditSink.
+//
+// Operations on paths are recorded once they are handled, including the ones that were denied.
+// Files and directories opened successfully are recorded when their handle is closed,
+// with the bytes transferred through it, and the time it was open;
+// handles left open by the client are recorded when the server stops.
+//
+// Audit is called concurrently by the workers of the server, and delays the response to the client.
+type AuditSink interface {
+	Audit(rec *AuditRecord)
+}
+
+// An AuditRecord describes an operation handled by a server.
+type AuditRecord struct {
+	// Time is when the operation started, or the handle was opened.
+	Time time.Time
+
+	// Session identifies the server, see WithSessionID and WithRSSessionID.
+	Session string
+
+	// Identity is the identity of the session, see WithSessionIdentity and WithRSSessionIdentity.
+	Identity string
+
+	// Method is the operation, as in AuthRequest.
+	// Handles are recorded with the method of the Request they would get on a RequestServer:
+	// Get for files opened for reading, Put for writing, Open for both, and List for directories.
+	Method string
+
+	// Path and Target are the paths of the operation, as in AuthRequest.
+	Path   string
+	Target string
+
+	// BytesRead and BytesWritten are the bytes read from and written to a handle by the client.
+	BytesRead    int64
+	BytesWritten int64
+
+	Duration time.Duration
+
+	// Status is the SSH_FX status code of the operation, and Err the error behind it, nil on success.
+	Status uint32
+	Err    error
+}
+
+// newSessionID returns a random identifier for a server.
+func newSessionID() string {
+	return rand.Text()
+}
+
+// handleStats tracks a handle for the authorizer, the audit sink and the idle timeout.
+type handleStats struct {
+	method string
+	path   string
+	opened time.Time
+
+	read    atomic.Int64
+	written atomic.Int64
+	used    atomic.Int64 // UnixNano of the last request on the handle.
+	busy    atomic.Int32 // requests in progress on the handle.
+}
+
+// openMethod returns the method of the Request a RequestServer would open a file with for pflags.
+func openMethod(pflags uint32) string {
+	flags := newFileOpenFlags(pflags)
+	switch {
+	case !flags.Write && !flags.Append && !flags.Creat && !flags.Trunc:
+		return "Get"
+	case flags.Read:
+		return "Open"
+	default:
+		return "Put"
+	}
+}
+
+// newAuditRecord returns the record of the operation req, answered by rpkt,
+// or nil if the operation is to be recorded when its handle is closed.
+func newAuditRecord(req *AuthRequest, rpkt responsePacket, start time.Time) *AuditRecord {
+	if _, ok := rpkt.(*sshFxpHandlePacket); ok {
+		return nil
+	}
+
+	rec := &AuditRecord{
+		Time:     start,
+		Method:   req.Method,
+		Path:     req.Path,
+		Target:   req.Target,
+		Duration: time.Since(start),
+	}
+
+	if status, ok := rpkt.(*sshFxpStatusPacket); ok && status.StatusError.Code != sshFxOk {
+		rec.Status = status.StatusError.Code
+		rec.Err = &StatusError{Code: status.StatusError.Code, msg: status.StatusError.msg}
+	}
+
+	return rec
+}
+
+// newHandleAuditRecord returns the record of a handle closed with err.
+func newHandleAuditRecord(st *handleStats, err error) *AuditRecord {
+	rec := &AuditRecord{
+		Time:         st.opened,
+		Method:       st.method,
+		Path:         st.path,
+		BytesRead:    st.read.Load(),
+		BytesWritten: st.written.Load(),
+		Duration:     time.Since(st.opened),
+	}
+
+	if err != nil {
+		rec.Status = statusFromError(0, err).StatusError.Code
+		rec.Err = err
+	}
+
+	return rec
+}
+
+// NewSlogAuditSink returns an AuditSink logging every record to logger,
+// at level Info, or Warn for failed operations.
+func NewSlogAuditSink(logger *slog.Logger) AuditSink {
+	return &slogAuditSink{logger: logger}
+}
+
+type slogAuditSink struct {
+	logger *slog.Logger
+}
+
+func (s *slogAuditSink) Audit(rec *AuditRecord) {
+	attrs := []slog.Attr{
+		slog.String("session", rec.Session),
+		slog.String("identity", rec.Identity),
+		slog.String("method", rec.Method),
+		slog.String("path", rec.Path),
+	}
+	if rec.Target != "" {
+		attrs = append(attrs, slog.String("target", rec.Target))
+	}
+	if rec.BytesRead != 0 || rec.BytesWritten != 0 {
+		attrs = append(attrs, slog.Int64("bytes_read", rec.BytesRead), slog.Int64("bytes_written", rec.BytesWritten))
+	}
+	attrs = append(attrs,
+		slog.Time("start", rec.Time),
+		slog.Duration("duration", rec.Duration),
+		slog.Uint64("status", uint64(rec.Status)),
+	)
+
+	level := slog.LevelInfo
+	if rec.Err != nil {
+		level = slog.LevelWarn
+		attrs = append(attrs, slog.String("error", rec.Err.Error()))
+	}
+
+	s.logger.LogAttrs(context.Background(), level, "sftp audit", attrs...)
+}
+
+// NewJSONAuditSink returns an AuditSink writing every record to w as a line of JSON,
+// with the fields of AuditRecord in snake case, the duration in nanoseconds, and the error as a string.
+// Records are written whole, with one call to w.Write each; errors writing them are ignored.
+func NewJSONAuditSink(w io.Writer) AuditSink {
+	return &jsonAuditSink{w: w}
+}
+
+type jsonAuditSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+type jsonAuditRecord struct {
+	Time         time.Time     `json:"time"`
+	Session      string        `json:"session"`
+	Identity     string        `json:"identity,omitempty"`
+	Method       string        `json:"method"`
+	Path         string        `json:"path"`
+	Target       string        `json:"target,omitempty"`
+	BytesRead    int64         `json:"bytes_read"`
+	BytesWritten int64         `json:"bytes_written"`
+	Duration     time.Duration `json:"duration"`
+	Status       uint32        `json:"status"`
+	Err          string        `json:"error,omitempty"`
+}
+
+func (s *jsonAuditSink) Audit(rec *AuditRecord) {
+	jrec := jsonAuditRecord{
+		Time:         rec.Time,
+		Session:      rec.Session,
+		Identity:     rec.Identity,
+		Method:       rec.Method,
+		Path:         rec.Path,
+		Target:       rec.Target,
+		BytesRead:    rec.BytesRead,
+		BytesWritten: rec.BytesWritten,
+		Duration:     rec.Duration,
+		Status:       rec.Status,
+	}
+	if rec.Err != nil {
+		jrec.Err = rec.Err.Error()
+	}
+
+	b, err := json.Marshal(jrec)
+	if err != nil {
+		return
+	}
+	b = append(b, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.w.Write(b)
+}
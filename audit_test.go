@@ -0,0 +1,214 @@
+package sftp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingAuditSink struct {
+	mu      sync.Mutex
+	records []*AuditRecord
+}
+
+func (s *recordingAuditSink) Audit(rec *AuditRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records = append(s.records, rec)
+}
+
+// find returns the records of method on p.
+func (s *recordingAuditSink) find(method, p string) []*AuditRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var found []*AuditRecord
+	for _, rec := range s.records {
+		if rec.Method == method && rec.Path == p {
+			found = append(found, rec)
+		}
+	}
+	return found
+}
+
+func TestServerAuditSink(t *testing.T) {
+	skipIfWindows(t)
+
+	dir := t.TempDir()
+	sink := &recordingAuditSink{}
+
+	deny := AuthorizerFunc(func(req *AuthRequest) error {
+		if (req.Method == "Remove" || req.Method == "Rmdir") && path.Base(req.Path) == "keep" {
+			return os.ErrPermission
+		}
+		return nil
+	})
+
+	client, server := clientServerPair(t,
+		WithRoot(dir),
+		WithAuditSink(sink),
+		WithAuthorizer(deny),
+		WithSessionID("session-1"),
+		WithSessionIdentity("acme"),
+	)
+	defer client.Close()
+	defer server.Close()
+
+	f, err := client.OpenFile("/upload", os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	recs := sink.find("Put", "/upload")
+	require.Len(t, recs, 1)
+	assert.Equal(t, "session-1", recs[0].Session)
+	assert.Equal(t, "acme", recs[0].Identity)
+	assert.EqualValues(t, 5, recs[0].BytesWritten)
+	assert.Zero(t, recs[0].BytesRead)
+	assert.NoError(t, recs[0].Err)
+	assert.False(t, recs[0].Time.IsZero())
+
+	f, err = client.Open("/upload")
+	require.NoError(t, err)
+	_, err = io.ReadAll(f)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	recs = sink.find("Get", "/upload")
+	require.Len(t, recs, 1)
+	assert.EqualValues(t, 5, recs[0].BytesRead)
+
+	require.NoError(t, client.Rename("/upload", "/keep"))
+	recs = sink.find("Rename", "/upload")
+	require.Len(t, recs, 1)
+	assert.Equal(t, "/keep", recs[0].Target)
+
+	assert.Error(t, client.Remove("/keep"))
+	recs = sink.find("Remove", "/keep")
+	require.Len(t, recs, 1)
+	assert.EqualValues(t, sshFxPermissionDenied, recs[0].Status)
+	assert.ErrorIs(t, recs[0].Err, os.ErrPermission)
+
+	_, err = client.Open("/missing")
+	assert.Error(t, err)
+	recs = sink.find("Open", "/missing")
+	require.Len(t, recs, 1)
+	assert.EqualValues(t, sshFxNoSuchFile, recs[0].Status)
+
+	_, err = client.ReadDir("/")
+	require.NoError(t, err)
+	assert.Len(t, sink.find("List", "/"), 1)
+}
+
+func TestRequestServerAuditSink(t *testing.T) {
+	sink := &recordingAuditSink{}
+
+	p := clientRequestServerPair(t, WithRSAuditSink(sink), WithRSSessionIdentity("acme"))
+
+	_, err := putTestFile(p.cli, "/foo", "hello")
+	require.NoError(t, err)
+
+	recs := sink.find("Open", "/foo")
+	require.Len(t, recs, 1)
+	assert.Equal(t, "acme", recs[0].Identity)
+	assert.NotEmpty(t, recs[0].Session)
+	assert.EqualValues(t, 5, recs[0].BytesWritten)
+
+	_, err = p.cli.Stat("/bar")
+	assert.Error(t, err)
+	recs = sink.find("Stat", "/bar")
+	require.Len(t, recs, 1)
+	assert.EqualValues(t, sshFxNoSuchFile, recs[0].Status)
+
+	// Handles left open are recorded when the server stops.
+	_, err = p.cli.Open("/foo")
+	require.NoError(t, err)
+
+	p.svr.Close()
+	<-p.svrResult
+	p.cli.Close()
+
+	recs = sink.find("Get", "/foo")
+	require.Len(t, recs, 1)
+	assert.Error(t, recs[0].Err)
+}
+
+func TestJSONAuditSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := NewJSONAuditSink(&buf)
+
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	sink.Audit(&AuditRecord{
+		Time:         start,
+		Session:      "s",
+		Identity:     "acme",
+		Method:       "Put",
+		Path:         "/upload",
+		BytesWritten: 5,
+		Duration:     time.Millisecond,
+	})
+	sink.Audit(&AuditRecord{
+		Time:    start,
+		Session: "s",
+		Method:  "Remove",
+		Path:    "/keep",
+		Status:  sshFxPermissionDenied,
+		Err:     errors.New("denied"),
+	})
+
+	lines := bytes.Split(bytes.TrimSuffix(buf.Bytes(), []byte("\n")), []byte("\n"))
+	require.Len(t, lines, 2)
+
+	var rec map[string]any
+	require.NoError(t, json.Unmarshal(lines[0], &rec))
+	assert.Equal(t, map[string]any{
+		"time":          "2024-01-02T03:04:05Z",
+		"session":       "s",
+		"identity":      "acme",
+		"method":        "Put",
+		"path":          "/upload",
+		"bytes_read":    float64(0),
+		"bytes_written": float64(5),
+		"duration":      float64(time.Millisecond),
+		"status":        float64(0),
+	}, rec)
+
+	rec = nil
+	require.NoError(t, json.Unmarshal(lines[1], &rec))
+	assert.Equal(t, "denied", rec["error"])
+	assert.Equal(t, float64(sshFxPermissionDenied), rec["status"])
+}
+
+func TestSlogAuditSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := NewSlogAuditSink(slog.New(slog.NewJSONHandler(&buf, nil)))
+
+	sink.Audit(&AuditRecord{
+		Session: "s",
+		Method:  "Rename",
+		Path:    "/a",
+		Target:  "/b",
+		Status:  sshFxFailure,
+		Err:     errors.New("failed"),
+	})
+
+	var rec map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &rec))
+	assert.Equal(t, "WARN", rec["level"])
+	assert.Equal(t, "Rename", rec["method"])
+	assert.Equal(t, "/b", rec["target"])
+	assert.Equal(t, "failed", rec["error"])
+	assert.NotContains(t, rec, "bytes_read")
+}
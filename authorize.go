@@ -0,0 +1,279 @@
+package sftp
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// An Authorizer decides whether a client may perform an operation,
+// before the filesystem, or the Handlers of a RequestServer, are touched.
+//
+// Authorize returns nil to allow the request.
+// Any error denies it and is sent to the client, see WithAuthorizer and WithRSAuthorizer;
+// errors matching os.ErrPermission are reported as SSH_FX_PERMISSION_DENIED.
+// It is called concurrently by the workers of the server.
+type Authorizer interface {
+	Authorize(req *AuthRequest) error
+}
+
+// AuthorizerFunc adapts an ordinary function to an Authorizer.
+type AuthorizerFunc func(req *AuthRequest) error
+
+// Authorize calls f(req).
+func (f AuthorizerFunc) Authorize(req *AuthRequest) error {
+	return f(req)
+}
+
+// An AuthRequest describes an operation requested by a client, to an Authorizer.
+type AuthRequest struct {
+	// Method is the operation, named like Request.Method, one of:
+	// Open, List, Stat, Lstat, Readlink, StatVFS, Setstat, Mkdir,
+	// Remove, Rmdir, Rename, PosixRename, Link and Symlink.
+	Method string
+
+	// Path is the cleaned absolute path the operation applies to.
+	// For Setstat on a handle, it is the path the handle was opened with.
+	// For Symlink, it is the target of the link as sent by the client.
+	Path string
+
+	// Target is the cleaned absolute new path of Rename, PosixRename and Link,
+	// and the link created by Symlink.
+	Target string
+
+	// Flags are the flags of Open.
+	Flags FileOpenFlags
+
+	// Identity is the identity of the session, see WithSessionIdentity and WithRSSessionIdentity.
+	Identity string
+}
+
+// newAuthRequest returns the AuthRequest for pkt, or nil if pkt needs no authorization,
+// like reads and writes on handles, which are authorized when they are opened.
+// clean makes a path sent by the client absolute,
+// and handlePath returns the absolute path of an open handle.
+func newAuthRequest(pkt requestPacket, clean func(p string) string, handlePath func(handle string) (string, bool)) *AuthRequest {
+	if p, ok := pkt.(*sshFxpExtendedPacket); ok {
+		if p.SpecificPacket == nil {
+			return nil
+		}
+		pkt = p.SpecificPacket
+	}
+
+	switch p := pkt.(type) {
+	case *sshFxpOpenPacket:
+		return &AuthRequest{Method: "Open", Path: clean(p.Path), Flags: newFileOpenFlags(p.Pflags)}
+	case *sshFxpOpendirPacket:
+		return &AuthRequest{Method: "List", Path: clean(p.Path)}
+	case *sshFxpStatPacket:
+		return &AuthRequest{Method: "Stat", Path: clean(p.Path)}
+	case *sshFxpLstatPacket:
+		return &AuthRequest{Method: "Lstat", Path: clean(p.Path)}
+	case *sshFxpReadlinkPacket:
+		return &AuthRequest{Method: "Readlink", Path: clean(p.Path)}
+	case *sshFxpExtendedPacketStatVFS:
+		return &AuthRequest{Method: "StatVFS", Path: clean(p.Path)}
+	case *sshFxpSetstatPacket:
+		return &AuthRequest{Method: "Setstat", Path: clean(p.Path)}
+	case *sshFxpFsetstatPacket:
+		p2, ok := handlePath(p.Handle)
+		if !ok {
+			// Left to fail with EBADF.
+			return nil
+		}
+		return &AuthRequest{Method: "Setstat", Path: p2}
+	case *sshFxpMkdirPacket:
+		return &AuthRequest{Method: "Mkdir", Path: clean(p.Path)}
+	case *sshFxpRemovePacket:
+		return &AuthRequest{Method: "Remove", Path: clean(p.Filename)}
+	case *sshFxpRmdirPacket:
+		return &AuthRequest{Method: "Rmdir", Path: clean(p.Path)}
+	case *sshFxpRenamePacket:
+		return &AuthRequest{Method: "Rename", Path: clean(p.Oldpath), Target: clean(p.Newpath)}
+	case *sshFxpExtendedPacketPosixRename:
+		return &AuthRequest{Method: "PosixRename", Path: clean(p.Oldpath), Target: clean(p.Newpath)}
+	case *sshFxpExtendedPacketHardlink:
+		return &AuthRequest{Method: "Link", Path: clean(p.Oldpath), Target: clean(p.Newpath)}
+	case *sshFxpSymlinkPacket:
+		return &AuthRequest{Method: "Symlink", Path: p.Targetpath, Target: clean(p.Linkpath)}
+	}
+
+	return nil
+}
+
+// An AuthRight is a set of rights granted by an AuthRule.
+type AuthRight uint8
+
+// Rights granted by an AuthRule.
+const (
+	// AuthRead allows opening files for reading, and Stat, Lstat, Readlink and StatVFS.
+	// It also allows linking to a file with Link, if the file grants every right granted on the new link.
+	AuthRead AuthRight = 1 << iota
+
+	// AuthWrite allows opening files for writing or creating them, Setstat and Mkdir,
+	// and creating the destination of Rename, PosixRename, Link and Symlink.
+	AuthWrite
+
+	// AuthDelete allows Remove, Rmdir, and moving a file away with Rename and PosixRename.
+	AuthDelete
+
+	// AuthList allows listing directories, and Stat, Lstat, Readlink and StatVFS.
+	AuthList
+)
+
+func (r AuthRight) String() string {
+	var rights []string
+	for _, right := range []struct {
+		right AuthRight
+		name  string
+	}{
+		{AuthRead, "read"},
+		{AuthWrite, "write"},
+		{AuthDelete, "delete"},
+		{AuthList, "list"},
+	} {
+		if r&right.right != 0 {
+			rights = append(rights, right.name)
+		}
+	}
+
+	if len(rights) == 0 {
+		return "none"
+	}
+	return strings.Join(rights, "|")
+}
+
+// An AuthRule grants rights on the paths matching a pattern.
+type AuthRule struct {
+	// Pattern is an absolute path pattern, in the syntax of path.Match.
+	// It matches a path, and everything below it.
+	Pattern string
+
+	Rights AuthRight
+}
+
+// RuleAuthorizer is an Authorizer granting rights from a list of AuthRules.
+//
+// A request is checked against the rules in order, and the first rule matching its path applies:
+// rules for subdirectories must come before the rules for their parents.
+// Paths matching no rule have no rights.
+// Operations on two paths, like Rename, need rights on both.
+// Link and Symlink also need the file linked to to grant every right granted on the new link itself,
+// so that links cannot be used to reach paths the rules deny.
+//
+// Paths are matched as they are sent by the client, once cleaned:
+// the server still follows symbolic links that already point elsewhere in the tree.
+type RuleAuthorizer struct {
+	rules []AuthRule
+}
+
+// NewRuleAuthorizer returns a RuleAuthorizer granting rights from rules.
+// It fails if a pattern is not absolute, or malformed.
+func NewRuleAuthorizer(rules ...AuthRule) (*RuleAuthorizer, error) {
+	for _, rule := range rules {
+		if !path.IsAbs(rule.Pattern) {
+			return nil, fmt.Errorf("sftp: rule pattern %q is not absolute", rule.Pattern)
+		}
+		if _, err := path.Match(rule.Pattern, "/"); err != nil {
+			return nil, fmt.Errorf("sftp: rule pattern %q: %w", rule.Pattern, err)
+		}
+	}
+
+	return &RuleAuthorizer{rules: rules}, nil
+}
+
+// Rights returns the rights granted on the path p.
+func (a *RuleAuthorizer) Rights(p string) AuthRight {
+	p = path.Clean(p)
+
+	for _, rule := range a.rules {
+		pattern := path.Clean(rule.Pattern)
+
+		// Try p, then its parents.
+		for q := p; ; q = path.Dir(q) {
+			if ok, _ := path.Match(pattern, q); ok {
+				return rule.Rights
+			}
+			if q == "/" || q == "." {
+				break
+			}
+		}
+	}
+
+	return 0
+}
+
+// Authorize implements Authorizer.
+// Denied requests get an error matching os.ErrPermission.
+func (a *RuleAuthorizer) Authorize(req *AuthRequest) error {
+	switch req.Method {
+	case "Open":
+		var need AuthRight
+		if req.Flags.Write || req.Flags.Append || req.Flags.Creat || req.Flags.Trunc {
+			need |= AuthWrite
+		}
+		if req.Flags.Read || need == 0 {
+			need |= AuthRead
+		}
+		return a.require(req, req.Path, need)
+
+	case "List":
+		return a.require(req, req.Path, AuthList)
+
+	case "Stat", "Lstat", "Readlink", "StatVFS":
+		if a.Rights(req.Path)&(AuthRead|AuthList) == 0 {
+			return a.deny(req, req.Path)
+		}
+		return nil
+
+	case "Setstat", "Mkdir":
+		return a.require(req, req.Path, AuthWrite)
+
+	case "Remove", "Rmdir":
+		return a.require(req, req.Path, AuthDelete)
+
+	case "Rename", "PosixRename":
+		if err := a.require(req, req.Path, AuthDelete); err != nil {
+			return err
+		}
+		return a.require(req, req.Target, AuthWrite)
+
+	case "Link":
+		if err := a.require(req, req.Target, AuthWrite); err != nil {
+			return err
+		}
+		// The file is reached through the new link with the rights granted there,
+		// so it must grant at least those.
+		return a.require(req, req.Path, AuthRead|a.Rights(req.Target))
+
+	case "Symlink":
+		if err := a.require(req, req.Target, AuthWrite); err != nil {
+			return err
+		}
+		// Paths through the link are checked as paths below it,
+		// so the target must grant at least the rights granted on the link.
+		return a.require(req, symlinkTarget(req.Path, req.Target), a.Rights(req.Target))
+	}
+
+	return a.deny(req, req.Path)
+}
+
+// symlinkTarget returns the absolute path a symbolic link at link to target points to.
+func symlinkTarget(target, link string) string {
+	if path.IsAbs(target) {
+		return path.Clean(target)
+	}
+	return path.Join(path.Dir(link), target)
+}
+
+func (a *RuleAuthorizer) require(req *AuthRequest, p string, need AuthRight) error {
+	if a.Rights(p)&need != need {
+		return a.deny(req, p)
+	}
+	return nil
+}
+
+func (a *RuleAuthorizer) deny(req *AuthRequest, p string) error {
+	return &os.PathError{Op: strings.ToLower(req.Method), Path: p, Err: os.ErrPermission}
+}
@@ -0,0 +1,180 @@
+package sftp
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRuleAuthorizer(t *testing.T) {
+	a, err := NewRuleAuthorizer(
+		AuthRule{Pattern: "/partners/*/incoming", Rights: AuthWrite | AuthList},
+		AuthRule{Pattern: "/partners/*/outgoing", Rights: AuthRead | AuthDelete | AuthList},
+		AuthRule{Pattern: "/", Rights: AuthList},
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, AuthList, a.Rights("/"))
+	assert.Equal(t, AuthList, a.Rights("/partners/acme"))
+	assert.Equal(t, AuthWrite|AuthList, a.Rights("/partners/acme/incoming"))
+	assert.Equal(t, AuthWrite|AuthList, a.Rights("/partners/acme/incoming/sub/file"))
+	assert.Equal(t, AuthRead|AuthDelete|AuthList, a.Rights("/partners/acme/outgoing/../outgoing/file"))
+	assert.Equal(t, "write|list", a.Rights("/partners/acme/incoming").String())
+
+	for _, tt := range []struct {
+		req   AuthRequest
+		allow bool
+	}{
+		{AuthRequest{Method: "Open", Path: "/partners/acme/incoming/f", Flags: FileOpenFlags{Write: true, Creat: true}}, true},
+		{AuthRequest{Method: "Open", Path: "/partners/acme/incoming/f", Flags: FileOpenFlags{Read: true, Write: true}}, false},
+		{AuthRequest{Method: "Open", Path: "/partners/acme/outgoing/f", Flags: FileOpenFlags{Read: true}}, true},
+		{AuthRequest{Method: "Open", Path: "/partners/acme/outgoing/f", Flags: FileOpenFlags{Read: true, Trunc: true}}, false},
+		{AuthRequest{Method: "Open", Path: "/readme", Flags: FileOpenFlags{Read: true}}, false},
+		{AuthRequest{Method: "List", Path: "/"}, true},
+		{AuthRequest{Method: "Stat", Path: "/partners/acme/incoming/f"}, true},
+		{AuthRequest{Method: "Setstat", Path: "/partners/acme/incoming/f"}, true},
+		{AuthRequest{Method: "Setstat", Path: "/partners/acme/outgoing/f"}, false},
+		{AuthRequest{Method: "Mkdir", Path: "/partners/new"}, false},
+		{AuthRequest{Method: "Remove", Path: "/partners/acme/outgoing/f"}, true},
+		{AuthRequest{Method: "Remove", Path: "/partners/acme/incoming/f"}, false},
+		{AuthRequest{Method: "Rename", Path: "/partners/acme/outgoing/f", Target: "/partners/acme/incoming/f"}, true},
+		{AuthRequest{Method: "PosixRename", Path: "/partners/acme/incoming/f", Target: "/partners/acme/incoming/g"}, false},
+		{AuthRequest{Method: "Link", Path: "/partners/acme/outgoing/f", Target: "/partners/acme/incoming/f"}, false},
+		{AuthRequest{Method: "Symlink", Path: "g", Target: "/partners/acme/incoming/f"}, true},
+		{AuthRequest{Method: "Symlink", Path: "/partners/acme/incoming/g", Target: "/partners/acme/incoming/f"}, true},
+		{AuthRequest{Method: "Symlink", Path: "/etc/passwd", Target: "/partners/acme/incoming/f"}, false},
+		{AuthRequest{Method: "Symlink", Path: "../outgoing", Target: "/partners/acme/incoming/f"}, false},
+		{AuthRequest{Method: "Symlink", Path: "/partners/acme/incoming/f", Target: "/partners/acme/outgoing/f"}, false},
+		{AuthRequest{Method: "Unknown", Path: "/partners/acme/incoming/f"}, false},
+	} {
+		err := a.Authorize(&tt.req)
+		if tt.allow {
+			assert.NoError(t, err, "%+v", tt.req)
+		} else {
+			assert.ErrorIs(t, err, os.ErrPermission, "%+v", tt.req)
+		}
+	}
+
+	_, err = NewRuleAuthorizer(AuthRule{Pattern: "relative"})
+	assert.Error(t, err)
+
+	_, err = NewRuleAuthorizer(AuthRule{Pattern: "/[bad"})
+	assert.Error(t, err)
+}
+
+func TestServerWithAuthorizer(t *testing.T) {
+	skipIfWindows(t)
+
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "incoming"), 0o755))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "outgoing"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "outgoing", "report"), []byte("report"), 0o644))
+
+	rules, err := NewRuleAuthorizer(
+		AuthRule{Pattern: "/incoming", Rights: AuthWrite | AuthList},
+		AuthRule{Pattern: "/outgoing", Rights: AuthRead | AuthList},
+		AuthRule{Pattern: "/", Rights: AuthList},
+	)
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	var identities []string
+	authorizer := AuthorizerFunc(func(req *AuthRequest) error {
+		mu.Lock()
+		identities = append(identities, req.Identity)
+		mu.Unlock()
+
+		return rules.Authorize(req)
+	})
+
+	client, server := clientServerPair(t, WithRoot(dir), WithAuthorizer(authorizer), WithSessionIdentity("acme"))
+	defer client.Close()
+	defer server.Close()
+
+	fis, err := client.ReadDir("/")
+	require.NoError(t, err)
+	assert.Len(t, fis, 2)
+
+	_, err = client.Create("/incoming/upload")
+	assert.ErrorIs(t, err, os.ErrPermission, "create opens for reading too")
+
+	f, err := client.OpenFile("/incoming/upload", os.O_WRONLY|os.O_CREATE)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("upload"))
+	require.NoError(t, err)
+	assert.NoError(t, f.Chmod(0o600))
+	require.NoError(t, f.Close())
+
+	got, err := os.ReadFile(filepath.Join(dir, "incoming", "upload"))
+	require.NoError(t, err)
+	assert.Equal(t, "upload", string(got))
+
+	_, err = client.Open("/incoming/upload")
+	assert.ErrorIs(t, err, os.ErrPermission)
+
+	f, err = client.Open("/outgoing/report")
+	require.NoError(t, err)
+	assert.ErrorIs(t, f.Chmod(0o600), os.ErrPermission)
+	require.NoError(t, f.Close())
+
+	_, err = client.OpenFile("/outgoing/report", os.O_WRONLY|os.O_TRUNC)
+	assert.ErrorIs(t, err, os.ErrPermission)
+
+	assert.ErrorIs(t, client.Remove("/outgoing/report"), os.ErrPermission)
+	assert.ErrorIs(t, client.Rename("/incoming/upload", "/outgoing/upload"), os.ErrPermission)
+	assert.ErrorIs(t, client.Mkdir("/new"), os.ErrPermission)
+	assert.ErrorIs(t, client.Symlink("/outgoing/report", "/outgoing/link"), os.ErrPermission)
+	assert.ErrorIs(t, client.Symlink("/outgoing", "/incoming/link"), os.ErrPermission, "the link would grant write on /outgoing")
+
+	// Paths are cleaned before they are checked.
+	_, err = client.OpenFile("/incoming/../outgoing/evil", os.O_WRONLY|os.O_CREATE)
+	assert.ErrorIs(t, err, os.ErrPermission)
+
+	_, err = os.Stat(filepath.Join(dir, "outgoing", "evil"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.NotEmpty(t, identities)
+	for _, identity := range identities {
+		assert.Equal(t, "acme", identity)
+	}
+}
+
+func TestRequestServerWithAuthorizer(t *testing.T) {
+	rules, err := NewRuleAuthorizer(
+		AuthRule{Pattern: "/upload", Rights: AuthRead | AuthWrite | AuthList},
+		AuthRule{Pattern: "/", Rights: AuthRead | AuthList},
+	)
+	require.NoError(t, err)
+
+	p := clientRequestServerPair(t, WithRSAuthorizer(rules))
+	defer p.Close()
+
+	require.ErrorIs(t, p.cli.Mkdir("/other"), os.ErrPermission)
+	require.NoError(t, p.cli.Mkdir("/upload"))
+
+	_, err = putTestFile(p.cli, "/upload/file", "hello")
+	require.NoError(t, err)
+
+	_, err = putTestFile(p.cli, "/file", "hello")
+	assert.ErrorIs(t, err, os.ErrPermission)
+
+	assert.ErrorIs(t, p.cli.Remove("/upload/file"), os.ErrPermission)
+	assert.ErrorIs(t, p.cli.Rename("/upload/file", "/upload/renamed"), os.ErrPermission)
+
+	fi, err := p.cli.Stat("/upload/file")
+	require.NoError(t, err)
+	assert.EqualValues(t, 5, fi.Size())
+
+	assert.NoError(t, p.cli.Symlink("file", "/upload/link"))
+	assert.ErrorIs(t, p.cli.Symlink("/", "/upload/root"), os.ErrPermission)
+
+	fis, err := p.cli.ReadDir("/")
+	require.NoError(t, err)
+	assert.Len(t, fis, 1)
+}
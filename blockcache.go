@@ -0,0 +1,292 @@
+package sftp
+
+import (
+	"container/list"
+	"errors"
+	"io"
+	"math"
+	"sync"
+)
+
+// UseBlockCache enables a cache of the blocks read from each File,
+// so that small and overlapping reads through ReadAt and Read are served without a round trip.
+// Every File gets its own cache of up to blocks blocks of blockSize bytes,
+// evicting the least recently used block when it is full.
+//
+// The cache only sees writes made through the same File;
+// changes made to the remote file by anyone else are not noticed while the File is open.
+// Reads larger than the whole cache bypass it.
+//
+// By default, no block cache is used.
+func UseBlockCache(blockSize, blocks int) ClientOption {
+	return func(c *Client) error {
+		if blockSize < 1 {
+			return errors.New("block size must be greater or equal to 1")
+		}
+		if blocks < 1 {
+			return errors.New("blocks must be greater or equal to 1")
+		}
+		c.cacheBlockSize = blockSize
+		c.cacheBlocks = blocks
+		return nil
+	}
+}
+
+// UseReadAhead enables sequential read-ahead for File.Read.
+// Once consecutive calls to Read are seen reading the File sequentially,
+// the next blocks of the file are fetched concurrently into the block cache,
+// before they are asked for.
+//
+// If no block cache was enabled with UseBlockCache,
+// one is used with blocks of the maximum packet size, holding twice as many blocks as are read ahead.
+//
+// By default, no read-ahead is done.
+func UseReadAhead(blocks int) ClientOption {
+	return func(c *Client) error {
+		if blocks < 1 {
+			return errors.New("blocks must be greater or equal to 1")
+		}
+		c.readAheadBlocks = blocks
+		return nil
+	}
+}
+
+// newBlockCache returns the block cache for a File opened by c, or nil if it should not have one.
+func (c *Client) newBlockCache() *blockCache {
+	blockSize, blocks := c.cacheBlockSize, c.cacheBlocks
+	if blocks == 0 {
+		if c.readAheadBlocks == 0 {
+			return nil
+		}
+		blockSize, blocks = c.maxPacket, 2*c.readAheadBlocks
+	}
+
+	return &blockCache{
+		blockSize: blockSize,
+		capacity:  blocks,
+		lru:       list.New(),
+		blocks:    make(map[int64]*list.Element),
+		fetches:   make(map[int64]*blockFetch),
+	}
+}
+
+// blockFetchFunc reads the block at off into b, filling it unless the file ends first.
+type blockFetchFunc func(b []byte, off int64) (int, error)
+
+// blockCache is an LRU cache of the fixed-size blocks of a file.
+// A nil *blockCache caches nothing.
+type blockCache struct {
+	blockSize int
+	capacity  int
+
+	mu      sync.Mutex
+	gen     uint64     // incremented on every invalidation, so that fetches started before it are not cached.
+	lru     *list.List // of *cacheBlock, the most recently used first.
+	blocks  map[int64]*list.Element
+	fetches map[int64]*blockFetch // fetches in flight, shared by everyone reading the same block.
+
+	prefetches sync.WaitGroup
+}
+
+type cacheBlock struct {
+	idx  int64
+	data []byte // shorter than the block size, if the file ends within the block.
+}
+
+type blockFetch struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+// fits reports whether a read of n bytes should go through the cache.
+func (c *blockCache) fits(n int) bool {
+	return c != nil && int64(n) <= int64(c.blockSize)*int64(c.capacity)
+}
+
+// readAt reads len(b) bytes at off through the cache, following io.ReaderAt semantics.
+func (c *blockCache) readAt(b []byte, off int64, fetch blockFetchFunc) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	bs := int64(c.blockSize)
+	first, last := off/bs, (off+int64(len(b))-1)/bs
+
+	// Fetch all blocks but the first concurrently, while we wait for the first one.
+	for idx := first + 1; idx <= last; idx++ {
+		c.prefetch(idx, fetch)
+	}
+
+	var n int
+	for n < len(b) {
+		pos := off + int64(n)
+		idx := pos / bs
+
+		data, err := c.block(idx, fetch)
+		if err != nil {
+			return n, err
+		}
+
+		within := pos - idx*bs
+		if within >= int64(len(data)) {
+			return n, io.EOF
+		}
+
+		n += copy(b[n:], data[within:])
+
+		if len(data) < c.blockSize && n < len(b) {
+			// The file ends within this block.
+			return n, io.EOF
+		}
+	}
+
+	return n, nil
+}
+
+// block returns the data of the block idx, fetching it if it is not cached.
+func (c *blockCache) block(idx int64, fetch blockFetchFunc) ([]byte, error) {
+	c.mu.Lock()
+
+	if e, ok := c.blocks[idx]; ok {
+		c.lru.MoveToFront(e)
+		c.mu.Unlock()
+		return e.Value.(*cacheBlock).data, nil
+	}
+
+	if bf, ok := c.fetches[idx]; ok {
+		c.mu.Unlock()
+		<-bf.done
+		return bf.data, bf.err
+	}
+
+	bf, gen := c.startFetch(idx)
+	c.mu.Unlock()
+
+	c.fetch(idx, bf, gen, fetch)
+	return bf.data, bf.err
+}
+
+// prefetch starts fetching the block idx in the background, unless it is already cached or being fetched.
+func (c *blockCache) prefetch(idx int64, fetch blockFetchFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.blocks[idx]; ok {
+		return
+	}
+	if _, ok := c.fetches[idx]; ok {
+		return
+	}
+
+	bf, gen := c.startFetch(idx)
+
+	c.prefetches.Add(1)
+	go func() {
+		defer c.prefetches.Done()
+		c.fetch(idx, bf, gen, fetch)
+	}()
+}
+
+// startFetch registers a fetch of the block idx.
+// It must be called while holding the lock.
+func (c *blockCache) startFetch(idx int64) (*blockFetch, uint64) {
+	bf := &blockFetch{
+		done: make(chan struct{}),
+	}
+	c.fetches[idx] = bf
+	return bf, c.gen
+}
+
+func (c *blockCache) fetch(idx int64, bf *blockFetch, gen uint64, fetch blockFetchFunc) {
+	defer close(bf.done)
+
+	b := make([]byte, c.blockSize)
+	n, err := fetch(b, idx*int64(c.blockSize))
+	if err == io.EOF {
+		err = nil
+	}
+	bf.data, bf.err = b[:n], err
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.fetches[idx] == bf {
+		delete(c.fetches, idx)
+	}
+
+	if err != nil || gen != c.gen {
+		return
+	}
+
+	if e, ok := c.blocks[idx]; ok {
+		c.lru.Remove(e)
+	}
+	c.blocks[idx] = c.lru.PushFront(&cacheBlock{idx: idx, data: bf.data})
+
+	for c.lru.Len() > c.capacity {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.blocks, oldest.Value.(*cacheBlock).idx)
+	}
+}
+
+// invalidate drops the cached blocks overlapping n bytes written at off,
+// along with the last block of the file, which a write past it would extend.
+// A negative n drops everything.
+func (c *blockCache) invalidate(off, n int64) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	clear(c.fetches)
+
+	if n < 0 {
+		off, n = 0, math.MaxInt64
+	}
+
+	bs := int64(c.blockSize)
+	first, last := off/bs, (off+n-1)/bs
+	if n == 0 {
+		last = first - 1
+	}
+
+	for e := c.lru.Front(); e != nil; {
+		next := e.Next()
+
+		blk := e.Value.(*cacheBlock)
+		if (blk.idx >= first && blk.idx <= last) || len(blk.data) < c.blockSize {
+			c.lru.Remove(e)
+			delete(c.blocks, blk.idx)
+		}
+
+		e = next
+	}
+}
+
+// readAhead starts fetching the n blocks that follow off in the background.
+func (c *blockCache) readAhead(off int64, n int, fetch blockFetchFunc) {
+	if c == nil {
+		return
+	}
+
+	// Leave room for the block being read from.
+	n = min(n, c.capacity-1)
+
+	idx := off / int64(c.blockSize)
+	for i := range int64(n) {
+		c.prefetch(idx+1+i, fetch)
+	}
+}
+
+// wait waits for the background fetches to finish.
+func (c *blockCache) wait() {
+	if c == nil {
+		return
+	}
+	c.prefetches.Wait()
+}
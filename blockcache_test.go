@@ -0,0 +1,158 @@
+package sftp
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeRandomFile creates name with size bytes of random content, and returns the content.
+func writeRandomFile(t *testing.T, cli *Client, name string, size int) []byte {
+	content := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(content)
+
+	f, err := cli.Create(name)
+	require.NoError(t, err)
+	_, err = f.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	return content
+}
+
+func TestBlockCacheReadAt(t *testing.T) {
+	const blockSize = 1024
+	h := newInstrumentedHandlers()
+	p := instrumentedPair(t, h, UseBlockCache(blockSize, 4))
+	content := writeRandomFile(t, p.cli, "/file", 10*blockSize+100)
+
+	f, err := p.cli.Open("/file")
+	require.NoError(t, err)
+	defer f.Close()
+
+	b := make([]byte, 100)
+	for i := 0; i < 10; i++ {
+		n, err := f.ReadAt(b, int64(blockSize+i*10))
+		require.NoError(t, err)
+		assert.Equal(t, content[blockSize+i*10:][:n], b[:n])
+	}
+	assert.EqualValues(t, 1, h.reads.Load(), "overlapping reads within a block")
+
+	// Across a block boundary.
+	n, err := f.ReadAt(b, 2*blockSize-50)
+	require.NoError(t, err)
+	assert.Equal(t, content[2*blockSize-50:][:n], b[:n])
+	assert.EqualValues(t, 2, h.reads.Load())
+
+	// Up to the end of the file.
+	n, err = f.ReadAt(b, int64(len(content)-40))
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 40, n)
+	assert.Equal(t, content[len(content)-40:], b[:n])
+
+	_, err = f.ReadAt(b, int64(len(content)))
+	assert.Equal(t, io.EOF, err)
+
+	// Evict the first block, by reading more than the capacity.
+	for off := int64(3 * blockSize); off < int64(len(content)); off += blockSize {
+		_, err := f.ReadAt(b, off)
+		require.NoError(t, err)
+	}
+
+	before := h.reads.Load()
+	_, err = f.ReadAt(b, blockSize)
+	require.NoError(t, err)
+	assert.Equal(t, before+1, h.reads.Load(), "evicted block is fetched again")
+
+	// Reads larger than the cache bypass it.
+	all := make([]byte, len(content))
+	n, err = f.ReadAt(all, 0)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(content, all[:n]))
+}
+
+func TestBlockCacheWriteInvalidates(t *testing.T) {
+	const blockSize = 512
+	h := newInstrumentedHandlers()
+	p := instrumentedPair(t, h, UseBlockCache(blockSize, 8))
+	content := writeRandomFile(t, p.cli, "/file", 3*blockSize)
+
+	f, err := p.cli.OpenFile("/file", os.O_RDWR)
+	require.NoError(t, err)
+	defer f.Close()
+
+	b := make([]byte, 10)
+	_, err = f.ReadAt(b, blockSize)
+	require.NoError(t, err)
+	assert.Equal(t, content[blockSize:][:10], b)
+
+	_, err = f.WriteAt([]byte("overwrite!"), blockSize)
+	require.NoError(t, err)
+
+	_, err = f.ReadAt(b, blockSize)
+	require.NoError(t, err)
+	assert.Equal(t, "overwrite!", string(b))
+
+	// The cached end of the file must not hide data written past it.
+	b = make([]byte, 18)
+	n, err := f.ReadAt(b, 3*blockSize-10)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 10, n)
+
+	_, err = f.WriteAt([]byte("appended"), 3*blockSize)
+	require.NoError(t, err)
+
+	n, err = f.ReadAt(b, 3*blockSize-10)
+	require.NoError(t, err)
+	assert.Equal(t, 18, n)
+	assert.Equal(t, "appended", string(b[10:]))
+
+	require.NoError(t, f.Truncate(blockSize))
+	assert.Zero(t, f.cache.lru.Len(), "truncate drops every block")
+}
+
+func TestReadAhead(t *testing.T) {
+	const blockSize = 1024
+	h := newInstrumentedHandlers()
+	p := instrumentedPair(t, h, UseBlockCache(blockSize, 8), UseReadAhead(4))
+	content := writeRandomFile(t, p.cli, "/file", 20*blockSize)
+
+	f, err := p.cli.Open("/file")
+	require.NoError(t, err)
+	defer f.Close()
+
+	b := make([]byte, 256)
+	for i := 0; i < 2; i++ {
+		_, err := io.ReadFull(f, b)
+		require.NoError(t, err)
+	}
+
+	// The second sequential Read started reading ahead.
+	f.cache.wait()
+	assert.EqualValues(t, 5, h.reads.Load())
+	for idx := int64(1); idx <= 4; idx++ {
+		assert.Contains(t, f.cache.blocks, idx)
+	}
+
+	got, err := io.ReadAll(struct{ io.Reader }{f}) // hide WriteTo, so Read is used.
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(content[2*len(b):], got))
+	assert.LessOrEqual(t, h.reads.Load(), int64(21), "every block is read once")
+
+	// Random access does not read ahead.
+	f.cache.invalidate(0, -1)
+	before := h.reads.Load()
+
+	_, err = f.Seek(10*blockSize, io.SeekStart)
+	require.NoError(t, err)
+	_, err = f.Read(b)
+	require.NoError(t, err)
+
+	f.cache.wait()
+	assert.Equal(t, before+1, h.reads.Load())
+}
@@ -103,12 +103,12 @@ func MaxConcurrentRequestsPerFile(n int) ClientOption {
 
 // UseConcurrentWrites allows the Client to perform concurrent Writes.
 //
-// Using concurrency while doing writes, requires special consideration.
-// A write to a later offset in a file after an error,
-// could end up with a file length longer than what was successfully written.
+// When a concurrent write fails, the count returned is exactly the length of the data
+// that the server confirmed without a gap, and the error is a *PartialWriteError.
+// As later chunks might still have been written, the file can be longer than that;
+// see UseTruncateOnPartialWrite to have it truncated back.
 //
-// When using this option, if you receive an error during `io.Copy` or `io.WriteTo`,
-// you may need to `Truncate` the target Writer to avoid “holes” in the data written.
+// Concurrent writes are enabled by default.
 func UseConcurrentWrites(value bool) ClientOption {
 	return func(c *Client) error {
 		c.useConcurrentWrites = value
@@ -158,6 +158,43 @@ func UseFstat(value bool) ClientOption {
 	}
 }
 
+// KeepAlive makes the Client probe the server with a cheap SSH_FXP_REALPATH
+// request whenever the connection has been idle for the given interval.
+//
+// If the probe is not answered within the time set by MaxResponseTime,
+// or within the interval if no maximum response time is set,
+// the server is declared dead and every outstanding and future request
+// fails with ErrServerUnresponsive.
+//
+// The default is to not send any keepalive probes.
+func KeepAlive(interval time.Duration) ClientOption {
+	return func(c *Client) error {
+		if interval < 0 {
+			return errors.New("interval must not be negative")
+		}
+		c.keepAlive = interval
+		return nil
+	}
+}
+
+// MaxResponseTime sets the maximum time any single request may wait for its response.
+// If a request is outstanding for longer than this, the server is declared dead
+// and every outstanding and future request fails with ErrServerUnresponsive.
+//
+// Set this well above the time the slowest expected operation takes,
+// as a server that is merely busy cannot be told apart from a dead one.
+//
+// The default is to wait forever.
+func MaxResponseTime(d time.Duration) ClientOption {
+	return func(c *Client) error {
+		if d < 0 {
+			return errors.New("duration must not be negative")
+		}
+		c.maxResponseTime = d
+		return nil
+	}
+}
+
 // Client represents an SFTP session on a *ssh.ClientConn SSH connection.
 // Multiple Clients can be active on a single SSH connection, and a Client
 // may be called concurrently from multiple Goroutines.
@@ -172,11 +209,22 @@ type Client struct {
 	maxConcurrentRequests int
 	nextid                uint32
 
-	// write concurrency is… error prone.
-	// Default behavior should be to not use it.
 	useConcurrentWrites    bool
 	useFstat               bool
 	disableConcurrentReads bool
+
+	keepAlive       time.Duration
+	maxResponseTime time.Duration
+
+	retryPolicy *RetryPolicy
+
+	cacheBlockSize  int
+	cacheBlocks     int
+	readAheadBlocks int
+
+	writeBehind int
+
+	truncateOnPartialWrite bool
 }
 
 // NewClient creates a new SFTP client on conn, using zero or more option
@@ -219,6 +267,7 @@ func NewClientPipe(rd io.Reader, wr io.WriteCloser, opts ...ClientOption) (*Clie
 
 		maxPacket:             1 << 15,
 		maxConcurrentRequests: 64,
+		useConcurrentWrites:   true,
 	}
 
 	for _, opt := range opts {
@@ -237,6 +286,11 @@ func NewClientPipe(rd io.Reader, wr io.WriteCloser, opts ...ClientOption) (*Clie
 		wr.Close()
 		return nil, fmt.Errorf("error receiving version packet from server: %w", err)
 	}
+	sftp.clientConn.lastRecv.Store(time.Now().UnixNano())
+
+	if sftp.maxResponseTime > 0 {
+		sftp.clientConn.sentAt = make(map[uint32]time.Time)
+	}
 
 	sftp.clientConn.wg.Add(1)
 	go func() {
@@ -247,6 +301,15 @@ func NewClientPipe(rd io.Reader, wr io.WriteCloser, opts ...ClientOption) (*Clie
 		}
 	}()
 
+	if sftp.keepAlive > 0 || sftp.maxResponseTime > 0 {
+		sftp.clientConn.wg.Add(1)
+		go func() {
+			defer sftp.clientConn.wg.Done()
+
+			sftp.monitor()
+		}()
+	}
+
 	return sftp, nil
 }
 
@@ -259,7 +322,7 @@ func NewClientPipe(rd io.Reader, wr io.WriteCloser, opts ...ClientOption) (*Clie
 // read/write at the same time. For those services you will need to use
 // `client.OpenFile(os.O_WRONLY|os.O_CREATE|os.O_TRUNC)`.
 func (c *Client) Create(path string) (*File, error) {
-	return c.open(path, toPflags(os.O_RDWR|os.O_CREATE|os.O_TRUNC))
+	return c.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC)
 }
 
 const sftpProtocolVersion = 3 // https://filezilla-project.org/specs/draft-ietf-secsh-filexfer-02.txt
@@ -335,6 +398,11 @@ func (c *Client) ReadDir(p string) ([]os.FileInfo, error) {
 // The passed context can be used to cancel the operation
 // returning all entries listed up to the cancellation.
 func (c *Client) ReadDirContext(ctx context.Context, p string) ([]os.FileInfo, error) {
+	entries, err := c.readDir(ctx, p)
+	return entries, pathError("readdir", p, err)
+}
+
+func (c *Client) readDir(ctx context.Context, p string) ([]os.FileInfo, error) {
 	handle, err := c.opendir(ctx, p)
 	if err != nil {
 		return nil, err
@@ -415,16 +483,31 @@ func (c *Client) opendir(ctx context.Context, path string) (string, error) {
 // Stat returns a FileInfo structure describing the file specified by path 'p'.
 // If 'p' is a symbolic link, the returned FileInfo structure describes the referent file.
 func (c *Client) Stat(p string) (os.FileInfo, error) {
-	fs, err := c.stat(p)
+	var fs *FileStat
+	err := c.retry("Stat", func(int) (err error) {
+		fs, err = c.stat(p)
+		return err
+	})
 	if err != nil {
-		return nil, err
+		return nil, pathError("stat", p, err)
 	}
 	return fileInfoFromStat(fs, path.Base(p)), nil
 }
 
 // Lstat returns a FileInfo structure describing the file specified by path 'p'.
 // If 'p' is a symbolic link, the returned FileInfo structure describes the symbolic link.
-func (c *Client) Lstat(p string) (os.FileInfo, error) {
+func (c *Client) Lstat(p string) (fi os.FileInfo, err error) {
+	err = c.retry("Lstat", func(int) (err error) {
+		fi, err = c.lstat(p)
+		return err
+	})
+	if err != nil {
+		return nil, pathError("lstat", p, err)
+	}
+	return fi, nil
+}
+
+func (c *Client) lstat(p string) (os.FileInfo, error) {
 	id := c.nextID()
 	typ, data, err := c.sendPacket(context.Background(), nil, &sshFxpLstatPacket{
 		ID:   id,
@@ -454,6 +537,14 @@ func (c *Client) Lstat(p string) (os.FileInfo, error) {
 
 // ReadLink reads the target of a symbolic link.
 func (c *Client) ReadLink(p string) (string, error) {
+	target, err := c.readlink(p)
+	if err != nil {
+		return "", pathError("readlink", p, err)
+	}
+	return target, nil
+}
+
+func (c *Client) readlink(p string) (string, error) {
 	id := c.nextID()
 	typ, data, err := c.sendPacket(context.Background(), nil, &sshFxpReadlinkPacket{
 		ID:   id,
@@ -483,6 +574,10 @@ func (c *Client) ReadLink(p string) (string, error) {
 
 // Link creates a hard link at 'newname', pointing at the same inode as 'oldname'
 func (c *Client) Link(oldname, newname string) error {
+	return linkError("link", oldname, newname, c.link(oldname, newname))
+}
+
+func (c *Client) link(oldname, newname string) error {
 	id := c.nextID()
 	typ, data, err := c.sendPacket(context.Background(), nil, &sshFxpHardlinkPacket{
 		ID:      id,
@@ -502,6 +597,10 @@ func (c *Client) Link(oldname, newname string) error {
 
 // Symlink creates a symbolic link at 'newname', pointing at target 'oldname'
 func (c *Client) Symlink(oldname, newname string) error {
+	return linkError("symlink", oldname, newname, c.symlink(oldname, newname))
+}
+
+func (c *Client) symlink(oldname, newname string) error {
 	id := c.nextID()
 	typ, data, err := c.sendPacket(context.Background(), nil, &sshFxpSymlinkPacket{
 		ID:         id,
@@ -565,7 +664,7 @@ func (c *Client) Chtimes(path string, atime time.Time, mtime time.Time) error {
 		Mtime uint32
 	}
 	attrs := times{uint32(atime.Unix()), uint32(mtime.Unix())}
-	return c.setstat(path, sshFileXferAttrACmodTime, attrs)
+	return pathError("chtimes", path, c.setstat(path, sshFileXferAttrACmodTime, attrs))
 }
 
 // Chown changes the user and group owners of the named file.
@@ -575,7 +674,7 @@ func (c *Client) Chown(path string, uid, gid int) error {
 		GID uint32
 	}
 	attrs := owner{uint32(uid), uint32(gid)}
-	return c.setstat(path, sshFileXferAttrUIDGID, attrs)
+	return pathError("chown", path, c.setstat(path, sshFileXferAttrUIDGID, attrs))
 }
 
 // Chmod changes the permissions of the named file.
@@ -584,7 +683,7 @@ func (c *Client) Chown(path string, uid, gid int) error {
 // possible in a portable way without causing a race condition. Callers
 // should mask off umask bits, if desired.
 func (c *Client) Chmod(path string, mode os.FileMode) error {
-	return c.setstat(path, sshFileXferAttrPermissions, toChmodPerm(mode))
+	return pathError("chmod", path, c.setstat(path, sshFileXferAttrPermissions, toChmodPerm(mode)))
 }
 
 // Truncate sets the size of the named file. Although it may be safely assumed
@@ -592,7 +691,7 @@ func (c *Client) Chmod(path string, mode os.FileMode) error {
 // the SFTP protocol does not specify what behavior the server should do when setting
 // size greater than the current size.
 func (c *Client) Truncate(path string, size int64) error {
-	return c.setstat(path, sshFileXferAttrSize, uint64(size))
+	return pathError("truncate", path, c.setstat(path, sshFileXferAttrSize, uint64(size)))
 }
 
 // SetExtendedData sets extended attributes of the named file. It uses the
@@ -606,29 +705,61 @@ func (c *Client) SetExtendedData(path string, extended []StatExtended) error {
 	attrs := &FileStat{
 		Extended: extended,
 	}
-	return c.setstat(path, sshFileXferAttrExtended, attrs)
+	return pathError("setstat", path, c.setstat(path, sshFileXferAttrExtended, attrs))
 }
 
 // Open opens the named file for reading. If successful, methods on the
 // returned file can be used for reading; the associated file descriptor
 // has mode O_RDONLY.
 func (c *Client) Open(path string) (*File, error) {
-	return c.open(path, toPflags(os.O_RDONLY))
+	return c.OpenFile(path, os.O_RDONLY)
 }
 
 // OpenFile is the generalized open call; most users will use Open or
 // Create instead. It opens the named file with specified flag (O_RDONLY
 // etc.). If successful, methods on the returned File can be used for I/O.
 func (c *Client) OpenFile(path string, f int) (*File, error) {
-	return c.open(path, toPflags(f))
+	file, err := c.open(path, toPflags(f), nil)
+	if err != nil {
+		return nil, pathError("open", path, err)
+	}
+	return file, nil
+}
+
+// OpenFileMode is like OpenFile, but if the file is created, it is created with the permissions perm.
+// The permissions are sent along with the open request,
+// so that the file is never accessible with the server's default permissions.
+//
+// Like os.OpenFile, the server may apply its umask to perm.
+func (c *Client) OpenFileMode(path string, f int, perm os.FileMode) (*File, error) {
+	file, err := c.open(path, toPflags(f), permAttrs(perm))
+	if err != nil {
+		return nil, pathError("open", path, err)
+	}
+	return file, nil
 }
 
-func (c *Client) open(path string, pflags uint32) (*File, error) {
+// permAttrs returns the attributes to send in a request that sets the permissions perm.
+func permAttrs(perm os.FileMode) *FileStat {
+	return &FileStat{
+		Mode: toChmodPerm(perm),
+	}
+}
+
+// open sends an open request. If attrs is not nil, its permissions are sent along.
+func (c *Client) open(path string, pflags uint32, attrs *FileStat) (*File, error) {
+	var flags uint32
+	if attrs != nil {
+		flags = sshFileXferAttrPermissions
+	}
+
 	id := c.nextID()
 	typ, data, err := c.sendPacket(context.Background(), nil, &sshFxpOpenPacket{
 		ID:     id,
 		Path:   path,
 		Pflags: pflags,
+		Flags:  flags,
+		Attrs:  attrs,
 	})
 	if err != nil {
 		return nil, err
@@ -640,7 +771,15 @@ func (c *Client) open(path string, pflags uint32) (*File, error) {
 			return nil, &unexpectedIDErr{id, sid}
 		}
 		handle, _ := unmarshalString(data)
-		return &File{c: c, path: path, handle: handle}, nil
+		return &File{
+			c:         c,
+			path:      path,
+			handle:    handle,
+			append:    pflags&sshFxfAppend != 0,
+			cache:     c.newBlockCache(),
+			readAhead: c.readAheadBlocks,
+			wb:        c.newWriteBehind(pflags),
+		}, nil
 	case sshFxpStatus:
 		return nil, normaliseError(unmarshalStatus(id, data))
 	default:
@@ -721,6 +860,14 @@ func (c *Client) fstat(handle string) (*FileStat, error) {
 // It implements the statvfs@openssh.com SSH_FXP_EXTENDED feature
 // from http://www.opensource.apple.com/source/OpenSSH/OpenSSH-175/openssh/PROTOCOL?txt.
 func (c *Client) StatVFS(path string) (*StatVFS, error) {
+	st, err := c.statVFS(path)
+	if err != nil {
+		return nil, pathError("statvfs", path, err)
+	}
+	return st, nil
+}
+
+func (c *Client) statVFS(path string) (*StatVFS, error) {
 	// send the StatVFS packet to the server
 	id := c.nextID()
 	typ, data, err := c.sendPacket(context.Background(), nil, &sshFxpStatvfsPacket{
@@ -802,21 +949,13 @@ func (c *Client) removeFile(path string) error {
 		Filename: path,
 	})
 	if err != nil {
-		return err
+		return pathError("remove", path, err)
 	}
 	switch typ {
 	case sshFxpStatus:
-		err = normaliseError(unmarshalStatus(id, data))
-		if err == nil {
-			return nil
-		}
-		return &os.PathError{
-			Op:   "remove",
-			Path: path,
-			Err:  err,
-		}
+		return pathError("remove", path, normaliseError(unmarshalStatus(id, data)))
 	default:
-		return unimplementedPacketErr(typ)
+		return pathError("remove", path, unimplementedPacketErr(typ))
 	}
 }
 
@@ -828,26 +967,22 @@ func (c *Client) RemoveDirectory(path string) error {
 		Path: path,
 	})
 	if err != nil {
-		return err
+		return pathError("remove", path, err)
 	}
 	switch typ {
 	case sshFxpStatus:
-		err = normaliseError(unmarshalStatus(id, data))
-		if err == nil {
-			return nil
-		}
-		return &os.PathError{
-			Op:   "remove",
-			Path: path,
-			Err:  err,
-		}
+		return pathError("remove", path, normaliseError(unmarshalStatus(id, data)))
 	default:
-		return unimplementedPacketErr(typ)
+		return pathError("remove", path, unimplementedPacketErr(typ))
 	}
 }
 
 // Rename renames a file.
 func (c *Client) Rename(oldname, newname string) error {
+	return linkError("rename", oldname, newname, c.rename(oldname, newname))
+}
+
+func (c *Client) rename(oldname, newname string) error {
 	id := c.nextID()
 	typ, data, err := c.sendPacket(context.Background(), nil, &sshFxpRenamePacket{
 		ID:      id,
@@ -868,6 +1003,10 @@ func (c *Client) Rename(oldname, newname string) error {
 // PosixRename renames a file using the posix-rename@openssh.com extension
 // which will replace newname if it already exists.
 func (c *Client) PosixRename(oldname, newname string) error {
+	return linkError("rename", oldname, newname, c.posixRename(oldname, newname))
+}
+
+func (c *Client) posixRename(oldname, newname string) error {
 	id := c.nextID()
 	typ, data, err := c.sendPacket(context.Background(), nil, &sshFxpPosixRenamePacket{
 		ID:      id,
@@ -890,6 +1029,14 @@ func (c *Client) PosixRename(oldname, newname string) error {
 // This is useful for converting path names containing ".." components,
 // or relative pathnames without a leading slash into absolute paths.
 func (c *Client) RealPath(path string) (string, error) {
+	real, err := c.realpath(path)
+	if err != nil {
+		return "", pathError("realpath", path, err)
+	}
+	return real, nil
+}
+
+func (c *Client) realpath(path string) (string, error) {
 	id := c.nextID()
 	typ, data, err := c.sendPacket(context.Background(), nil, &sshFxpRealpathPacket{
 		ID:   id,
@@ -926,11 +1073,47 @@ func (c *Client) Getwd() (string, error) {
 // Mkdir creates the specified directory. An error will be returned if a file or
 // directory with the specified path already exists, or if the directory's
 // parent folder does not exist (the method cannot create complete paths).
+//
+// If the request is retried, an already existing directory at path counts as success,
+// as it was most likely created by the earlier attempt.
 func (c *Client) Mkdir(path string) error {
+	return pathError("mkdir", path, c.mkdirRetry(path, nil))
+}
+
+// MkdirMode is like Mkdir, but creates the directory with the permissions perm.
+// The permissions are sent along with the mkdir request,
+// so that the directory is never accessible with the server's default permissions.
+//
+// Like os.Mkdir, the server may apply its umask to perm.
+func (c *Client) MkdirMode(path string, perm os.FileMode) error {
+	return pathError("mkdir", path, c.mkdirRetry(path, permAttrs(perm)))
+}
+
+func (c *Client) mkdirRetry(path string, attrs *FileStat) error {
+	return c.retry("Mkdir", func(attempt int) error {
+		err := c.mkdir(path, attrs)
+		if err != nil && attempt > 1 {
+			if fi, serr := c.stat(path); serr == nil && fileInfoFromStat(fi, "").IsDir() {
+				return nil
+			}
+		}
+		return err
+	})
+}
+
+// mkdir sends a mkdir request. If attrs is not nil, its permissions are sent along.
+func (c *Client) mkdir(path string, attrs *FileStat) error {
+	var flags uint32
+	if attrs != nil {
+		flags = sshFileXferAttrPermissions
+	}
+
 	id := c.nextID()
 	typ, data, err := c.sendPacket(context.Background(), nil, &sshFxpMkdirPacket{
-		ID:   id,
-		Path: path,
+		ID:    id,
+		Flags: flags,
+		Path:  path,
+		Attrs: attrs,
 	})
 	if err != nil {
 		return err
@@ -948,6 +1131,16 @@ func (c *Client) Mkdir(path string) error {
 // If path is already a directory, MkdirAll does nothing and returns nil.
 // If, while making any directory, that path is found to already be a regular file, an error is returned.
 func (c *Client) MkdirAll(path string) error {
+	return c.mkdirAll(path, nil)
+}
+
+// MkdirAllMode is like MkdirAll, but creates every missing directory with the permissions perm.
+// Directories that already exist keep their permissions.
+func (c *Client) MkdirAllMode(path string, perm os.FileMode) error {
+	return c.mkdirAll(path, permAttrs(perm))
+}
+
+func (c *Client) mkdirAll(path string, attrs *FileStat) error {
 	// Most of this code mimics https://golang.org/src/os/path.go?s=514:561#L13
 	// Fast path: if we can tell whether path is a directory or file, stop with success or error.
 	dir, err := c.Stat(path)
@@ -971,14 +1164,14 @@ func (c *Client) MkdirAll(path string) error {
 
 	if j > 1 {
 		// Create parent
-		err = c.MkdirAll(path[0 : j-1])
+		err = c.mkdirAll(path[0:j-1], attrs)
 		if err != nil {
 			return err
 		}
 	}
 
 	// Parent now exists; invoke Mkdir and use its result.
-	err = c.Mkdir(path)
+	err = pathError("mkdir", path, c.mkdirRetry(path, attrs))
 	if err != nil {
 		// Handle arguments like "foo/." by
 		// double-checking that directory doesn't exist.
@@ -1038,6 +1231,15 @@ type File struct {
 	mu     sync.RWMutex
 	handle string
 	offset int64 // current offset within remote file
+
+	append bool // opened with O_APPEND, so writes ignore the offset and cannot be retried.
+
+	cache     *blockCache
+	readAhead int   // blocks to prefetch once Read is seen reading sequentially.
+	seqNext   int64 // offset at which the next sequential Read would start.
+	seqReads  int   // number of consecutive sequential Reads.
+
+	wb *writeBehind
 }
 
 // Close closes the File, rendering it unusable for I/O. It returns an
@@ -1047,7 +1249,7 @@ func (f *File) Close() error {
 	defer f.mu.Unlock()
 
 	if f.handle == "" {
-		return os.ErrClosed
+		return f.wrapErr("close", os.ErrClosed)
 	}
 
 	// The design principle here is that when `openssh-portable/sftp-server.c` is doing `handle_close`,
@@ -1056,10 +1258,24 @@ func (f *File) Close() error {
 	// By invalidating our local copy of the handle,
 	// we ensure that there cannot be any erroneous use-after-close requests sent after Close.
 
+	writeErr := f.flushWrites()
+
+	// Read-ahead must not send requests with a handle that the server might reuse.
+	f.cache.wait()
+
 	handle := f.handle
 	f.handle = ""
 
-	return f.c.close(handle)
+	err := f.c.close(handle)
+	if writeErr != nil {
+		err = writeErr
+	}
+	return f.wrapErr("close", err)
+}
+
+// wrapErr records the operation and the name of the file that caused err.
+func (f *File) wrapErr(op string, err error) error {
+	return pathError(op, f.path, err)
 }
 
 // Name returns the name of the file as presented to Open or Create.
@@ -1080,45 +1296,67 @@ func (f *File) Read(b []byte) (int, error) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
+	if f.offset == f.seqNext {
+		f.seqReads++
+	} else {
+		f.seqReads = 0
+	}
+
 	n, err := f.readAt(b, f.offset)
 	f.offset += int64(n)
-	return n, err
+	f.seqNext = f.offset
+
+	if f.readAhead > 0 && f.seqReads >= 2 && err == nil {
+		f.cache.readAhead(f.offset, f.readAhead, f.readAtRemote)
+	}
+
+	return n, f.wrapErr("read", err)
 }
 
 // readChunkAt attempts to read the whole entire length of the buffer from the file starting at the offset.
 // It will continue progressively reading into the buffer until it fills the whole buffer, or an error occurs.
 func (f *File) readChunkAt(ch chan result, b []byte, off int64) (n int, err error) {
 	for err == nil && n < len(b) {
-		id := f.c.nextID()
-		typ, data, err := f.c.sendPacket(context.Background(), ch, &sshFxpReadPacket{
-			ID:     id,
-			Handle: f.handle,
-			Offset: uint64(off) + uint64(n),
-			Len:    uint32(len(b) - n),
+		var m int
+		err = f.c.retry("ReadAt", func(int) (err error) {
+			m, err = f.readPacketAt(ch, b[n:], off+int64(n))
+			return err
 		})
-		if err != nil {
-			return n, err
-		}
+		n += m
+	}
 
-		switch typ {
-		case sshFxpStatus:
-			return n, normaliseError(unmarshalStatus(id, data))
+	return n, err
+}
 
-		case sshFxpData:
-			sid, data := unmarshalUint32(data)
-			if id != sid {
-				return n, &unexpectedIDErr{id, sid}
-			}
+// readPacketAt issues a single read request, and returns how much of the buffer it filled.
+func (f *File) readPacketAt(ch chan result, b []byte, off int64) (int, error) {
+	id := f.c.nextID()
+	typ, data, err := f.c.sendPacket(context.Background(), ch, &sshFxpReadPacket{
+		ID:     id,
+		Handle: f.handle,
+		Offset: uint64(off),
+		Len:    uint32(len(b)),
+	})
+	if err != nil {
+		return 0, err
+	}
 
-			l, data := unmarshalUint32(data)
-			n += copy(b[n:], data[:l])
+	switch typ {
+	case sshFxpStatus:
+		return 0, normaliseError(unmarshalStatus(id, data))
 
-		default:
-			return n, unimplementedPacketErr(typ)
+	case sshFxpData:
+		sid, data := unmarshalUint32(data)
+		if id != sid {
+			return 0, &unexpectedIDErr{id, sid}
 		}
-	}
 
-	return
+		l, data := unmarshalUint32(data)
+		return copy(b, data[:l]), nil
+
+	default:
+		return 0, unimplementedPacketErr(typ)
+	}
 }
 
 func (f *File) readAtSequential(b []byte, off int64) (read int, err error) {
@@ -1148,7 +1386,8 @@ func (f *File) ReadAt(b []byte, off int64) (int, error) {
 	f.mu.RLock()
 	defer f.mu.RUnlock()
 
-	return f.readAt(b, off)
+	n, err := f.readAt(b, off)
+	return n, f.wrapErr("read", err)
 }
 
 // readAt must be called while holding either the Read or Write mutex in File.
@@ -1158,6 +1397,17 @@ func (f *File) readAt(b []byte, off int64) (int, error) {
 		return 0, os.ErrClosed
 	}
 
+	f.flushWrites()
+
+	if f.cache.fits(len(b)) {
+		return f.cache.readAt(b, off, f.readAtRemote)
+	}
+
+	return f.readAtRemote(b, off)
+}
+
+// readAtRemote implements readAt, always reading from the server.
+func (f *File) readAtRemote(b []byte, off int64) (int, error) {
 	if len(b) <= f.c.maxPacket {
 		// This should be able to be serviced with 1/2 requests.
 		// So, just do it directly.
@@ -1272,6 +1522,11 @@ func (f *File) readAt(b []byte, off int64) (int, error) {
 					}
 				}
 
+				if err != nil && f.c.retryPolicy != nil && f.c.retryable(err) {
+					// retry the failed chunk on its own.
+					n, err = f.readChunkAt(nil, packet.b, packet.off)
+				}
+
 				if err != nil {
 					// return the offset as the start + how much we read before the error.
 					errCh <- rErr{packet.off + int64(n), err}
@@ -1320,6 +1575,7 @@ func (f *File) writeToSequential(w io.Writer) (written int64, err error) {
 
 	for {
 		n, err := f.readChunkAt(ch, b, f.offset)
+		err = f.wrapErr("read", err)
 		if n < 0 {
 			panic("sftp.File: returned negative count from readChunkAt")
 		}
@@ -1357,9 +1613,11 @@ func (f *File) WriteTo(w io.Writer) (written int64, err error) {
 	defer f.mu.Unlock()
 
 	if f.handle == "" {
-		return 0, os.ErrClosed
+		return 0, f.wrapErr("read", os.ErrClosed)
 	}
 
+	f.flushWrites()
+
 	if f.c.disableConcurrentReads {
 		return f.writeToSequential(w)
 	}
@@ -1372,7 +1630,7 @@ func (f *File) WriteTo(w io.Writer) (written int64, err error) {
 		fileStat, err = f.c.stat(f.path)
 	}
 	if err != nil {
-		return 0, err
+		return 0, f.wrapErr("read", err)
 	}
 
 	fileSize := fileStat.Size
@@ -1495,6 +1753,7 @@ func (f *File) WriteTo(w io.Writer) (written int64, err error) {
 						err = unimplementedPacketErr(s.typ)
 					}
 				}
+				err = f.wrapErr("read", err)
 
 				writeWork := writeWork{
 					b:   b,
@@ -1554,14 +1813,24 @@ func (f *File) Stat() (os.FileInfo, error) {
 	defer f.mu.RUnlock()
 
 	if f.handle == "" {
-		return nil, os.ErrClosed
+		return nil, f.wrapErr("stat", os.ErrClosed)
 	}
 
-	return f.stat()
+	f.flushWrites()
+
+	fi, err := f.stat()
+	if err != nil {
+		return nil, f.wrapErr("stat", err)
+	}
+	return fi, nil
 }
 
 func (f *File) stat() (os.FileInfo, error) {
-	fs, err := f.c.fstat(f.handle)
+	var fs *FileStat
+	err := f.c.retry("Fstat", func(int) (err error) {
+		fs, err = f.c.fstat(f.handle)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -1581,15 +1850,36 @@ func (f *File) Write(b []byte) (int, error) {
 	defer f.mu.Unlock()
 
 	if f.handle == "" {
-		return 0, os.ErrClosed
+		return 0, f.wrapErr("write", os.ErrClosed)
 	}
 
-	n, err := f.writeAt(b, f.offset)
+	var n int
+	var err error
+	if f.wb != nil {
+		n, err = f.bufferedWrite(b, f.offset)
+	} else {
+		n, err = f.writeAt(b, f.offset)
+	}
+	f.cache.invalidate(f.offset, int64(len(b)))
 	f.offset += int64(n)
+	return n, f.wrapErr("write", err)
+}
+
+func (f *File) writeChunkAt(ch chan result, b []byte, off int64) (n int, err error) {
+	if f.append {
+		// The server ignores the offset, and a retry could write the data twice.
+		return f.writePacketAt(ch, b, off)
+	}
+
+	err = f.c.retry("WriteAt", func(int) (err error) {
+		n, err = f.writePacketAt(ch, b, off)
+		return err
+	})
 	return n, err
 }
 
-func (f *File) writeChunkAt(ch chan result, b []byte, off int64) (int, error) {
+// writePacketAt issues a single write request.
+func (f *File) writePacketAt(ch chan result, b []byte, off int64) (int, error) {
 	typ, data, err := f.c.sendPacket(context.Background(), ch, &sshFxpWritePacket{
 		ID:     f.c.nextID(),
 		Handle: f.handle,
@@ -1629,6 +1919,7 @@ func (f *File) writeAtConcurrent(b []byte, off int64) (int, error) {
 		id  uint32
 		res chan result
 
+		b   []byte
 		off int64
 	}
 	workCh := make(chan work)
@@ -1653,6 +1944,13 @@ func (f *File) writeAtConcurrent(b []byte, off int64) (int, error) {
 				wb = wb[:chunkSize]
 			}
 
+			// Stop before sending more, once a write failed.
+			select {
+			case <-cancel:
+				return
+			default:
+			}
+
 			id := f.c.nextID()
 			res := pool.Get()
 			off := off + int64(read)
@@ -1665,11 +1963,8 @@ func (f *File) writeAtConcurrent(b []byte, off int64) (int, error) {
 				Data:   wb,
 			})
 
-			select {
-			case workCh <- work{id, res, off}:
-			case <-cancel:
-				return
-			}
+			// Always hand over a dispatched write, so that its response is waited for.
+			workCh <- work{id, res, wb, off}
 
 			read += len(wb)
 		}
@@ -1681,6 +1976,8 @@ func (f *File) writeAtConcurrent(b []byte, off int64) (int, error) {
 	}
 	errCh := make(chan wErr)
 
+	var acked ackTracker
+
 	var wg sync.WaitGroup
 	wg.Add(concurrency)
 	for i := 0; i < concurrency; i++ {
@@ -1702,9 +1999,17 @@ func (f *File) writeAtConcurrent(b []byte, off int64) (int, error) {
 					}
 				}
 
+				if err != nil && f.c.retryPolicy != nil && f.c.retryable(err) {
+					// retry the failed chunk on its own.
+					_, err = f.writeChunkAt(nil, work.b, work.off)
+				}
+
 				if err != nil {
 					errCh <- wErr{work.off, err}
+					continue
 				}
+
+				acked.ack(work.off, len(work.b))
 			}
 		}()
 	}
@@ -1732,7 +2037,12 @@ func (f *File) writeAtConcurrent(b []byte, off int64) (int, error) {
 
 	if firstErr.err != nil {
 		// firstErr.err != nil if and only if firstErr.off >= our starting offset.
-		return int(firstErr.off - off), firstErr.err
+		//
+		// Chunks are dispatched in order, and every dispatched chunk is handed to a worker,
+		// so once the workers are done, everything before the first failed chunk has been confirmed,
+		// and no write is left in flight.
+		confirmed := firstErr.off - off
+		return int(confirmed), f.partialWrite(off, confirmed, acked.end, firstErr.err)
 	}
 
 	return len(b), nil
@@ -1746,10 +2056,16 @@ func (f *File) WriteAt(b []byte, off int64) (written int, err error) {
 	defer f.mu.RUnlock()
 
 	if f.handle == "" {
-		return 0, os.ErrClosed
+		return 0, f.wrapErr("write", os.ErrClosed)
+	}
+
+	if err := f.flushWrites(); err != nil {
+		return 0, f.wrapErr("write", err)
 	}
 
-	return f.writeAt(b, off)
+	written, err = f.writeAt(b, off)
+	f.cache.invalidate(off, int64(len(b)))
+	return written, f.wrapErr("write", err)
 }
 
 // writeAt must be called while holding either the Read or Write mutex in File.
@@ -1799,13 +2115,18 @@ func (f *File) writeAt(b []byte, off int64) (written int, err error) {
 func (f *File) ReadFromWithConcurrency(r io.Reader, concurrency int) (read int64, err error) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
+	defer f.cache.invalidate(0, -1)
 
 	return f.readFromWithConcurrency(r, concurrency)
 }
 
 func (f *File) readFromWithConcurrency(r io.Reader, concurrency int) (read int64, err error) {
 	if f.handle == "" {
-		return 0, os.ErrClosed
+		return 0, f.wrapErr("write", os.ErrClosed)
+	}
+
+	if err := f.flushWrites(); err != nil {
+		return 0, f.wrapErr("write", err)
 	}
 
 	// Split the write into multiple maxPacket sized concurrent writes.
@@ -1819,12 +2140,14 @@ func (f *File) readFromWithConcurrency(r io.Reader, concurrency int) (read int64
 		res chan result
 
 		off int64
+		n   int
 	}
 	workCh := make(chan work)
 
 	type rwErr struct {
-		off int64
-		err error
+		off   int64
+		err   error
+		write bool // the error is from writing, rather than from reading r.
 	}
 	errCh := make(chan rwErr)
 
@@ -1834,18 +2157,28 @@ func (f *File) readFromWithConcurrency(r io.Reader, concurrency int) (read int64
 
 	pool := newResChanPool(concurrency)
 
+	start := f.offset
+	var acked ackTracker
+
 	// Slice: cut up the Read into any number of buffers of length <= f.c.maxPacket, and at appropriate offsets.
 	go func() {
 		defer close(workCh)
 
 		b := make([]byte, f.c.maxPacket)
-		off := f.offset
+		off := start
 
 		for {
 			// Fill the entire buffer.
 			n, err := io.ReadFull(r, b)
 
 			if n > 0 {
+				// Stop before sending more, once a write failed.
+				select {
+				case <-cancel:
+					return
+				default:
+				}
+
 				read += int64(n)
 
 				id := f.c.nextID()
@@ -1859,18 +2192,15 @@ func (f *File) readFromWithConcurrency(r io.Reader, concurrency int) (read int64
 					Data:   b[:n],
 				})
 
-				select {
-				case workCh <- work{id, res, off}:
-				case <-cancel:
-					return
-				}
+				// Always hand over a dispatched write, so that its response is waited for.
+				workCh <- work{id, res, off, n}
 
 				off += int64(n)
 			}
 
 			if err != nil {
 				if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
-					errCh <- rwErr{off, err}
+					errCh <- rwErr{off, err, false}
 				}
 				return
 			}
@@ -1899,11 +2229,14 @@ func (f *File) readFromWithConcurrency(r io.Reader, concurrency int) (read int64
 				}
 
 				if err != nil {
-					errCh <- rwErr{work.off, err}
+					errCh <- rwErr{work.off, err, true}
 
 					// DO NOT return.
 					// We want to ensure that workCh is drained before wg.Wait returns.
+					continue
 				}
+
+				acked.ack(work.off, work.n)
 			}
 		}()
 	}
@@ -1915,7 +2248,7 @@ func (f *File) readFromWithConcurrency(r io.Reader, concurrency int) (read int64
 	}()
 
 	// Reduce: Collect all the results into a relevant return: the earliest offset to return an error.
-	firstErr := rwErr{math.MaxInt64, nil}
+	firstErr := rwErr{off: math.MaxInt64}
 	for rwErr := range errCh {
 		if rwErr.off <= firstErr.off {
 			firstErr = rwErr
@@ -1936,14 +2269,17 @@ func (f *File) readFromWithConcurrency(r io.Reader, concurrency int) (read int64
 		// * the offset of the first error from writing,
 		// * the last successfully read offset.
 		//
-		// This could be less than the last successfully written offset,
-		// which is the whole reason for the UseConcurrentWrites() ClientOption.
-		//
-		// Callers are responsible for truncating any SFTP files to a safe length.
+		// Chunks are dispatched in order, and every dispatched chunk is handed to a worker,
+		// so once the workers are done, everything before firstErr.off has been confirmed,
+		// and no write is left in flight.
+		confirmed := firstErr.off - start
 		f.offset = firstErr.off
 
-		// ReadFrom is defined to return the read bytes, regardless of any writer errors.
-		return read, firstErr.err
+		if !firstErr.write {
+			return confirmed, firstErr.err
+		}
+
+		return confirmed, f.wrapErr("write", f.partialWrite(start, confirmed, acked.end, firstErr.err))
 	}
 
 	f.offset += read
@@ -1953,6 +2289,8 @@ func (f *File) readFromWithConcurrency(r io.Reader, concurrency int) (read int64
 // ReadFrom reads data from r until EOF and writes it to the file. The return
 // value is the number of bytes read. Any error except io.EOF encountered
 // during the read is also returned.
+// If a concurrent write fails, the return value is instead the number of bytes
+// the server confirmed without a gap, and the error is a *PartialWriteError.
 //
 // This method is preferred over calling Write multiple times
 // to maximise throughput for transferring the entire file,
@@ -1972,9 +2310,14 @@ func (f *File) readFromWithConcurrency(r io.Reader, concurrency int) (read int64
 func (f *File) ReadFrom(r io.Reader) (int64, error) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
+	defer f.cache.invalidate(0, -1)
 
 	if f.handle == "" {
-		return 0, os.ErrClosed
+		return 0, f.wrapErr("write", os.ErrClosed)
+	}
+
+	if err := f.flushWrites(); err != nil {
+		return 0, f.wrapErr("write", err)
 	}
 
 	if f.c.useConcurrentWrites {
@@ -2033,6 +2376,7 @@ func (f *File) ReadFrom(r io.Reader) (int64, error) {
 			read += int64(n)
 
 			m, err2 := f.writeChunkAt(ch, b[:n], f.offset)
+			err2 = f.wrapErr("write", err2)
 			f.offset += int64(m)
 
 			if err == nil {
@@ -2058,7 +2402,7 @@ func (f *File) Seek(offset int64, whence int) (int64, error) {
 	defer f.mu.Unlock()
 
 	if f.handle == "" {
-		return 0, os.ErrClosed
+		return 0, f.wrapErr("seek", os.ErrClosed)
 	}
 
 	switch whence {
@@ -2066,17 +2410,19 @@ func (f *File) Seek(offset int64, whence int) (int64, error) {
 	case io.SeekCurrent:
 		offset += f.offset
 	case io.SeekEnd:
+		f.flushWrites()
+
 		fi, err := f.stat()
 		if err != nil {
-			return f.offset, err
+			return f.offset, f.wrapErr("seek", err)
 		}
 		offset += fi.Size()
 	default:
-		return f.offset, unimplementedSeekWhence(whence)
+		return f.offset, f.wrapErr("seek", unimplementedSeekWhence(whence))
 	}
 
 	if offset < 0 {
-		return f.offset, os.ErrInvalid
+		return f.offset, f.wrapErr("seek", os.ErrInvalid)
 	}
 
 	f.offset = offset
@@ -2089,13 +2435,13 @@ func (f *File) Chown(uid, gid int) error {
 	defer f.mu.RUnlock()
 
 	if f.handle == "" {
-		return os.ErrClosed
+		return f.wrapErr("chown", os.ErrClosed)
 	}
 
-	return f.c.fsetstat(f.handle, sshFileXferAttrUIDGID, &FileStat{
+	return f.wrapErr("chown", f.c.fsetstat(f.handle, sshFileXferAttrUIDGID, &FileStat{
 		UID: uint32(uid),
 		GID: uint32(gid),
-	})
+	}))
 }
 
 // Chmod changes the permissions of the current file.
@@ -2106,10 +2452,10 @@ func (f *File) Chmod(mode os.FileMode) error {
 	defer f.mu.RUnlock()
 
 	if f.handle == "" {
-		return os.ErrClosed
+		return f.wrapErr("chmod", os.ErrClosed)
 	}
 
-	return f.c.fsetstat(f.handle, sshFileXferAttrPermissions, toChmodPerm(mode))
+	return f.wrapErr("chmod", f.c.fsetstat(f.handle, sshFileXferAttrPermissions, toChmodPerm(mode)))
 }
 
 // SetExtendedData sets extended attributes of the current file. It uses the
@@ -2124,14 +2470,14 @@ func (f *File) SetExtendedData(path string, extended []StatExtended) error {
 	defer f.mu.RUnlock()
 
 	if f.handle == "" {
-		return os.ErrClosed
+		return f.wrapErr("setstat", os.ErrClosed)
 	}
 
 	attrs := &FileStat{
 		Extended: extended,
 	}
 
-	return f.c.fsetstat(f.handle, sshFileXferAttrExtended, attrs)
+	return f.wrapErr("setstat", f.c.fsetstat(f.handle, sshFileXferAttrExtended, attrs))
 }
 
 // Truncate sets the size of the current file. Although it may be safely assumed
@@ -2144,10 +2490,15 @@ func (f *File) Truncate(size int64) error {
 	defer f.mu.RUnlock()
 
 	if f.handle == "" {
-		return os.ErrClosed
+		return f.wrapErr("truncate", os.ErrClosed)
+	}
+
+	if err := f.flushWrites(); err != nil {
+		return f.wrapErr("truncate", err)
 	}
+	defer f.cache.invalidate(0, -1)
 
-	return f.c.fsetstat(f.handle, sshFileXferAttrSize, uint64(size))
+	return f.wrapErr("truncate", f.c.fsetstat(f.handle, sshFileXferAttrSize, uint64(size)))
 }
 
 // Sync requests a flush of the contents of a File to stable storage.
@@ -2157,6 +2508,14 @@ func (f *File) Sync() error {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
+	if err := f.flushWrites(); err != nil {
+		return f.wrapErr("sync", err)
+	}
+
+	return f.wrapErr("sync", f.sync())
+}
+
+func (f *File) sync() error {
 	if f.handle == "" {
 		return os.ErrClosed
 	}
@@ -2184,8 +2543,11 @@ func (f *File) Sync() error {
 	}
 }
 
-// normaliseError normalises an error into a more standard form that can be
-// checked against stdlib errors like io.EOF or os.ErrNotExist.
+// normaliseError normalises an error into a more standard form.
+// End of file is reported as a bare io.EOF, as required by io.Reader,
+// and missing files and denied permissions as os.ErrNotExist and os.ErrPermission,
+// so that os.IsNotExist and os.IsPermission recognize them once wrapped in an *os.PathError.
+// Other status codes remain a *StatusError.
 func normaliseError(err error) error {
 	switch err := err.(type) {
 	case *StatusError:
@@ -2206,6 +2568,29 @@ func normaliseError(err error) error {
 	}
 }
 
+// pathError records the operation and the path that caused err,
+// unless err is nil, io.EOF, or already carries them.
+func pathError(op, path string, err error) error {
+	switch err.(type) {
+	case nil, *os.PathError, *os.LinkError:
+		return err
+	}
+	if err == io.EOF {
+		return err
+	}
+	return &os.PathError{Op: op, Path: path, Err: err}
+}
+
+// linkError records the operation and the paths that caused err,
+// unless err is nil, or already carries them.
+func linkError(op, oldname, newname string, err error) error {
+	switch err.(type) {
+	case nil, *os.PathError, *os.LinkError:
+		return err
+	}
+	return &os.LinkError{Op: op, Old: oldname, New: newname, Err: err}
+}
+
 // flags converts the flags passed to OpenFile into ssh flags.
 // Unsupported flags are ignored.
 func toPflags(f int) uint32 {
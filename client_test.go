@@ -198,3 +198,85 @@ func TestClientNoSid(t *testing.T) {
 		t.Fatal("expected ErrSSHFxConnectionLost, got", err)
 	}
 }
+
+func TestStatusErrorIs(t *testing.T) {
+	tests := []struct {
+		code   uint32
+		target error
+	}{
+		{sshFxNoSuchFile, os.ErrNotExist},
+		{sshFxPermissionDenied, os.ErrPermission},
+		{sshFxFileAlreadyExists, os.ErrExist},
+		{sshFxEOF, io.EOF},
+		{sshFxFailure, ErrSSHFxFailure},
+		{sshFxConnectionLost, &StatusError{Code: sshFxConnectionLost, msg: "other message"}},
+	}
+
+	for _, tt := range tests {
+		err := &StatusError{Code: tt.code}
+		if !errors.Is(err, tt.target) {
+			t.Errorf("errors.Is(%v, %v) = false, want true", err, tt.target)
+		}
+		if errors.Is(err, ErrSSHFxOpUnsupported) {
+			t.Errorf("errors.Is(%v, ErrSSHFxOpUnsupported) = true, want false", err)
+		}
+	}
+}
+
+func TestClientPathErrors(t *testing.T) {
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	dir := t.TempDir()
+	missing := dir + "/missing"
+
+	_, err := client.Stat(missing)
+	var pathErr *os.PathError
+	if !errors.As(err, &pathErr) || pathErr.Op != "stat" || pathErr.Path != missing {
+		t.Fatalf("Stat() = %#v, want *os.PathError for stat %s", err, missing)
+	}
+	if !os.IsNotExist(err) || !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("os.IsNotExist(%v) = false, want true", err)
+	}
+
+	if err := os.WriteFile(dir+"/notdir", nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.ReadDir(dir + "/notdir")
+	var status *StatusError
+	if !errors.As(err, &status) || status.FxCode() != ErrSSHFxFailure || status.Message() == "" {
+		t.Errorf("ReadDir() = %v, want a *StatusError with code and message", err)
+	}
+
+	err = client.Rename(missing, dir+"/other")
+	var linkErr *os.LinkError
+	if !errors.As(err, &linkErr) || linkErr.Op != "rename" || linkErr.Old != missing {
+		t.Errorf("Rename() = %#v, want *os.LinkError for rename", err)
+	}
+
+	// removal tries both a file and a directory, but reports the error only once.
+	err = client.Remove(missing)
+	if !errors.As(err, &pathErr) || pathErr.Op != "remove" {
+		t.Errorf("Remove() = %#v, want *os.PathError for remove", err)
+	}
+	if _, ok := pathErr.Err.(*os.PathError); ok {
+		t.Errorf("Remove() = %v, wrapped twice", err)
+	}
+
+	f, err := client.Create(dir + "/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// reaching the end of the file is not an error, and must be reported as is.
+	if _, err := f.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Read() at end of file = %v, want io.EOF", err)
+	}
+
+	f.Close()
+	_, err = f.Write([]byte("hello"))
+	if !errors.As(err, &pathErr) || pathErr.Op != "write" || !errors.Is(err, os.ErrClosed) {
+		t.Errorf("Write() after Close() = %#v, want *os.PathError wrapping os.ErrClosed", err)
+	}
+}
@@ -0,0 +1,129 @@
+package sftp
+
+import (
+	"io"
+	"os"
+)
+
+// A CloseHook is called when a client closes a file it opened for writing,
+// once the file is closed, for example to scan, index or ingest the upload;
+// see WithOnClose and WithRSOnClose.
+//
+// An error returned by the hook is sent to the client as the status of its close,
+// so that the client sees the upload fail when its content is rejected;
+// errors matching os.ErrPermission are reported as SSH_FX_PERMISSION_DENIED.
+// The hook is not called for the files the client does not close,
+// such as the ones closed when idle or when the server stops.
+//
+// The hook is called by the workers of the server, and delays the response to the client.
+type CloseHook func(f *ClosedFile) error
+
+// A ClosedFile is a file written by a client, passed to a CloseHook.
+type ClosedFile struct {
+	// Path is the absolute path of the file, as seen by the client.
+	Path string
+
+	// Size is the size of the file once closed.
+	Size int64
+
+	// Session and Identity identify the server, as in AuditRecord.
+	Session  string
+	Identity string
+
+	// Content reads the file. It is only valid until the hook returns.
+	Content io.ReaderAt
+
+	quarantine func(dst string) error
+}
+
+// Quarantine moves the file to the path dst, as a client would rename it,
+// for example to a directory out of reach of the client.
+// dst is an absolute path in the tree the server serves.
+func (f *ClosedFile) Quarantine(dst string) error {
+	return f.quarantine(dst)
+}
+
+// writable reports whether a handle opened with method was opened for writing.
+func writable(method string) bool {
+	return method == "Put" || method == "Open"
+}
+
+// WithOnClose calls hook when a client closes a file it opened for writing, see CloseHook.
+// The content is read by opening the file again, and Quarantine renames it within the filesystem of the Server.
+func WithOnClose(hook CloseHook) ServerOption {
+	return func(s *Server) error {
+		s.onClose = hook
+		return nil
+	}
+}
+
+// runOnClose calls the CloseHook for the file of the handle st, closed by the client.
+func (svr *Server) runOnClose(st *handleStats) error {
+	name := svr.localPath(st.path)
+
+	f, err := svr.fsys.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	return svr.onClose(&ClosedFile{
+		Path:     st.path,
+		Size:     fi.Size(),
+		Session:  svr.sessionID,
+		Identity: svr.identity,
+		Content:  f,
+		quarantine: func(dst string) error {
+			return svr.fsys.Rename(name, svr.localPath(dst))
+		},
+	})
+}
+
+// WithRSOnClose calls hook when a client closes a file it opened for writing, see CloseHook.
+// The content is read from the FileReader of the Handlers, the size is asked to the FileLister with a Stat Request,
+// and Quarantine is a Rename Request to the FileCmder.
+func WithRSOnClose(hook CloseHook) RequestServerOption {
+	return func(rs *RequestServer) {
+		rs.onClose = hook
+	}
+}
+
+// runOnClose calls the CloseHook for the file of r, closed by the client.
+func (rs *RequestServer) runOnClose(r *Request) error {
+	fi, err := rs.stat(r.Filepath)
+	if err != nil {
+		return err
+	}
+
+	rd, err := rs.Handlers.FileGet.Fileread(&Request{
+		Method:   "Get",
+		Filepath: r.Filepath,
+		Flags:    sshFxfRead,
+	})
+	if err != nil {
+		return err
+	}
+	if c, ok := rd.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	return rs.onClose(&ClosedFile{
+		Path:     r.Filepath,
+		Size:     fi.Size(),
+		Session:  rs.sessionID,
+		Identity: rs.identity,
+		Content:  rd,
+		quarantine: func(dst string) error {
+			return rs.Handlers.FileCmd.Filecmd(&Request{
+				Method:   "Rename",
+				Filepath: r.Filepath,
+				Target:   cleanPathWithBase(rs.startDirectory, dst),
+			})
+		},
+	})
+}
@@ -0,0 +1,120 @@
+package sftp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scanner is a CloseHook quarantining the files containing "virus".
+type scanner struct {
+	mu     sync.Mutex
+	closed []ClosedFile
+}
+
+func (s *scanner) hook(f *ClosedFile) error {
+	content, err := io.ReadAll(io.NewSectionReader(f.Content, 0, f.Size))
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.closed = append(s.closed, *f)
+	s.mu.Unlock()
+
+	if bytes.Contains(content, []byte("virus")) {
+		if err := f.Quarantine(path.Join("/quarantine", path.Base(f.Path))); err != nil {
+			return err
+		}
+		return errors.New("upload rejected")
+	}
+	return nil
+}
+
+func (s *scanner) paths() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var paths []string
+	for _, f := range s.closed {
+		paths = append(paths, f.Path)
+	}
+	return paths
+}
+
+func TestServerOnClose(t *testing.T) {
+	skipIfWindows(t)
+
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "quarantine"), 0o755))
+	s := &scanner{}
+
+	client, server := clientServerPair(t, WithRoot(dir), WithOnClose(s.hook), WithSessionID("session-1"))
+	defer client.Close()
+	defer server.Close()
+
+	f, err := client.Create("/clean")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("clean"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	f, err = client.Create("/infected")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("a virus"))
+	require.NoError(t, err)
+	assert.ErrorContains(t, f.Close(), "upload rejected")
+
+	_, err = os.Stat(filepath.Join(dir, "infected"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, err = os.Stat(filepath.Join(dir, "quarantine", "infected"))
+	assert.NoError(t, err)
+
+	// Files only read do not call the hook.
+	f, err = client.Open("/clean")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	assert.Equal(t, []string{"/clean", "/infected"}, s.paths())
+	assert.EqualValues(t, 5, s.closed[0].Size)
+	assert.Equal(t, "session-1", s.closed[0].Session)
+}
+
+func TestRequestServerOnClose(t *testing.T) {
+	s := &scanner{}
+
+	p := clientRequestServerPair(t, WithRSOnClose(s.hook), WithRSSessionIdentity("acme"))
+	defer p.Close()
+
+	require.NoError(t, p.cli.Mkdir("/quarantine"))
+
+	_, err := putTestFile(p.cli, "/clean", "clean")
+	require.NoError(t, err)
+
+	w, err := p.cli.Create("/infected")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("a virus"))
+	require.NoError(t, err)
+	assert.ErrorContains(t, w.Close(), "upload rejected")
+
+	_, err = p.testHandler().fetch("/infected")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, err = p.testHandler().fetch("/quarantine/infected")
+	assert.NoError(t, err)
+
+	f, err := p.cli.Open("/clean")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	assert.Equal(t, []string{"/clean", "/infected"}, s.paths())
+	assert.EqualValues(t, 7, s.closed[1].Size)
+	assert.Equal(t, "acme", s.closed[1].Identity)
+}
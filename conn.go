@@ -3,9 +3,12 @@ package sftp
 import (
 	"context"
 	"encoding"
+	"errors"
 	"fmt"
 	"io"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // conn implements a bidirectional channel on which client and server
@@ -43,8 +46,13 @@ type clientConn struct {
 	conn
 	wg sync.WaitGroup
 
-	sync.Mutex                          // protects inflight
+	sync.Mutex                          // protects inflight and sentAt
 	inflight   map[uint32]chan<- result // outstanding requests
+	sentAt     map[uint32]time.Time     // dispatch time of outstanding requests, nil unless tracked
+
+	lastRecv atomic.Int64 // time of the last received packet, in unix nanoseconds
+
+	unresponsive atomic.Bool // the server was declared unresponsive, see declareUnresponsive
 
 	closed chan struct{}
 	err    error
@@ -58,10 +66,31 @@ func (c *clientConn) Wait() error {
 	return c.err
 }
 
+// isClosed reports whether the conn has shut down.
+func (c *clientConn) isClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
 // Close closes the SFTP session.
+//
+// Once the server was declared unresponsive, the conn is already closed,
+// and Close returns at once: it neither takes the send lock,
+// nor waits for recv, which might be stuck reading from the dead server.
 func (c *clientConn) Close() error {
-	defer c.wg.Wait()
-	return c.conn.Close()
+	if c.unresponsive.Load() {
+		return nil
+	}
+
+	err := c.conn.Close()
+	if !c.unresponsive.Load() {
+		c.wg.Wait()
+	}
+	return err
 }
 
 // recv continuously reads from the server and forwards responses to the
@@ -74,6 +103,8 @@ func (c *clientConn) recv() error {
 		if err != nil {
 			return err
 		}
+		c.lastRecv.Store(time.Now().UnixNano())
+
 		sid, _, err := unmarshalUint32Safe(data)
 		if err != nil {
 			return err
@@ -98,12 +129,15 @@ func (c *clientConn) putChannel(ch chan<- result, sid uint32) bool {
 	select {
 	case <-c.closed:
 		// already closed with broadcastErr, return error on chan.
-		ch <- result{err: ErrSSHFxConnectionLost}
+		ch <- result{err: c.closedErr()}
 		return false
 	default:
 	}
 
 	c.inflight[sid] = ch
+	if c.sentAt != nil {
+		c.sentAt[sid] = time.Now()
+	}
 	return true
 }
 
@@ -113,10 +147,40 @@ func (c *clientConn) getChannel(sid uint32) (chan<- result, bool) {
 
 	ch, ok := c.inflight[sid]
 	delete(c.inflight, sid)
+	delete(c.sentAt, sid)
 
 	return ch, ok
 }
 
+// oldestInflight returns the dispatch time of the longest outstanding request,
+// and false if there are no outstanding requests being tracked.
+func (c *clientConn) oldestInflight() (time.Time, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	var oldest time.Time
+	for _, t := range c.sentAt {
+		if oldest.IsZero() || t.Before(oldest) {
+			oldest = t
+		}
+	}
+
+	return oldest, !oldest.IsZero()
+}
+
+// idleSince returns the time of the last received packet,
+// and false if there are requests outstanding.
+func (c *clientConn) idleSince() (time.Time, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	if len(c.inflight) > 0 {
+		return time.Time{}, false
+	}
+
+	return time.Unix(0, c.lastRecv.Load()), true
+}
+
 // result captures the result of receiving the a packet from the server
 type result struct {
 	typ  byte
@@ -162,11 +226,20 @@ func (c *clientConn) dispatchRequest(ch chan<- result, p idmarshaler) {
 }
 
 // broadcastErr sends an error to all goroutines waiting for a response.
+// Only the first call has any effect, later calls are ignored.
 func (c *clientConn) broadcastErr(err error) {
 	c.Lock()
 	defer c.Unlock()
 
-	bcastRes := result{err: ErrSSHFxConnectionLost}
+	select {
+	case <-c.closed:
+		return
+	default:
+	}
+
+	c.err = err
+
+	bcastRes := result{err: c.closedErr()}
 	for sid, ch := range c.inflight {
 		ch <- bcastRes
 
@@ -176,10 +249,18 @@ func (c *clientConn) broadcastErr(err error) {
 		c.inflight[sid] = make(chan<- result, 1)
 	}
 
-	c.err = err
 	close(c.closed)
 }
 
+// closedErr returns the error reported to requests after the conn has shut down.
+// It must be called while holding the lock.
+func (c *clientConn) closedErr() error {
+	if errors.Is(c.err, ErrServerUnresponsive) {
+		return c.err
+	}
+	return ErrSSHFxConnectionLost
+}
+
 type serverConn struct {
 	conn
 }
@@ -7,6 +7,11 @@ import (
 
 var EBADF = syscall.NewError("fd out of range or not open")
 
+var (
+	errLoop     = syscall.NewError("too many levels of symbolic links")
+	errNotEmpty = syscall.NewError("directory not empty")
+)
+
 func wrapPathError(filepath string, err error) error {
 	if errno, ok := err.(syscall.ErrorString); ok {
 		return &os.PathError{Path: filepath, Err: errno}
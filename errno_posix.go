@@ -10,6 +10,11 @@ import (
 
 const EBADF = syscall.EBADF
 
+const (
+	errLoop     = syscall.ELOOP
+	errNotEmpty = syscall.ENOTEMPTY
+)
+
 func wrapPathError(filepath string, err error) error {
 	if errno, ok := err.(syscall.Errno); ok {
 		return &os.PathError{Path: filepath, Err: errno}
@@ -2,6 +2,7 @@ package sftp_test
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -125,7 +126,8 @@ func ExampleClient_Mkdir_parents() {
 			}
 			parents = path.Join(parents, name)
 			err = client.Mkdir(parents)
-			if status, ok := err.(*sftp.StatusError); ok {
+			var status *sftp.StatusError
+			if errors.As(err, &status) {
 				if status.Code == sshFxFailure {
 					var fi os.FileInfo
 					fi, err = client.Stat(parents)
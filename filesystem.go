@@ -0,0 +1,111 @@
+package sftp
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+
+	"github.com/kr/fs"
+)
+
+// FS is the filesystem surface of a Client.
+//
+// Code written against FS instead of *Client can be tested with a MemFS,
+// without running a server.
+// Use NewClientFS to get the FS of a Client.
+type FS interface {
+	Stat(p string) (os.FileInfo, error)
+	Lstat(p string) (os.FileInfo, error)
+	ReadDir(p string) ([]os.FileInfo, error)
+	ReadDirContext(ctx context.Context, p string) ([]os.FileInfo, error)
+	ReadLink(p string) (string, error)
+	RealPath(path string) (string, error)
+	Getwd() (string, error)
+	StatVFS(path string) (*StatVFS, error)
+
+	Walk(root string) *fs.Walker
+	Glob(pattern string) ([]string, error)
+	Join(elem ...string) string
+
+	Open(path string) (FileHandle, error)
+	Create(path string) (FileHandle, error)
+	OpenFile(path string, f int) (FileHandle, error)
+	OpenFileMode(path string, f int, perm os.FileMode) (FileHandle, error)
+
+	Mkdir(path string) error
+	MkdirMode(path string, perm os.FileMode) error
+	MkdirAll(path string) error
+	MkdirAllMode(path string, perm os.FileMode) error
+
+	Link(oldname, newname string) error
+	Symlink(oldname, newname string) error
+	Rename(oldname, newname string) error
+	PosixRename(oldname, newname string) error
+	Remove(path string) error
+	RemoveDirectory(path string) error
+	RemoveAll(path string) error
+
+	Chtimes(path string, atime time.Time, mtime time.Time) error
+	Chown(path string, uid, gid int) error
+	Chmod(path string, mode os.FileMode) error
+	Truncate(path string, size int64) error
+
+	Close() error
+}
+
+// FileHandle is the surface of a File opened through an FS.
+// *File implements FileHandle.
+type FileHandle interface {
+	io.Reader
+	io.ReaderAt
+	io.Writer
+	io.WriterAt
+	io.Seeker
+	io.Closer
+	io.WriterTo
+	io.ReaderFrom
+
+	Name() string
+	Stat() (os.FileInfo, error)
+	Chmod(mode os.FileMode) error
+	Chown(uid, gid int) error
+	Truncate(size int64) error
+	Sync() error
+}
+
+var _ FileHandle = (*File)(nil)
+
+// NewClientFS returns c as an FS.
+// It differs from c only in that the files it opens are returned as FileHandles.
+func NewClientFS(c *Client) FS {
+	return clientFS{c}
+}
+
+type clientFS struct {
+	*Client
+}
+
+func (c clientFS) Open(path string) (FileHandle, error) {
+	return fileHandle(c.Client.Open(path))
+}
+
+func (c clientFS) Create(path string) (FileHandle, error) {
+	return fileHandle(c.Client.Create(path))
+}
+
+func (c clientFS) OpenFile(path string, f int) (FileHandle, error) {
+	return fileHandle(c.Client.OpenFile(path, f))
+}
+
+func (c clientFS) OpenFileMode(path string, f int, perm os.FileMode) (FileHandle, error) {
+	return fileHandle(c.Client.OpenFileMode(path, f, perm))
+}
+
+// fileHandle avoids returning a nil *File inside a non-nil FileHandle.
+func fileHandle(f *File, err error) (FileHandle, error) {
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
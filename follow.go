@@ -0,0 +1,250 @@
+package sftp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// A FollowOption is a function which applies configuration to File.Follow and Client.Tail.
+type FollowOption func(*follower) error
+
+// FollowPollInterval sets how often the file is checked for new data.
+// After a check finds nothing new, the interval is doubled, up to max,
+// and it goes back to min as soon as new data is found.
+//
+// The default is to poll every 250 milliseconds, backing off up to every 5 seconds.
+func FollowPollInterval(min, max time.Duration) FollowOption {
+	return func(fl *follower) error {
+		if min <= 0 {
+			return errors.New("min must be greater than 0")
+		}
+		if max < min {
+			return errors.New("max must be greater or equal to min")
+		}
+		fl.minInterval, fl.maxInterval = min, max
+		return nil
+	}
+}
+
+// FollowReopen sets whether the file is reopened by name when it is rotated,
+// that is when the path comes to name a different file than the one being followed.
+// The rest of the old file is read before switching to the new one, which is read from its beginning.
+//
+// Without an identity attribute, see FollowIdentityAttr,
+// the path is only known to name a different file once it is shorter than what was already read.
+//
+// The default is to keep following the file that was opened.
+func FollowReopen(value bool) FollowOption {
+	return func(fl *follower) error {
+		fl.reopen = value
+		return nil
+	}
+}
+
+// FollowIdentityAttr sets the type of the extended attribute that identifies a file on the server,
+// such as an inode number, for servers that send one in their file attributes.
+// When both the followed file and the path have this attribute, and they differ, the file was rotated.
+//
+// The default is to not rely on any extended attribute.
+func FollowIdentityAttr(extType string) FollowOption {
+	return func(fl *follower) error {
+		fl.identityAttr = extType
+		return nil
+	}
+}
+
+type follower struct {
+	minInterval  time.Duration
+	maxInterval  time.Duration
+	reopen       bool
+	identityAttr string
+}
+
+func newFollower(opts []FollowOption) (*follower, error) {
+	fl := &follower{
+		minInterval: 250 * time.Millisecond,
+		maxInterval: 5 * time.Second,
+	}
+
+	for _, opt := range opts {
+		if err := opt(fl); err != nil {
+			return nil, err
+		}
+	}
+
+	return fl, nil
+}
+
+// Tail opens path, and follows it from its current end, see File.Follow.
+func (c *Client) Tail(ctx context.Context, path string, w io.Writer, opts ...FollowOption) (int64, error) {
+	f, err := c.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		return 0, err
+	}
+
+	return f.Follow(ctx, w, opts...)
+}
+
+// Follow writes the content of the File from its current offset to w,
+// and then keeps writing the data appended to it, like tail -f,
+// until ctx is done or an error occurs.
+// It returns the number of bytes written, and the error of ctx once it is done.
+//
+// The file is polled for new data, see FollowPollInterval.
+// If the file is truncated below the offset reached, it is read again from its beginning.
+// See FollowReopen to follow the path by name across rotations.
+func (f *File) Follow(ctx context.Context, w io.Writer, opts ...FollowOption) (int64, error) {
+	fl, err := newFollower(opts)
+	if err != nil {
+		return 0, err
+	}
+
+	cur := f
+	defer func() {
+		if cur != f {
+			cur.Close()
+		}
+	}()
+
+	var written int64
+	buf := make([]byte, f.c.maxPacket)
+	interval := fl.minInterval
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return written, err
+		}
+
+		n, err := cur.Read(buf)
+		if n > 0 {
+			m, err := w.Write(buf[:n])
+			written += int64(m)
+			if err != nil {
+				return written, err
+			}
+
+			interval = fl.minInterval
+			continue
+		}
+
+		if err != nil && err != io.EOF {
+			return written, err
+		}
+
+		// We have read everything there is for now.
+		next, err := fl.check(cur)
+		if err != nil {
+			return written, err
+		}
+
+		if next != cur {
+			if cur != f {
+				cur.Close()
+			}
+			cur = next
+
+			interval = fl.minInterval
+			continue
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return written, ctx.Err()
+		case <-timer.C:
+		}
+
+		interval = min(2*interval, fl.maxInterval)
+	}
+}
+
+// check looks for truncation and rotation of cur, once everything in it has been read.
+// It returns the File to read from next, which is cur unless the path was reopened.
+func (fl *follower) check(cur *File) (*File, error) {
+	off, err := cur.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := cur.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if fi.Size() < off {
+		// Truncated, read it again from the start.
+		cur.cache.invalidate(0, -1)
+		if _, err := cur.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return cur, nil
+	}
+
+	// The last block might have been cached short.
+	cur.cache.invalidate(off, 0)
+
+	if !fl.reopen {
+		return cur, nil
+	}
+
+	pathInfo, err := cur.c.Stat(cur.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			// Between the rotation and the creation of the new file.
+			return cur, nil
+		}
+		return nil, err
+	}
+
+	if !fl.rotated(fi, pathInfo, off) {
+		return cur, nil
+	}
+
+	next, err := cur.c.Open(cur.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return cur, nil
+		}
+		return nil, err
+	}
+
+	return next, nil
+}
+
+// rotated reports whether pathInfo describes a different file than fi, of which off bytes were read.
+func (fl *follower) rotated(fi, pathInfo os.FileInfo, off int64) bool {
+	if fl.identityAttr != "" {
+		id, ok := extendedAttr(fi, fl.identityAttr)
+		pathID, pathOK := extendedAttr(pathInfo, fl.identityAttr)
+		if ok && pathOK {
+			return id != pathID
+		}
+	}
+
+	return pathInfo.Size() < off
+}
+
+// extendedAttr returns the data of the extended attribute extType of fi, if it has one.
+func extendedAttr(fi os.FileInfo, extType string) (string, bool) {
+	stat, ok := fi.Sys().(*FileStat)
+	if !ok {
+		return "", false
+	}
+
+	for _, ext := range stat.Extended {
+		if ext.ExtType == extType {
+			return ext.ExtData, true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,123 @@
+package sftp
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// lockedBuffer is a bytes.Buffer safe for concurrent use.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func appendFile(t *testing.T, name, data string) {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.WriteString(data)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+}
+
+func TestFileFollow(t *testing.T) {
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	name := filepath.Join(t.TempDir(), "log")
+	require.NoError(t, os.WriteFile(name, []byte("one\n"), 0o600))
+
+	f, err := client.Open(name)
+	require.NoError(t, err)
+	defer f.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var out lockedBuffer
+	type result struct {
+		n   int64
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		n, err := f.Follow(ctx, &out, FollowPollInterval(time.Millisecond, 10*time.Millisecond))
+		done <- result{n, err}
+	}()
+
+	wait := func(want string) {
+		t.Helper()
+		assert.Eventually(t, func() bool { return out.String() == want }, 5*time.Second, time.Millisecond)
+	}
+
+	wait("one\n")
+
+	appendFile(t, name, "two\n")
+	wait("one\ntwo\n")
+
+	// Truncated, and written again.
+	require.NoError(t, os.WriteFile(name, []byte("3\n"), 0o600))
+	wait("one\ntwo\n3\n")
+
+	cancel()
+	res := <-done
+	assert.ErrorIs(t, res.err, context.Canceled)
+	assert.EqualValues(t, len("one\ntwo\n3\n"), res.n)
+
+	_, err = f.Follow(ctx, &out, FollowPollInterval(time.Second, time.Millisecond))
+	assert.Error(t, err)
+}
+
+func TestClientTailReopen(t *testing.T) {
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	name := filepath.Join(t.TempDir(), "log")
+	require.NoError(t, os.WriteFile(name, []byte("before tail\n"), 0o600))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var out lockedBuffer
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.Tail(ctx, name, &out, FollowPollInterval(time.Millisecond, 10*time.Millisecond), FollowReopen(true))
+		done <- err
+	}()
+
+	// Wait for Tail to have opened the file.
+	time.Sleep(50 * time.Millisecond)
+
+	appendFile(t, name, "old\n")
+	assert.Eventually(t, func() bool { return out.String() == "old\n" }, 5*time.Second, time.Millisecond)
+
+	// Rotate the file, and write to the new one.
+	require.NoError(t, os.Rename(name, name+".1"))
+	require.NoError(t, os.WriteFile(name, []byte("new\n"), 0o600))
+
+	assert.Eventually(t, func() bool { return out.String() == "old\nnew\n" }, 5*time.Second, time.Millisecond)
+
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+}
@@ -0,0 +1,81 @@
+package sftp
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrTooManyHandles is the error of opening a file or directory
+	// beyond the limit set by WithMaxOpenHandles or WithRSMaxOpenHandles.
+	// It is reported to the client as SSH_FX_FAILURE.
+	ErrTooManyHandles = errors.New("sftp: too many open handles")
+
+	// ErrHandleIdle is the error a handle is closed with once idle for longer than
+	// the timeout set by WithHandleIdleTimeout or WithRSHandleIdleTimeout.
+	// It is passed to TransferError, and recorded by the AuditSink.
+	ErrHandleIdle = errors.New("sftp: handle closed after being idle")
+)
+
+// touch records a request on the handle at now.
+func (st *handleStats) touch(now time.Time) {
+	st.used.Store(now.UnixNano())
+}
+
+// begin records the start of a request on the handle at now.
+// end must be called once it is done.
+func (st *handleStats) begin(now time.Time) {
+	st.busy.Add(1)
+	st.touch(now)
+}
+
+// end records the end of a request on the handle at now.
+func (st *handleStats) end(now time.Time) {
+	st.touch(now)
+	st.busy.Add(-1)
+}
+
+// idle reports whether the handle has had no request for longer than timeout at now.
+// A handle with a request in progress is never idle.
+func (st *handleStats) idle(now time.Time, timeout time.Duration) bool {
+	return st.busy.Load() == 0 && now.UnixNano()-st.used.Load() > int64(timeout)
+}
+
+// startReaper calls reap every half of timeout, until the returned function is called,
+// which waits for reap to return.
+// It does nothing if timeout is not positive.
+func startReaper(timeout time.Duration, reap func(now time.Time)) (stop func()) {
+	if timeout <= 0 {
+		return func() {}
+	}
+
+	interval := timeout / 2
+	if interval <= 0 {
+		interval = timeout
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				reap(now)
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
@@ -0,0 +1,196 @@
+package sftp
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerMaxOpenHandles(t *testing.T) {
+	skipIfWindows(t)
+
+	dir := t.TempDir()
+	sink := &recordingAuditSink{}
+
+	client, server := clientServerPair(t, WithRoot(dir), WithMaxOpenHandles(2), WithAuditSink(sink))
+	defer client.Close()
+	defer server.Close()
+
+	f1, err := client.Create("/a")
+	require.NoError(t, err)
+	f2, err := client.Create("/b")
+	require.NoError(t, err)
+
+	_, err = client.Create("/c")
+	var status *StatusError
+	require.ErrorAs(t, err, &status)
+	assert.Equal(t, ErrSSHFxFailure, status.FxCode())
+	assert.Contains(t, status.Error(), ErrTooManyHandles.Error())
+
+	_, err = client.ReadDir("/")
+	assert.Error(t, err, "directories count too")
+
+	// An open over the limit leaves the file alone.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "precious"), []byte("precious"), 0o644))
+	_, err = client.Create("/precious")
+	assert.Error(t, err)
+	b, err := os.ReadFile(filepath.Join(dir, "precious"))
+	require.NoError(t, err)
+	assert.Equal(t, "precious", string(b))
+
+	_, err = os.Stat(filepath.Join(dir, "c"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	recs := sink.find("Open", "/c")
+	require.Len(t, recs, 1)
+	assert.EqualValues(t, sshFxFailure, recs[0].Status)
+
+	require.NoError(t, f1.Close())
+
+	f3, err := client.Create("/c")
+	require.NoError(t, err)
+	require.NoError(t, f3.Close())
+	require.NoError(t, f2.Close())
+}
+
+func TestServerHandleIdleTimeout(t *testing.T) {
+	skipIfWindows(t)
+
+	dir := t.TempDir()
+	sink := &recordingAuditSink{}
+
+	client, server := clientServerPair(t, WithRoot(dir), WithHandleIdleTimeout(50*time.Millisecond), WithAuditSink(sink))
+	defer client.Close()
+	defer server.Close()
+
+	busy, err := client.Create("/busy")
+	require.NoError(t, err)
+	idle, err := client.Create("/idle")
+	require.NoError(t, err)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(sink.find("Open", "/idle")) == 0 {
+		require.True(t, time.Now().Before(deadline), "idle handle not closed")
+
+		_, err := busy.Write([]byte("x"))
+		require.NoError(t, err)
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	recs := sink.find("Open", "/idle")
+	require.Len(t, recs, 1)
+	assert.ErrorIs(t, recs[0].Err, ErrHandleIdle)
+
+	_, err = idle.Write([]byte("x"))
+	assert.Error(t, err)
+
+	assert.Empty(t, sink.find("Open", "/busy"))
+	require.NoError(t, busy.Close())
+
+	_, err = os.Stat(filepath.Join(dir, "idle"))
+	assert.NoError(t, err)
+}
+
+func TestRequestServerMaxOpenHandles(t *testing.T) {
+	p := clientRequestServerPair(t, WithRSMaxOpenHandles(1))
+	defer p.Close()
+
+	f, err := p.cli.Create("/foo")
+	require.NoError(t, err)
+
+	_, err = p.cli.Create("/bar")
+	var status *StatusError
+	require.ErrorAs(t, err, &status)
+	assert.Equal(t, ErrSSHFxFailure, status.FxCode())
+
+	_, err = p.testHandler().fetch("/bar")
+	assert.ErrorIs(t, err, os.ErrNotExist, "the Handlers are not called")
+
+	require.NoError(t, f.Close())
+
+	_, err = putTestFile(p.cli, "/bar", "hello")
+	require.NoError(t, err)
+}
+
+func TestRequestServerHandleIdleTimeout(t *testing.T) {
+	sink := &recordingAuditSink{}
+
+	p := clientRequestServerPair(t, WithRSHandleIdleTimeout(50*time.Millisecond), WithRSAuditSink(sink))
+	defer p.Close()
+
+	f, err := p.cli.Create("/foo")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("hello"))
+	require.NoError(t, err)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(sink.find("Open", "/foo")) == 0 {
+		require.True(t, time.Now().Before(deadline), "idle handle not closed")
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	recs := sink.find("Open", "/foo")
+	require.Len(t, recs, 1)
+	assert.ErrorIs(t, recs[0].Err, ErrHandleIdle)
+	assert.EqualValues(t, 5, recs[0].BytesWritten)
+
+	file, err := p.testHandler().fetch("/foo")
+	require.NoError(t, err)
+	file.mu.RLock()
+	assert.ErrorIs(t, file.err, ErrHandleIdle, "TransferError is called")
+	file.mu.RUnlock()
+
+	_, err = f.Write([]byte("x"))
+	assert.Error(t, err)
+}
+
+// slowWriter delays every write by delay.
+type slowWriter struct {
+	FileWriter
+	delay time.Duration
+}
+
+func (h *slowWriter) Filewrite(r *Request) (io.WriterAt, error) {
+	wa, err := h.FileWriter.Filewrite(r)
+	if err != nil {
+		return nil, err
+	}
+	return slowWriterAt{wa, h.delay}, nil
+}
+
+type slowWriterAt struct {
+	io.WriterAt
+	delay time.Duration
+}
+
+func (w slowWriterAt) WriteAt(b []byte, off int64) (int, error) {
+	time.Sleep(w.delay)
+	return w.WriterAt.WriteAt(b, off)
+}
+
+func TestRequestServerHandleIdleTimeoutBusy(t *testing.T) {
+	handlers := InMemHandler()
+	handlers.FilePut = &slowWriter{FileWriter: handlers.FilePut, delay: 300 * time.Millisecond}
+
+	p := clientRequestServerPairWithHandlers(t, handlers, WithRSHandleIdleTimeout(50*time.Millisecond))
+	defer p.Close()
+
+	f, err := p.cli.OpenFile("/foo", os.O_WRONLY|os.O_CREATE)
+	require.NoError(t, err)
+
+	// The write outlasts the idle timeout, but the handle is in use all along.
+	_, err = f.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	file, err := p.testHandler().fetch("/foo")
+	require.NoError(t, err)
+	file.mu.RLock()
+	assert.NoError(t, file.err)
+	file.mu.RUnlock()
+}
@@ -0,0 +1,105 @@
+package sftp
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrServerUnresponsive is reported to every outstanding and future request of a Client,
+// once the server has been declared dead by the KeepAlive or MaxResponseTime options.
+//
+// A Client that returned this error will not recover, and should be discarded.
+// It also matches ErrSSHFxConnectionLost when tested with errors.Is.
+var ErrServerUnresponsive error = serverUnresponsiveErr{}
+
+type serverUnresponsiveErr struct{}
+
+func (serverUnresponsiveErr) Error() string {
+	return "sftp: server unresponsive"
+}
+
+func (serverUnresponsiveErr) Is(target error) bool {
+	return target == ErrSSHFxConnectionLost
+}
+
+// monitor watches the connection for stalled requests, and probes it while idle.
+// It returns once the conn has shut down.
+func (c *Client) monitor() {
+	period := c.keepAlive
+	if c.maxResponseTime > 0 && (period == 0 || c.maxResponseTime < period) {
+		period = c.maxResponseTime
+	}
+
+	// Check several times per period, so that a stall is detected
+	// at most a fraction of a period late.
+	ticker := time.NewTicker(period / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.closed:
+			return
+		case <-ticker.C:
+		}
+
+		if c.maxResponseTime > 0 {
+			if sent, ok := c.oldestInflight(); ok && time.Since(sent) > c.maxResponseTime {
+				c.declareUnresponsive(fmt.Errorf("%w: no response within %v", ErrServerUnresponsive, c.maxResponseTime))
+				return
+			}
+		}
+
+		if c.keepAlive > 0 {
+			if last, ok := c.idleSince(); ok && time.Since(last) >= c.keepAlive {
+				if err := c.probe(); err != nil {
+					c.declareUnresponsive(err)
+					return
+				}
+			}
+		}
+	}
+}
+
+// probe sends a cheap request, and waits for any response to it.
+// It returns a non-nil error only if the server failed to answer in time.
+func (c *Client) probe() error {
+	timeout := c.maxResponseTime
+	if timeout == 0 {
+		timeout = c.keepAlive
+	}
+
+	ch := make(chan result, 1)
+	c.dispatchRequest(ch, &sshFxpRealpathPacket{
+		ID:   c.nextID(),
+		Path: ".",
+	})
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-ch:
+		// Any answer, even an error status, proves the server is alive.
+		return nil
+	case <-c.closed:
+		return nil
+	case <-timer.C:
+		return fmt.Errorf("%w: keepalive probe not answered within %v", ErrServerUnresponsive, timeout)
+	}
+}
+
+// declareUnresponsive fails all outstanding requests with err, and shuts down the conn.
+func (c *Client) declareUnresponsive(err error) {
+	c.clientConn.unresponsive.Store(true)
+	c.clientConn.broadcastErr(err)
+
+	// Close the writer directly, without taking the send lock:
+	// a writer blocked by the dead server could be holding it.
+	c.clientConn.conn.WriteCloser.Close()
+
+	// Likewise, unblock recv if the reader can be closed.
+	if rc, ok := c.clientConn.conn.Reader.(io.Closer); ok {
+		rc.Close()
+	}
+}
@@ -0,0 +1,145 @@
+package sftp
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// silentServerPair returns a Client connected to a server that completes the
+// version handshake, and then never answers any request.
+func silentServerPair(t *testing.T, opts ...ClientOption) *Client {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+
+	go func() {
+		defer sw.Close()
+
+		if _, _, err := recvPacket(sr, nil, 0); err != nil {
+			return
+		}
+		if err := sendPacket(sw, &sshFxVersionPacket{Version: sftpProtocolVersion}); err != nil {
+			return
+		}
+
+		// swallow everything until the client hangs up.
+		for {
+			if _, _, err := recvPacket(sr, nil, 0); err != nil {
+				return
+			}
+		}
+	}()
+
+	client, err := NewClientPipe(cr, cw, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return client
+}
+
+func TestClientMaxResponseTime(t *testing.T) {
+	client := silentServerPair(t, MaxResponseTime(50*time.Millisecond))
+	defer client.Close()
+
+	start := time.Now()
+
+	_, err := client.Stat("/foo")
+	if !errors.Is(err, ErrServerUnresponsive) {
+		t.Fatalf("Stat() = %v, want ErrServerUnresponsive", err)
+	}
+	if !errors.Is(err, ErrSSHFxConnectionLost) {
+		t.Errorf("errors.Is(%v, ErrSSHFxConnectionLost) = false, want true", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("stall detected after %v, want about 50ms", elapsed)
+	}
+
+	// later requests fail immediately with the same error.
+	if _, err := client.Stat("/bar"); !errors.Is(err, ErrServerUnresponsive) {
+		t.Errorf("second Stat() = %v, want ErrServerUnresponsive", err)
+	}
+}
+
+func TestClientKeepAliveDeadServer(t *testing.T) {
+	client := silentServerPair(t, KeepAlive(20*time.Millisecond))
+	defer client.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrServerUnresponsive) {
+			t.Fatalf("Wait() = %v, want ErrServerUnresponsive", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("keepalive did not detect the dead server")
+	}
+}
+
+func TestClientKeepAliveLiveServer(t *testing.T) {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+
+	server, err := NewServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{sr, sw})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve()
+
+	client, err := NewClientPipe(cr, cw, KeepAlive(10*time.Millisecond), MaxResponseTime(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	defer server.Close()
+
+	// several idle periods pass, and each probe is answered.
+	time.Sleep(100 * time.Millisecond)
+
+	if _, err := client.Getwd(); err != nil {
+		t.Fatalf("Getwd() after idle = %v", err)
+	}
+}
+
+func TestClientCloseUnresponsive(t *testing.T) {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	t.Cleanup(func() { sw.Close() })
+
+	// The server completes the handshake, and then neither reads nor hangs up.
+	go func() {
+		if _, _, err := recvPacket(sr, nil, 0); err != nil {
+			return
+		}
+		sendPacket(sw, &sshFxVersionPacket{Version: sftpProtocolVersion})
+	}()
+
+	// Hide the Close method of the reader, so that recv stays blocked.
+	client, err := NewClientPipe(struct{ io.Reader }{cr}, cw, MaxResponseTime(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.Stat("/foo"); !errors.Is(err, ErrServerUnresponsive) {
+		t.Fatalf("Stat() = %v, want ErrServerUnresponsive", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Close()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close() hung on the unresponsive server")
+	}
+}
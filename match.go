@@ -1,6 +1,7 @@
 package sftp
 
 import (
+	"os"
 	"path"
 	"strings"
 )
@@ -41,8 +42,19 @@ func Split(p string) (dir, file string) {
 // The only possible returned error is ErrBadPattern, when pattern
 // is malformed.
 func (c *Client) Glob(pattern string) (matches []string, err error) {
+	return glob(c, pattern)
+}
+
+// globFS is the part of a filesystem needed to expand a glob pattern.
+type globFS interface {
+	Stat(p string) (os.FileInfo, error)
+	Lstat(p string) (os.FileInfo, error)
+	ReadDir(p string) ([]os.FileInfo, error)
+}
+
+func glob(fsys globFS, pattern string) (matches []string, err error) {
 	if !hasMeta(pattern) {
-		file, err := c.Lstat(pattern)
+		file, err := fsys.Lstat(pattern)
 		if err != nil {
 			return nil, nil
 		}
@@ -55,7 +67,7 @@ func (c *Client) Glob(pattern string) (matches []string, err error) {
 	dir = cleanGlobPath(dir)
 
 	if !hasMeta(dir) {
-		return c.glob(dir, file, nil)
+		return globDir(fsys, dir, file, nil)
 	}
 
 	// Prevent infinite recursion. See issue 15879.
@@ -64,12 +76,12 @@ func (c *Client) Glob(pattern string) (matches []string, err error) {
 	}
 
 	var m []string
-	m, err = c.Glob(dir)
+	m, err = glob(fsys, dir)
 	if err != nil {
 		return
 	}
 	for _, d := range m {
-		matches, err = c.glob(d, file, matches)
+		matches, err = globDir(fsys, d, file, matches)
 		if err != nil {
 			return
 		}
@@ -89,20 +101,20 @@ func cleanGlobPath(path string) string {
 	}
 }
 
-// glob searches for files matching pattern in the directory dir
+// globDir searches for files matching pattern in the directory dir
 // and appends them to matches. If the directory cannot be
 // opened, it returns the existing matches. New matches are
 // added in lexicographical order.
-func (c *Client) glob(dir, pattern string, matches []string) (m []string, e error) {
+func globDir(fsys globFS, dir, pattern string, matches []string) (m []string, e error) {
 	m = matches
-	fi, err := c.Stat(dir)
+	fi, err := fsys.Stat(dir)
 	if err != nil {
 		return
 	}
 	if !fi.IsDir() {
 		return
 	}
-	names, err := c.ReadDir(dir)
+	names, err := fsys.ReadDir(dir)
 	if err != nil {
 		return
 	}
@@ -0,0 +1,1148 @@
+package sftp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/kr/fs"
+)
+
+const memFSMaxSymlinks = 40
+
+// A Fault describes a failure or delay that a MemFS injects into matching calls.
+type Fault struct {
+	// Op is the operation to match, as it appears in the Op of the returned *os.PathError or *os.LinkError,
+	// for example "open", "stat", "read", "write", "close" or "rename".
+	// An empty Op matches every operation.
+	Op string
+
+	// Path is a pattern, in the syntax of Match, for the cleaned absolute path to match.
+	// For operations on two paths, it is matched against the old name.
+	// For operations on a file, it is matched against the name the file was opened with.
+	// An empty Path matches every path.
+	Path string
+
+	// Latency delays the matching calls by the given duration.
+	Latency time.Duration
+
+	// Err, if not nil, is returned by the matching calls instead of carrying them out.
+	// It is reported the way a Client would receive it from a Server,
+	// so os.ErrNotExist and os.ErrPermission are returned as they are,
+	// and other errors as a *StatusError with the code the Server would have sent.
+	Err error
+
+	// Times limits how many calls the fault is applied to.
+	// Zero applies it to every matching call.
+	Times int
+}
+
+// MemFS is an in-memory FS, for testing code written against FS without a server.
+//
+// It returns the same errors a Client connected to a Server would:
+// *os.PathError and *os.LinkError wrapping os.ErrNotExist, os.ErrPermission,
+// or a *StatusError with the code the Server would have sent.
+// Relative paths are resolved against the root directory.
+// Permission bits are stored, but not enforced; use a Fault to simulate denied access.
+//
+// It is safe for concurrent use.
+type MemFS struct {
+	mu     sync.Mutex
+	root   *memFSNode
+	faults []*Fault
+	closed bool
+}
+
+// NewMemFS returns a MemFS holding an empty root directory.
+func NewMemFS() *MemFS {
+	now := time.Now()
+	return &MemFS{
+		root: &memFSNode{
+			mode:    os.ModeDir | 0o755,
+			atime:   now,
+			mtime:   now,
+			entries: make(map[string]*memFSNode),
+		},
+	}
+}
+
+var _ FS = (*MemFS)(nil)
+
+// InjectFault adds f to the faults of m.
+// When several faults match a call, the one added first applies.
+func (m *MemFS) InjectFault(f Fault) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.faults = append(m.faults, &f)
+}
+
+// ClearFaults removes all faults from m.
+func (m *MemFS) ClearFaults() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.faults = nil
+}
+
+// Close marks m as disconnected.
+// Every later call fails with ErrSSHFxConnectionLost, like a Client that lost its connection.
+func (m *MemFS) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.closed = true
+	return nil
+}
+
+// do applies the faults matching op on p, and then calls fn while holding the lock.
+// Errors are translated the way a Server would send them, and a Client would return them.
+func (m *MemFS) do(op, p string, fn func() error) error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return ErrSSHFxConnectionLost
+	}
+
+	f := m.fault(op, path.Join("/", p))
+	m.mu.Unlock()
+
+	if f != nil {
+		time.Sleep(f.Latency)
+		if f.Err != nil {
+			return memFSError(f.Err)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return ErrSSHFxConnectionLost
+	}
+
+	return memFSError(fn())
+}
+
+// fault returns the first fault matching op on p, and uses it up once.
+// It must be called while holding the lock.
+func (m *MemFS) fault(op, p string) *Fault {
+	for i, f := range m.faults {
+		if f.Op != "" && f.Op != op {
+			continue
+		}
+		if f.Path != "" {
+			if ok, _ := path.Match(f.Path, p); !ok {
+				continue
+			}
+		}
+
+		if f.Times > 0 {
+			if f.Times--; f.Times == 0 {
+				m.faults = append(m.faults[:i:i], m.faults[i+1:]...)
+			}
+		}
+
+		return f
+	}
+
+	return nil
+}
+
+func memFSError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, os.ErrPermission) {
+		// A Server only sees permission errors as errnos.
+		err = syscall.EACCES
+	}
+	return normaliseError(&statusFromError(0, err).StatusError)
+}
+
+// parent returns the directory that holds p, and the final element of p,
+// following any symbolic links on the way.
+// The name is empty for the root directory.
+func (m *MemFS) parent(p string, hops *int) (*memFSNode, string, error) {
+	p = path.Join("/", p)
+	if p == "/" {
+		return m.root, "", nil
+	}
+
+	dirname, name := path.Split(p)
+
+	dir, cur := m.root, "/"
+	for _, elem := range strings.Split(strings.Trim(dirname, "/"), "/") {
+		if elem == "" {
+			continue
+		}
+
+		n := dir.entries[elem]
+		if n == nil {
+			return nil, "", os.ErrNotExist
+		}
+
+		n, err := m.follow(n, cur, hops)
+		if err != nil {
+			return nil, "", err
+		}
+
+		if !n.mode.IsDir() {
+			return nil, "", syscall.ENOTDIR
+		}
+
+		dir, cur = n, path.Join(cur, elem)
+	}
+
+	return dir, name, nil
+}
+
+// lookup returns the node at p.
+// If follow is set, and the node is a symbolic link, the node it points to is returned instead.
+func (m *MemFS) lookup(p string, follow bool, hops *int) (*memFSNode, error) {
+	dir, name, err := m.parent(p, hops)
+	if err != nil {
+		return nil, err
+	}
+
+	if name == "" {
+		return dir, nil
+	}
+
+	n := dir.entries[name]
+	if n == nil {
+		return nil, os.ErrNotExist
+	}
+
+	if !follow {
+		return n, nil
+	}
+
+	return m.follow(n, path.Dir(path.Join("/", p)), hops)
+}
+
+// follow resolves n, if it is a symbolic link found in the directory dirname.
+func (m *MemFS) follow(n *memFSNode, dirname string, hops *int) (*memFSNode, error) {
+	if n.mode&os.ModeSymlink == 0 {
+		return n, nil
+	}
+
+	if *hops++; *hops > memFSMaxSymlinks {
+		return nil, errLoop
+	}
+
+	return m.lookup(path.Join(dirname, n.target), true, hops)
+}
+
+// create adds a new node named p, which must not exist yet.
+func (m *MemFS) create(p string, n *memFSNode) error {
+	var hops int
+	dir, name, err := m.parent(p, &hops)
+	if err != nil {
+		return err
+	}
+
+	if name == "" || dir.entries[name] != nil {
+		return os.ErrExist
+	}
+
+	now := time.Now()
+	n.atime, n.mtime = now, now
+
+	dir.entries[name] = n
+	dir.mtime = now
+
+	return nil
+}
+
+// Stat returns a FileInfo describing the named file.
+// If the file is a symbolic link, the returned FileInfo describes the file it points to.
+func (m *MemFS) Stat(p string) (os.FileInfo, error) {
+	var fi os.FileInfo
+	err := m.do("stat", p, func() error {
+		var hops int
+		n, err := m.lookup(p, true, &hops)
+		if err != nil {
+			return err
+		}
+
+		fi = fileInfoFromStat(n.stat(), path.Base(p))
+		return nil
+	})
+	return fi, pathError("stat", p, err)
+}
+
+// Lstat returns a FileInfo describing the named file.
+// If the file is a symbolic link, the returned FileInfo describes the link itself.
+func (m *MemFS) Lstat(p string) (os.FileInfo, error) {
+	var fi os.FileInfo
+	err := m.do("lstat", p, func() error {
+		var hops int
+		n, err := m.lookup(p, false, &hops)
+		if err != nil {
+			return err
+		}
+
+		fi = fileInfoFromStat(n.stat(), path.Base(p))
+		return nil
+	})
+	return fi, pathError("lstat", p, err)
+}
+
+// ReadDir reads the directory named by p,
+// and returns its entries sorted by name.
+func (m *MemFS) ReadDir(p string) ([]os.FileInfo, error) {
+	return m.ReadDirContext(context.Background(), p)
+}
+
+// ReadDirContext reads the directory named by p,
+// and returns its entries sorted by name.
+// It fails with the error of ctx, if ctx is already done.
+func (m *MemFS) ReadDirContext(ctx context.Context, p string) ([]os.FileInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, pathError("readdir", p, err)
+	}
+
+	var entries []os.FileInfo
+	err := m.do("readdir", p, func() error {
+		var hops int
+		dir, err := m.lookup(p, true, &hops)
+		if err != nil {
+			return err
+		}
+
+		if !dir.mode.IsDir() {
+			return syscall.ENOTDIR
+		}
+
+		for name, n := range dir.entries {
+			entries = append(entries, fileInfoFromStat(n.stat(), name))
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+		dir.atime = time.Now()
+		return nil
+	})
+	return entries, pathError("readdir", p, err)
+}
+
+// ReadLink reads the target of a symbolic link.
+func (m *MemFS) ReadLink(p string) (string, error) {
+	var target string
+	err := m.do("readlink", p, func() error {
+		var hops int
+		n, err := m.lookup(p, false, &hops)
+		if err != nil {
+			return err
+		}
+
+		if n.mode&os.ModeSymlink == 0 {
+			return syscall.EINVAL
+		}
+
+		target = n.target
+		return nil
+	})
+	return target, pathError("readlink", p, err)
+}
+
+// RealPath returns p as a cleaned absolute path.
+func (m *MemFS) RealPath(p string) (string, error) {
+	err := m.do("realpath", p, func() error { return nil })
+	if err != nil {
+		return "", pathError("realpath", p, err)
+	}
+	return path.Join("/", p), nil
+}
+
+// Getwd returns the working directory, which is always the root directory.
+func (m *MemFS) Getwd() (string, error) {
+	return m.RealPath(".")
+}
+
+// StatVFS returns the usage of m, as if it was a filesystem of 1 TiB.
+func (m *MemFS) StatVFS(p string) (*StatVFS, error) {
+	const (
+		bsize  = 4096
+		blocks = 1 << 40 / bsize
+		files  = 1 << 20
+	)
+
+	var st *StatVFS
+	err := m.do("statvfs", p, func() error {
+		var hops int
+		if _, err := m.lookup(p, true, &hops); err != nil {
+			return err
+		}
+
+		var used, nodes uint64
+		m.root.walk(func(n *memFSNode) {
+			used += (uint64(len(n.data)) + bsize - 1) / bsize
+			nodes++
+		})
+
+		st = &StatVFS{
+			Bsize:   bsize,
+			Frsize:  bsize,
+			Blocks:  blocks,
+			Bfree:   blocks - used,
+			Bavail:  blocks - used,
+			Files:   files,
+			Ffree:   files - nodes,
+			Favail:  files - nodes,
+			Namemax: 255,
+		}
+		return nil
+	})
+	return st, pathError("statvfs", p, err)
+}
+
+// Walk returns a new Walker rooted at root.
+func (m *MemFS) Walk(root string) *fs.Walker {
+	return fs.WalkFS(root, m)
+}
+
+// Glob returns the names of all files matching pattern, like Client.Glob.
+func (m *MemFS) Glob(pattern string) ([]string, error) {
+	return glob(m, pattern)
+}
+
+// Join joins any number of path elements into a single path.
+func (m *MemFS) Join(elem ...string) string { return path.Join(elem...) }
+
+// Open opens the named file for reading.
+func (m *MemFS) Open(path string) (FileHandle, error) {
+	return m.OpenFile(path, os.O_RDONLY)
+}
+
+// Create creates the named file, or truncates it if it already exists.
+func (m *MemFS) Create(path string) (FileHandle, error) {
+	return m.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC)
+}
+
+// OpenFile opens the named file with the given flags, like Client.OpenFile.
+func (m *MemFS) OpenFile(path string, f int) (FileHandle, error) {
+	return m.openFile(path, f, 0o644)
+}
+
+// OpenFileMode opens the named file with the given flags, like Client.OpenFileMode.
+// The permissions are only applied if the file is created.
+func (m *MemFS) OpenFileMode(path string, f int, perm os.FileMode) (FileHandle, error) {
+	return m.openFile(path, f, perm&os.ModePerm)
+}
+
+func (m *MemFS) openFile(p string, flag int, perm os.FileMode) (FileHandle, error) {
+	file := &memFSFile{
+		m:    m,
+		path: p,
+	}
+
+	switch flag & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR) {
+	case os.O_RDONLY:
+		file.read = true
+	case os.O_WRONLY:
+		file.write = true
+	case os.O_RDWR:
+		file.read, file.write = true, true
+	}
+	file.append = flag&os.O_APPEND != 0
+
+	err := m.do("open", p, func() error {
+		var hops int
+		n, err := m.open(p, flag, perm, &hops)
+		if err != nil {
+			return err
+		}
+
+		if n.mode.IsDir() && file.write {
+			return syscall.EISDIR
+		}
+
+		if flag&os.O_TRUNC != 0 && file.write {
+			n.data = nil
+			n.mtime = time.Now()
+		}
+
+		file.node = n
+		return nil
+	})
+	if err != nil {
+		return nil, pathError("open", p, err)
+	}
+
+	return file, nil
+}
+
+// open returns the node at p, creating it as requested by flag.
+func (m *MemFS) open(p string, flag int, perm os.FileMode, hops *int) (*memFSNode, error) {
+	excl := flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL
+
+	n, err := m.lookup(p, false, hops)
+	if errors.Is(err, os.ErrNotExist) && flag&os.O_CREATE != 0 {
+		n = &memFSNode{mode: perm}
+		return n, m.create(p, n)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if excl {
+		return nil, os.ErrExist
+	}
+
+	if n.mode&os.ModeSymlink == 0 {
+		return n, nil
+	}
+
+	// You can create files through dangling symlinks.
+	if *hops++; *hops > memFSMaxSymlinks {
+		return nil, errLoop
+	}
+
+	return m.open(path.Join(path.Dir(path.Join("/", p)), n.target), flag, perm, hops)
+}
+
+// Mkdir creates the named directory, with permissions 0o755.
+func (m *MemFS) Mkdir(path string) error {
+	return m.MkdirMode(path, 0o755)
+}
+
+// MkdirMode creates the named directory, with the given permissions.
+func (m *MemFS) MkdirMode(path string, perm os.FileMode) error {
+	return pathError("mkdir", path, m.do("mkdir", path, func() error {
+		return m.create(path, newMemFSDir(perm))
+	}))
+}
+
+// MkdirAll creates a directory named path, along with any necessary parents.
+func (m *MemFS) MkdirAll(path string) error {
+	return m.MkdirAllMode(path, 0o755)
+}
+
+// MkdirAllMode creates a directory named path, along with any necessary parents,
+// applying the given permissions to every directory it creates.
+func (m *MemFS) MkdirAllMode(p string, perm os.FileMode) error {
+	return pathError("mkdir", p, m.do("mkdir", p, func() error {
+		dir, cur := m.root, "/"
+		for _, elem := range strings.Split(strings.Trim(path.Join("/", p), "/"), "/") {
+			if elem == "" {
+				continue
+			}
+			cur = path.Join(cur, elem)
+
+			n := dir.entries[elem]
+			if n == nil {
+				n = newMemFSDir(perm)
+				if err := m.create(cur, n); err != nil {
+					return err
+				}
+			}
+
+			var hops int
+			n, err := m.follow(n, path.Dir(cur), &hops)
+			if err != nil {
+				return err
+			}
+
+			if !n.mode.IsDir() {
+				return syscall.ENOTDIR
+			}
+
+			dir = n
+		}
+		return nil
+	}))
+}
+
+// Link creates a hard link newname, pointing to the same file as oldname.
+func (m *MemFS) Link(oldname, newname string) error {
+	return linkError("link", oldname, newname, m.do("link", oldname, func() error {
+		var hops int
+		n, err := m.lookup(oldname, false, &hops)
+		if err != nil {
+			return err
+		}
+
+		if n.mode.IsDir() {
+			return syscall.EPERM
+		}
+
+		return m.link(newname, n)
+	}))
+}
+
+// link adds n under the name p, which must not exist yet.
+func (m *MemFS) link(p string, n *memFSNode) error {
+	var hops int
+	dir, name, err := m.parent(p, &hops)
+	if err != nil {
+		return err
+	}
+
+	if name == "" || dir.entries[name] != nil {
+		return os.ErrExist
+	}
+
+	dir.entries[name] = n
+	dir.mtime = time.Now()
+
+	return nil
+}
+
+// Symlink creates a symbolic link newname, pointing to oldname.
+func (m *MemFS) Symlink(oldname, newname string) error {
+	return linkError("symlink", oldname, newname, m.do("symlink", oldname, func() error {
+		return m.create(newname, &memFSNode{
+			mode:   os.ModeSymlink | 0o777,
+			target: oldname,
+		})
+	}))
+}
+
+// Rename renames oldname to newname, replacing newname if it exists, like a Server does.
+func (m *MemFS) Rename(oldname, newname string) error {
+	return linkError("rename", oldname, newname, m.do("rename", oldname, func() error {
+		return m.rename(oldname, newname)
+	}))
+}
+
+// PosixRename renames oldname to newname, replacing newname if it exists.
+func (m *MemFS) PosixRename(oldname, newname string) error {
+	return linkError("rename", oldname, newname, m.do("rename", oldname, func() error {
+		return m.rename(oldname, newname)
+	}))
+}
+
+func (m *MemFS) rename(oldname, newname string) error {
+	var hops int
+	odir, oname, err := m.parent(oldname, &hops)
+	if err != nil {
+		return err
+	}
+
+	n := odir.entries[oname]
+	if oname == "" || n == nil {
+		return os.ErrNotExist
+	}
+
+	ndir, nname, err := m.parent(newname, &hops)
+	if err != nil {
+		return err
+	}
+	if nname == "" {
+		return syscall.EBUSY
+	}
+
+	if target := ndir.entries[nname]; target != nil {
+		if target == n {
+			return nil
+		}
+
+		switch {
+		case n.mode.IsDir() && !target.mode.IsDir():
+			return syscall.ENOTDIR
+		case !n.mode.IsDir() && target.mode.IsDir():
+			return syscall.EISDIR
+		case target.mode.IsDir() && len(target.entries) > 0:
+			return errNotEmpty
+		}
+	}
+
+	if n.mode.IsDir() && n.contains(ndir) {
+		return syscall.EINVAL
+	}
+
+	delete(odir.entries, oname)
+	ndir.entries[nname] = n
+
+	now := time.Now()
+	odir.mtime, ndir.mtime = now, now
+
+	return nil
+}
+
+// Remove removes the named file or empty directory.
+func (m *MemFS) Remove(path string) error {
+	return pathError("remove", path, m.do("remove", path, func() error {
+		return m.remove(path, false)
+	}))
+}
+
+// RemoveDirectory removes the named empty directory.
+func (m *MemFS) RemoveDirectory(path string) error {
+	return pathError("remove", path, m.do("remove", path, func() error {
+		return m.remove(path, true)
+	}))
+}
+
+func (m *MemFS) remove(p string, dirOnly bool) error {
+	var hops int
+	dir, name, err := m.parent(p, &hops)
+	if err != nil {
+		return err
+	}
+
+	if name == "" {
+		return syscall.EBUSY
+	}
+
+	n := dir.entries[name]
+	switch {
+	case n == nil:
+		return os.ErrNotExist
+	case dirOnly && !n.mode.IsDir():
+		return syscall.ENOTDIR
+	case n.mode.IsDir() && len(n.entries) > 0:
+		return errNotEmpty
+	}
+
+	delete(dir.entries, name)
+	dir.mtime = time.Now()
+
+	return nil
+}
+
+// RemoveAll removes path, and everything it contains.
+// An error is returned if path does not exist.
+func (m *MemFS) RemoveAll(path string) error {
+	return pathError("remove", path, m.do("remove", path, func() error {
+		var hops int
+		dir, name, err := m.parent(path, &hops)
+		if err != nil {
+			return err
+		}
+
+		if name == "" {
+			return syscall.EBUSY
+		}
+
+		if dir.entries[name] == nil {
+			return os.ErrNotExist
+		}
+
+		delete(dir.entries, name)
+		dir.mtime = time.Now()
+
+		return nil
+	}))
+}
+
+// setstat calls fn with the node at p, following symbolic links.
+func (m *MemFS) setstat(op, p string, fn func(n *memFSNode) error) error {
+	return pathError(op, p, m.do(op, p, func() error {
+		var hops int
+		n, err := m.lookup(p, true, &hops)
+		if err != nil {
+			return err
+		}
+		return fn(n)
+	}))
+}
+
+// Chtimes changes the access and modification times of the named file.
+func (m *MemFS) Chtimes(path string, atime time.Time, mtime time.Time) error {
+	return m.setstat("chtimes", path, func(n *memFSNode) error {
+		n.atime, n.mtime = atime, mtime
+		return nil
+	})
+}
+
+// Chown changes the user and group owners of the named file.
+func (m *MemFS) Chown(path string, uid, gid int) error {
+	return m.setstat("chown", path, func(n *memFSNode) error {
+		n.chown(uid, gid)
+		return nil
+	})
+}
+
+// Chmod changes the permissions of the named file.
+func (m *MemFS) Chmod(path string, mode os.FileMode) error {
+	return m.setstat("chmod", path, func(n *memFSNode) error {
+		n.chmod(mode)
+		return nil
+	})
+}
+
+// Truncate sets the size of the named file.
+func (m *MemFS) Truncate(path string, size int64) error {
+	return m.setstat("truncate", path, func(n *memFSNode) error {
+		return n.truncate(size)
+	})
+}
+
+type memFSNode struct {
+	mode         os.FileMode
+	uid, gid     uint32
+	atime, mtime time.Time
+
+	data    []byte                // regular files
+	target  string                // symbolic links
+	entries map[string]*memFSNode // directories
+}
+
+func newMemFSDir(perm os.FileMode) *memFSNode {
+	return &memFSNode{
+		mode:    os.ModeDir | perm&os.ModePerm,
+		entries: make(map[string]*memFSNode),
+	}
+}
+
+func (n *memFSNode) stat() *FileStat {
+	size := len(n.data)
+	if n.mode&os.ModeSymlink != 0 {
+		size = len(n.target)
+	}
+
+	return &FileStat{
+		Size:  uint64(size),
+		Mode:  fromFileMode(n.mode),
+		Mtime: uint32(n.mtime.Unix()),
+		Atime: uint32(n.atime.Unix()),
+		UID:   n.uid,
+		GID:   n.gid,
+	}
+}
+
+func (n *memFSNode) chmod(mode os.FileMode) {
+	const mask = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+	n.mode = n.mode&^mask | mode&mask
+}
+
+func (n *memFSNode) chown(uid, gid int) {
+	n.uid, n.gid = uint32(uid), uint32(gid)
+}
+
+func (n *memFSNode) truncate(size int64) error {
+	switch {
+	case n.mode.IsDir():
+		return syscall.EISDIR
+	case size < 0:
+		return syscall.EINVAL
+	}
+
+	if size <= int64(len(n.data)) {
+		n.data = n.data[:size]
+	} else {
+		n.data = append(n.data, make([]byte, size-int64(len(n.data)))...)
+	}
+	n.mtime = time.Now()
+
+	return nil
+}
+
+// contains reports whether other is n, or somewhere below n.
+func (n *memFSNode) contains(other *memFSNode) bool {
+	if n == other {
+		return true
+	}
+	for _, child := range n.entries {
+		if child.mode.IsDir() && child.contains(other) {
+			return true
+		}
+	}
+	return false
+}
+
+// walk calls fn for n, and every node below it.
+func (n *memFSNode) walk(fn func(*memFSNode)) {
+	fn(n)
+	for _, child := range n.entries {
+		child.walk(fn)
+	}
+}
+
+// memFSFile is a file opened from a MemFS.
+type memFSFile struct {
+	m    *MemFS
+	node *memFSNode
+	path string
+
+	read, write, append bool
+
+	mu     sync.Mutex
+	offset int64
+	closed bool
+}
+
+// do calls fn with the node of the file, like MemFS.do.
+func (f *memFSFile) do(op string, fn func(n *memFSNode) error) error {
+	if f.closed {
+		return pathError(op, f.path, os.ErrClosed)
+	}
+
+	return pathError(op, f.path, f.m.do(op, f.path, func() error {
+		return fn(f.node)
+	}))
+}
+
+// Name returns the name of the file as presented to Open.
+func (f *memFSFile) Name() string {
+	return f.path
+}
+
+// Close closes the file.
+func (f *memFSFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return pathError("close", f.path, os.ErrClosed)
+	}
+
+	// Like a File, the file is closed even if the server reports an error.
+	f.closed = true
+
+	return pathError("close", f.path, f.m.do("close", f.path, func() error { return nil }))
+}
+
+func (f *memFSFile) readAt(n *memFSNode, b []byte, off int64) (int, error) {
+	switch {
+	case !f.read:
+		return 0, EBADF
+	case n.mode.IsDir():
+		return 0, syscall.EISDIR
+	case off < 0:
+		return 0, syscall.EINVAL
+	case off >= int64(len(n.data)):
+		return 0, io.EOF
+	}
+
+	n.atime = time.Now()
+
+	read := copy(b, n.data[off:])
+	if read < len(b) {
+		return read, io.EOF
+	}
+	return read, nil
+}
+
+func (f *memFSFile) writeAt(n *memFSNode, b []byte, off int64) (int, error) {
+	if !f.write {
+		return 0, EBADF
+	}
+	if off < 0 {
+		return 0, syscall.EINVAL
+	}
+
+	if end := off + int64(len(b)); end > int64(len(n.data)) {
+		n.data = append(n.data, make([]byte, end-int64(len(n.data)))...)
+	}
+	n.mtime = time.Now()
+
+	return copy(n.data[off:], b), nil
+}
+
+// Read reads up to len(b) bytes from the file.
+func (f *memFSFile) Read(b []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var read int
+	err := f.do("read", func(n *memFSNode) (err error) {
+		read, err = f.readAt(n, b, f.offset)
+		if read > 0 && err == io.EOF {
+			err = nil
+		}
+		return err
+	})
+	f.offset += int64(read)
+	return read, err
+}
+
+// ReadAt reads up to len(b) bytes from the file at the offset off.
+func (f *memFSFile) ReadAt(b []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var read int
+	err := f.do("read", func(n *memFSNode) (err error) {
+		read, err = f.readAt(n, b, off)
+		return err
+	})
+	return read, err
+}
+
+// Write writes len(b) bytes to the file.
+func (f *memFSFile) Write(b []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var written int
+	err := f.do("write", func(n *memFSNode) (err error) {
+		if f.append {
+			f.offset = int64(len(n.data))
+		}
+		written, err = f.writeAt(n, b, f.offset)
+		return err
+	})
+	f.offset += int64(written)
+	return written, err
+}
+
+// WriteAt writes len(b) bytes to the file at the offset off.
+func (f *memFSFile) WriteAt(b []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var written int
+	err := f.do("write", func(n *memFSNode) (err error) {
+		written, err = f.writeAt(n, b, off)
+		return err
+	})
+	return written, err
+}
+
+// WriteTo writes the rest of the file to w.
+func (f *memFSFile) WriteTo(w io.Writer) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var data []byte
+	err := f.do("read", func(n *memFSNode) error {
+		if f.offset >= int64(len(n.data)) {
+			_, err := f.readAt(n, nil, f.offset)
+			if err == io.EOF {
+				err = nil
+			}
+			return err
+		}
+
+		b := make([]byte, int64(len(n.data))-f.offset)
+		_, err := f.readAt(n, b, f.offset)
+		data = b
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	written, err := w.Write(data)
+	f.offset += int64(written)
+	return int64(written), err
+}
+
+// ReadFrom writes everything read from r to the file.
+func (f *memFSFile) ReadFrom(r io.Reader) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var read int64
+	b := make([]byte, 32*1024)
+	for {
+		n, rerr := r.Read(b)
+		if n > 0 {
+			err := f.do("write", func(node *memFSNode) error {
+				if f.append {
+					f.offset = int64(len(node.data))
+				}
+				written, err := f.writeAt(node, b[:n], f.offset)
+				f.offset += int64(written)
+				read += int64(written)
+				return err
+			})
+			if err != nil {
+				return read, err
+			}
+		}
+
+		if rerr == io.EOF {
+			return read, nil
+		}
+		if rerr != nil {
+			return read, rerr
+		}
+	}
+}
+
+// Seek sets the offset for the next Read or Write.
+func (f *memFSFile) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return 0, pathError("seek", f.path, os.ErrClosed)
+	}
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		err := f.do("seek", func(n *memFSNode) error {
+			offset += int64(len(n.data))
+			return nil
+		})
+		if err != nil {
+			return f.offset, err
+		}
+	default:
+		return f.offset, pathError("seek", f.path, unimplementedSeekWhence(whence))
+	}
+
+	if offset < 0 {
+		return f.offset, pathError("seek", f.path, os.ErrInvalid)
+	}
+
+	f.offset = offset
+	return f.offset, nil
+}
+
+// Stat returns the FileInfo describing the file.
+func (f *memFSFile) Stat() (os.FileInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var fi os.FileInfo
+	err := f.do("stat", func(n *memFSNode) error {
+		fi = fileInfoFromStat(n.stat(), path.Base(f.path))
+		return nil
+	})
+	return fi, err
+}
+
+// Chmod changes the permissions of the file.
+func (f *memFSFile) Chmod(mode os.FileMode) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.do("chmod", func(n *memFSNode) error {
+		n.chmod(mode)
+		return nil
+	})
+}
+
+// Chown changes the user and group owners of the file.
+func (f *memFSFile) Chown(uid, gid int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.do("chown", func(n *memFSNode) error {
+		n.chown(uid, gid)
+		return nil
+	})
+}
+
+// Truncate sets the size of the file.
+func (f *memFSFile) Truncate(size int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.do("truncate", func(n *memFSNode) error {
+		return n.truncate(size)
+	})
+}
+
+// Sync does nothing, as a MemFS has nothing to flush, but still applies faults for "sync".
+func (f *memFSFile) Sync() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.do("sync", func(*memFSNode) error { return nil })
+}
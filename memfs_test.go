@@ -0,0 +1,236 @@
+package sftp
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemFS(t *testing.T) {
+	m := NewMemFS()
+
+	require.NoError(t, m.MkdirAll("/a/b/c"))
+	require.NoError(t, m.MkdirAll("a/b")) // already exists
+
+	f, err := m.Create("/a/b/file")
+	require.NoError(t, err)
+
+	_, err = f.Write([]byte("hello world"))
+	require.NoError(t, err)
+
+	off, err := f.Seek(6, io.SeekStart)
+	require.NoError(t, err)
+	assert.EqualValues(t, 6, off)
+
+	buf := make([]byte, 16)
+	n, err := f.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "world", string(buf[:n]))
+
+	_, err = f.Read(buf)
+	assert.Equal(t, io.EOF, err)
+
+	_, err = f.WriteAt([]byte("W"), 6)
+	require.NoError(t, err)
+	require.NoError(t, f.Truncate(7))
+	require.NoError(t, f.Close())
+
+	assert.ErrorIs(t, f.Close(), os.ErrClosed)
+
+	require.NoError(t, m.Symlink("b/file", "/a/link"))
+	require.NoError(t, m.Link("/a/b/file", "/a/hard"))
+
+	for _, name := range []string{"/a/b/file", "/a/link", "/a/hard"} {
+		f, err := m.Open(name)
+		require.NoError(t, err)
+
+		var b []byte
+		b, err = io.ReadAll(f)
+		require.NoError(t, err)
+		assert.Equal(t, "hello W", string(b), name)
+		require.NoError(t, f.Close())
+	}
+
+	target, err := m.ReadLink("/a/link")
+	require.NoError(t, err)
+	assert.Equal(t, "b/file", target)
+
+	fi, err := m.Lstat("/a/link")
+	require.NoError(t, err)
+	assert.True(t, fi.Mode()&os.ModeSymlink != 0)
+
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	require.NoError(t, m.Chmod("/a/link", 0o600))
+	require.NoError(t, m.Chtimes("/a/b/file", mtime, mtime))
+
+	fi, err = m.Stat("/a/b/file")
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), fi.Mode())
+	assert.True(t, mtime.Equal(fi.ModTime()))
+	assert.EqualValues(t, 7, fi.Size())
+	assert.IsType(t, &FileStat{}, fi.Sys())
+
+	require.NoError(t, m.Rename("/a/b", "/a/d"))
+
+	entries, err := m.ReadDir("/a/d")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "c", entries[0].Name())
+	assert.True(t, entries[0].IsDir())
+	assert.Equal(t, "file", entries[1].Name())
+
+	matches, err := m.Glob("/a/*")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/a/d", "/a/hard", "/a/link"}, matches)
+
+	var walked []string
+	for w := m.Walk("/a"); w.Step(); {
+		require.NoError(t, w.Err())
+		walked = append(walked, w.Path())
+	}
+	assert.Equal(t, []string{"/a", "/a/d", "/a/d/c", "/a/d/file", "/a/hard", "/a/link"}, walked)
+
+	require.NoError(t, m.RemoveAll("/a/d"))
+	_, err = m.Stat("/a/link")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	f, err = m.Open("/a/hard")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("x"))
+	assert.Error(t, err, "write to a read-only file")
+	require.NoError(t, f.Close())
+}
+
+// TestMemFSErrorsMatchClient checks that MemFS fails in the same way as a Client connected to a Server.
+func TestMemFSErrorsMatchClient(t *testing.T) {
+	skipIfWindows(t)
+
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	setup := func(fsys FS, dir string) {
+		require.NoError(t, fsys.Mkdir(path.Join(dir, "dir")))
+		f, err := fsys.Create(path.Join(dir, "dir", "file"))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+
+	tests := []struct {
+		name string
+		op   func(fsys FS, dir string) error
+	}{
+		{"stat missing", func(fsys FS, dir string) error {
+			_, err := fsys.Stat(path.Join(dir, "missing"))
+			return err
+		}},
+		{"open missing", func(fsys FS, dir string) error {
+			_, err := fsys.Open(path.Join(dir, "missing"))
+			return err
+		}},
+		{"create exclusive", func(fsys FS, dir string) error {
+			_, err := fsys.OpenFile(path.Join(dir, "dir", "file"), os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+			return err
+		}},
+		{"mkdir exists", func(fsys FS, dir string) error {
+			return fsys.Mkdir(path.Join(dir, "dir"))
+		}},
+		{"mkdir missing parent", func(fsys FS, dir string) error {
+			return fsys.Mkdir(path.Join(dir, "missing", "dir"))
+		}},
+		{"remove non-empty", func(fsys FS, dir string) error {
+			return fsys.Remove(path.Join(dir, "dir"))
+		}},
+		{"readdir file", func(fsys FS, dir string) error {
+			_, err := fsys.ReadDir(path.Join(dir, "dir", "file"))
+			return err
+		}},
+		{"rename missing", func(fsys FS, dir string) error {
+			return fsys.Rename(path.Join(dir, "missing"), path.Join(dir, "other"))
+		}},
+		{"chmod missing", func(fsys FS, dir string) error {
+			return fsys.Chmod(path.Join(dir, "missing"), 0o600)
+		}},
+		{"read past end", func(fsys FS, dir string) error {
+			f, err := fsys.Open(path.Join(dir, "dir", "file"))
+			require.NoError(t, err)
+			defer f.Close()
+
+			_, err = f.Read(make([]byte, 1))
+			return err
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			setup(NewClientFS(client), dir)
+			want := tt.op(NewClientFS(client), dir)
+
+			m := NewMemFS()
+			setup(m, "/")
+			got := tt.op(m, "/")
+
+			require.Error(t, want)
+			require.Error(t, got)
+
+			assert.IsType(t, want, got)
+
+			var wantPath, gotPath *os.PathError
+			if errors.As(want, &wantPath) {
+				require.ErrorAs(t, got, &gotPath)
+				assert.Equal(t, wantPath.Op, gotPath.Op)
+			}
+
+			var wantStatus, gotStatus *StatusError
+			if errors.As(want, &wantStatus) {
+				require.ErrorAs(t, got, &gotStatus)
+				assert.Equal(t, wantStatus.Code, gotStatus.Code)
+			}
+		})
+	}
+}
+
+func TestMemFSFaults(t *testing.T) {
+	m := NewMemFS()
+	require.NoError(t, m.Mkdir("/dir"))
+
+	m.InjectFault(Fault{Op: "stat", Path: "/dir/*", Err: os.ErrPermission, Times: 1})
+
+	_, err := m.Stat("/dir")
+	assert.NoError(t, err, "path does not match")
+
+	_, err = m.Stat("/dir/x")
+	var pathErr *os.PathError
+	require.ErrorAs(t, err, &pathErr)
+	assert.Equal(t, "/dir/x", pathErr.Path)
+	assert.True(t, os.IsPermission(err))
+
+	_, err = m.Stat("/dir/x")
+	assert.ErrorIs(t, err, os.ErrNotExist, "fault is used up")
+
+	m.InjectFault(Fault{Op: "write", Err: ErrSSHFxFailure})
+	f, err := m.Create("/dir/file")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("data"))
+	assert.ErrorIs(t, err, ErrSSHFxFailure)
+	require.NoError(t, f.Close())
+
+	m.ClearFaults()
+
+	const latency = 20 * time.Millisecond
+	m.InjectFault(Fault{Op: "mkdir", Latency: latency})
+	start := time.Now()
+	require.NoError(t, m.Mkdir("/slow"))
+	assert.GreaterOrEqual(t, time.Since(start), latency)
+
+	require.NoError(t, m.Close())
+	_, err = m.Stat("/dir")
+	assert.ErrorIs(t, err, ErrSSHFxConnectionLost)
+}
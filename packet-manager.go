@@ -2,8 +2,10 @@ package sftp
 
 import (
 	"encoding"
+	"hash/fnv"
 	"sort"
 	"sync"
+	"sync/atomic"
 )
 
 // The goal of the packetManager is to keep the outgoing packets in the same
@@ -18,6 +20,9 @@ type packetManager struct {
 	sender      packetSender // connection object
 	working     *sync.WaitGroup
 	packetCount uint32
+	pending     atomic.Int64 // requests received and not answered yet
+	workers     int          // number of workers for reads and writes, or for handles
+	perHandle   bool         // requests on a handle are handled in order, by one worker
 	// it is not nil if the allocator is enabled
 	alloc *allocator
 }
@@ -35,6 +40,7 @@ func newPktMgr(sender packetSender) *packetManager {
 		outgoing:  make([]orderedPacket, 0, SftpServerWorkerCount),
 		sender:    sender,
 		working:   &sync.WaitGroup{},
+		workers:   SftpServerWorkerCount,
 	}
 	go s.controller()
 	return s
@@ -60,6 +66,7 @@ type orderedRequest struct {
 }
 
 func (s *packetManager) newOrderedRequest(p requestPacket) orderedRequest {
+	s.pending.Add(1)
 	return orderedRequest{requestPacket: p, orderid: s.newOrderID()}
 }
 func (p orderedRequest) orderID() uint32       { return p.orderid }
@@ -114,9 +121,13 @@ func (s *packetManager) close() {
 // maximizing throughput of file transfers.
 func (s *packetManager) workerChan(runWorker func(chan orderedRequest),
 ) chan orderedRequest {
+	if s.perHandle {
+		return s.handleWorkerChan(runWorker)
+	}
+
 	// multiple workers for faster read/writes
 	rwChan := make(chan orderedRequest, SftpServerWorkerCount)
-	for i := 0; i < SftpServerWorkerCount; i++ {
+	for i := 0; i < s.workers; i++ {
 		runWorker(rwChan)
 	}
 
@@ -127,7 +138,7 @@ func (s *packetManager) workerChan(runWorker func(chan orderedRequest),
 	pktChan := make(chan orderedRequest, SftpServerWorkerCount)
 	go func() {
 		for pkt := range pktChan {
-			switch pkt.requestPacket.(type) {
+			switch p := pkt.requestPacket.(type) {
 			case *sshFxpReadPacket, *sshFxpWritePacket:
 				s.incomingPacket(pkt)
 				rwChan <- pkt
@@ -136,6 +147,11 @@ func (s *packetManager) workerChan(runWorker func(chan orderedRequest),
 				// wait for reads/writes to finish when file is closed
 				// incomingPacket() call must occur after this
 				s.working.Wait()
+			case *sshFxpExtendedPacket:
+				// likewise, fsync must only be acknowledged after the writes before it
+				if _, ok := p.SpecificPacket.(*sshFxpExtendedPacketFsync); ok {
+					s.working.Wait()
+				}
 			}
 			s.incomingPacket(pkt)
 			// all non-RW use sequential cmdChan
@@ -149,6 +165,63 @@ func (s *packetManager) workerChan(runWorker func(chan orderedRequest),
 	return pktChan
 }
 
+// handleWorkerChan is workerChan for requests handled in order per handle:
+// every handle is assigned a worker, that handles all the requests on it one after the other,
+// while the requests on handles assigned other workers run in parallel.
+func (s *packetManager) handleWorkerChan(runWorker func(chan orderedRequest),
+) chan orderedRequest {
+	handleChans := make([]chan orderedRequest, s.workers)
+	for i := range handleChans {
+		handleChans[i] = make(chan orderedRequest, SftpServerWorkerCount)
+		runWorker(handleChans[i])
+	}
+
+	// single worker to enforce sequential processing of everything else
+	cmdChan := make(chan orderedRequest)
+	runWorker(cmdChan)
+
+	pktChan := make(chan orderedRequest, SftpServerWorkerCount)
+	go func() {
+		for pkt := range pktChan {
+			s.incomingPacket(pkt)
+
+			if handle, ok := packetHandle(pkt.requestPacket); ok {
+				h := fnv.New32a()
+				h.Write([]byte(handle))
+				handleChans[h.Sum32()%uint32(len(handleChans))] <- pkt
+				continue
+			}
+
+			cmdChan <- pkt
+		}
+		for _, ch := range handleChans {
+			close(ch)
+		}
+		close(cmdChan)
+		s.close()
+	}()
+
+	return pktChan
+}
+
+// packetHandle returns the handle pkt is a request on, if any.
+func packetHandle(pkt requestPacket) (string, bool) {
+	if p, ok := pkt.(*sshFxpExtendedPacket); ok {
+		if p.SpecificPacket == nil {
+			return "", false
+		}
+		pkt = p.SpecificPacket
+	}
+
+	switch p := pkt.(type) {
+	case hasHandle:
+		return p.getHandle(), true
+	case *sshFxpExtendedPacketFsync:
+		return p.Handle, true
+	}
+	return "", false
+}
+
 // process packets
 func (s *packetManager) controller() {
 	for {
@@ -183,6 +256,7 @@ func (s *packetManager) maybeSendPackets() {
 		if in.orderID() == out.orderID() {
 			debug("Sending packet: %v", out.id())
 			s.sender.sendPacket(out.(encoding.BinaryMarshaler))
+			s.pending.Add(-1)
 			if s.alloc != nil {
 				// mark for reuse the slices allocated for this request
 				s.alloc.ReleasePages(in.orderID())
@@ -3,7 +3,9 @@ package sftp
 import (
 	"encoding"
 	"fmt"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -113,6 +115,59 @@ func TestPacketManager(t *testing.T) {
 	s.close()
 }
 
+// fsync must not be handled while a write before it is still running.
+func TestPacketManagerFsyncWaitsForWrites(t *testing.T) {
+	sender := newTestSender()
+	s := newPktMgr(sender)
+
+	release := make(chan struct{})
+
+	var mu sync.Mutex
+	var handled []string
+
+	runWorker := func(ch chan orderedRequest) {
+		go func() {
+			for pkt := range ch {
+				name := "fsync"
+				if _, ok := pkt.requestPacket.(*sshFxpWritePacket); ok {
+					name = "write"
+					<-release
+				}
+
+				mu.Lock()
+				handled = append(handled, name)
+				mu.Unlock()
+
+				s.readyPacket(s.newOrderedResponse(fake(pkt.id(), pkt.orderID()), pkt.orderID()))
+			}
+		}()
+	}
+
+	pktChan := s.workerChan(runWorker)
+	pktChan <- s.newOrderedRequest(&sshFxpWritePacket{ID: 1, Handle: "1"})
+	pktChan <- s.newOrderedRequest(&sshFxpExtendedPacket{
+		ID:              2,
+		ExtendedRequest: "fsync@openssh.com",
+		SpecificPacket:  &sshFxpExtendedPacketFsync{ID: 2, Handle: "1"},
+	})
+
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	assert.Empty(t, handled, "fsync was handled before the write finished")
+	mu.Unlock()
+
+	close(release)
+	for range 2 {
+		<-sender.sent
+	}
+
+	mu.Lock()
+	assert.Equal(t, []string{"write", "fsync"}, handled)
+	mu.Unlock()
+
+	close(pktChan)
+}
+
 func (p sshFxpRemovePacket) String() string {
 	return fmt.Sprintf("RmPkt:%d", p.ID)
 }
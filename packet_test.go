@@ -410,6 +410,24 @@ func TestSendPacket(t *testing.T) {
 				0x0, 0x0, 0x1, 0xed,
 			},
 		},
+		{
+			packet: &sshFxpMkdirPacket{
+				ID:    4,
+				Path:  "/foo",
+				Flags: sshFileXferAttrPermissions,
+				Attrs: &FileStat{
+					Mode: 0o700,
+				},
+			},
+			want: []byte{
+				0x0, 0x0, 0x0, 0x15,
+				0xe,
+				0x0, 0x0, 0x0, 0x4,
+				0x0, 0x0, 0x0, 0x4, '/', 'f', 'o', 'o',
+				0x0, 0x0, 0x0, 0x4,
+				0x0, 0x0, 0x1, 0xc0,
+			},
+		},
 		{
 			packet: &sshFxpWritePacket{
 				ID:     124,
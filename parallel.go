@@ -0,0 +1,220 @@
+package sftp
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// DownloadTo writes the whole content of the File to dst, and returns the number of bytes written.
+//
+// Unlike WriteTo, it does not need to put the chunks back in order:
+// each chunk is written to dst at its own offset as soon as it arrives,
+// so memory use is bounded by the concurrency, no matter the latency.
+// It always starts at the beginning of the File, and does not use or change the File offset.
+//
+// If an error occurs, the count returned is the length of the data from the beginning of dst
+// that was written without a gap, though later chunks might have been written as well.
+func (f *File) DownloadTo(dst io.WriterAt) (int64, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if f.handle == "" {
+		return 0, f.wrapErr("read", os.ErrClosed)
+	}
+
+	f.flushWrites()
+
+	concurrency := f.c.maxConcurrentRequests
+	if f.c.disableConcurrentReads {
+		concurrency = 1
+	}
+
+	c := &chunkCopy{
+		concurrency: concurrency,
+		chunkSize:   f.c.maxPacket,
+		end:         -1,
+		readAt: func(b []byte, off int64) (int, error) {
+			return f.readChunkAt(nil, b, off)
+		},
+		writeAt: dst.WriteAt,
+	}
+
+	n, err := c.run()
+	if c.writeFailed {
+		return n, err
+	}
+	return n, f.wrapErr("read", err)
+}
+
+// UploadFrom writes the first size bytes of src to the File, at the same offsets,
+// and returns the number of bytes written.
+//
+// Ranges of src are read concurrently, and each is written to the File as soon as it has been read,
+// so memory use is bounded by the concurrency.
+// It does not use or change the File offset.
+//
+// If an error occurs, the count returned is the length of the data from the beginning of the File
+// that was written without a gap.
+// If writing to the File failed, the error is a *PartialWriteError.
+func (f *File) UploadFrom(src io.ReaderAt, size int64) (int64, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if f.handle == "" {
+		return 0, f.wrapErr("write", os.ErrClosed)
+	}
+
+	if err := f.flushWrites(); err != nil {
+		return 0, f.wrapErr("write", err)
+	}
+	defer f.cache.invalidate(0, -1)
+
+	concurrency := f.c.maxConcurrentRequests
+	if !f.c.useConcurrentWrites {
+		concurrency = 1
+	}
+
+	c := &chunkCopy{
+		concurrency: concurrency,
+		chunkSize:   f.c.maxPacket,
+		end:         size,
+		readAt: func(b []byte, off int64) (int, error) {
+			n, err := src.ReadAt(b, off)
+			if err == io.EOF && n < len(b) {
+				// The source is shorter than we were told.
+				err = io.ErrUnexpectedEOF
+			}
+			return n, err
+		},
+		writeAt: func(b []byte, off int64) (int, error) {
+			return f.writeChunkAt(nil, b, off)
+		},
+	}
+
+	n, err := c.run()
+	if err != nil && c.writeFailed {
+		return n, f.wrapErr("write", f.partialWrite(0, n, c.ackedEnd, err))
+	}
+	return n, err
+}
+
+// chunkCopy copies data between two random-access endpoints, starting at offset 0,
+// with several chunks in flight, each written at the same offset it was read from.
+// It stops at end, if it is not negative, or at the end of the source, whichever comes first.
+type chunkCopy struct {
+	concurrency int
+	chunkSize   int
+
+	readAt  func(b []byte, off int64) (int, error)
+	writeAt func(b []byte, off int64) (int, error)
+
+	mu       sync.Mutex
+	next     int64 // offset of the next chunk to hand out.
+	end      int64 // where to stop; negative until the end of the source is found.
+	errOff   int64 // offset of the chunk that failed first.
+	err      error
+	ackedEnd int64 // end of the furthest chunk written.
+
+	writeFailed bool // err is from writing, rather than from reading.
+}
+
+// claim returns the offset of the next chunk to copy, or false if there is none left.
+func (c *chunkCopy) claim() (int64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.end >= 0 && c.next >= c.end {
+		return 0, false
+	}
+
+	off := c.next
+	c.next += int64(c.chunkSize)
+	return off, true
+}
+
+// fail records that the chunk at off failed with err, so that nothing past it is copied.
+func (c *chunkCopy) fail(off int64, err error, write bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err == nil || off < c.errOff {
+		c.err, c.errOff, c.writeFailed = err, off, write
+	}
+
+	if c.end < 0 || off < c.end {
+		c.end = off
+	}
+}
+
+// eof records that the source ends at off.
+func (c *chunkCopy) eof(off int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.end < 0 || off < c.end {
+		c.end = off
+	}
+}
+
+func (c *chunkCopy) written(off int64, n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if end := off + int64(n); end > c.ackedEnd {
+		c.ackedEnd = end
+	}
+}
+
+// run copies the data, and returns the length of the data copied without a gap from offset 0.
+func (c *chunkCopy) run() (int64, error) {
+	size := c.end
+
+	var wg sync.WaitGroup
+	for range c.concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			b := make([]byte, c.chunkSize)
+			for {
+				off, ok := c.claim()
+				if !ok {
+					return
+				}
+
+				rb := b
+				if size >= 0 && int64(len(rb)) > size-off {
+					rb = rb[:size-off]
+				}
+
+				n, err := c.readAt(rb, off)
+				if err == io.EOF {
+					c.eof(off + int64(n))
+				} else if err != nil {
+					c.fail(off+int64(n), err, false)
+				}
+
+				if n == 0 {
+					continue
+				}
+
+				if _, err := c.writeAt(rb[:n], off); err != nil {
+					c.fail(off, err, true)
+					continue
+				}
+
+				c.written(off, n)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	// Every chunk before the end has been copied, and we stop at the first failure.
+	if c.err != nil {
+		return c.errOff, c.err
+	}
+
+	return c.end, nil
+}
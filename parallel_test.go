@@ -0,0 +1,147 @@
+package sftp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingWriterAt fails every write at or past failAt.
+type failingWriterAt struct {
+	io.WriterAt
+	failAt int64
+}
+
+var errWriterAt = errors.New("write failed")
+
+func (w failingWriterAt) WriteAt(b []byte, off int64) (int, error) {
+	if off+int64(len(b)) > w.failAt {
+		return 0, errWriterAt
+	}
+	return w.WriterAt.WriteAt(b, off)
+}
+
+func TestDownloadToUploadFrom(t *testing.T) {
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	// force many small chunks in flight.
+	client.maxPacket = 1024
+
+	dir := t.TempDir()
+
+	content := make([]byte, 100*1024+17)
+	rand.New(rand.NewSource(1)).Read(content)
+
+	src := filepath.Join(dir, "src")
+	require.NoError(t, os.WriteFile(src, content, 0o600))
+
+	f, err := client.Open(src)
+	require.NoError(t, err)
+	defer f.Close()
+
+	local, err := os.Create(filepath.Join(dir, "local"))
+	require.NoError(t, err)
+	defer local.Close()
+
+	n, err := f.DownloadTo(local)
+	require.NoError(t, err)
+	assert.EqualValues(t, len(content), n)
+
+	got, err := os.ReadFile(local.Name())
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(content, got), "downloaded content differs")
+
+	off, err := f.Seek(0, io.SeekCurrent)
+	require.NoError(t, err)
+	assert.Zero(t, off, "the File offset is unchanged")
+
+	dst := filepath.Join(dir, "dst")
+	w, err := client.Create(dst)
+	require.NoError(t, err)
+	defer w.Close()
+
+	n, err = w.UploadFrom(local, int64(len(content)))
+	require.NoError(t, err)
+	assert.EqualValues(t, len(content), n)
+
+	got, err = os.ReadFile(dst)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(content, got), "uploaded content differs")
+
+	// An empty file.
+	require.NoError(t, os.WriteFile(src, nil, 0o600))
+
+	var buf writerAtBuffer
+	n, err = f.DownloadTo(&buf)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+}
+
+func TestDownloadToUploadFromErrors(t *testing.T) {
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	client.maxPacket = 1024
+
+	dir := t.TempDir()
+
+	content := make([]byte, 20*1024)
+	rand.New(rand.NewSource(1)).Read(content)
+
+	src := filepath.Join(dir, "src")
+	require.NoError(t, os.WriteFile(src, content, 0o600))
+
+	f, err := client.Open(src)
+	require.NoError(t, err)
+	defer f.Close()
+
+	local, err := os.Create(filepath.Join(dir, "local"))
+	require.NoError(t, err)
+	defer local.Close()
+
+	n, err := f.DownloadTo(failingWriterAt{local, 5*1024 + 10})
+	assert.ErrorIs(t, err, errWriterAt)
+	assert.EqualValues(t, 5*1024, n, "everything before the failed chunk is written")
+
+	got, err := os.ReadFile(local.Name())
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(content[:n], got[:n]))
+
+	// The source is shorter than the size given.
+	w, err := client.Create(filepath.Join(dir, "dst"))
+	require.NoError(t, err)
+	defer w.Close()
+
+	n, err = w.UploadFrom(bytes.NewReader(content), int64(len(content)+100))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.EqualValues(t, len(content), n)
+
+	// Writing to a read-only File.
+	n, err = f.UploadFrom(bytes.NewReader(content), int64(len(content)))
+	var pwErr *PartialWriteError
+	require.ErrorAs(t, err, &pwErr)
+	assert.Zero(t, n)
+	assert.Zero(t, pwErr.Confirmed)
+}
+
+// writerAtBuffer is an in-memory io.WriterAt.
+type writerAtBuffer struct {
+	b []byte
+}
+
+func (w *writerAtBuffer) WriteAt(b []byte, off int64) (int, error) {
+	if end := off + int64(len(b)); end > int64(len(w.b)) {
+		w.b = append(w.b, make([]byte, end-int64(len(w.b)))...)
+	}
+	return copy(w.b[off:], b), nil
+}
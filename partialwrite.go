@@ -0,0 +1,96 @@
+package sftp
+
+import (
+	"fmt"
+	"sync"
+)
+
+// UseTruncateOnPartialWrite sets whether a File is truncated back to the end of the confirmed data,
+// when a concurrent write fails part way.
+// This removes any data that the server acknowledged past the first failed chunk,
+// so that the file does not end up with holes in it.
+//
+// Truncating is only appropriate when the write extends the file, as uploads do,
+// since any data that already followed the written range is lost as well.
+//
+// By default, the File is left as it is, and the PartialWriteError reports what was written.
+func UseTruncateOnPartialWrite(value bool) ClientOption {
+	return func(c *Client) error {
+		c.truncateOnPartialWrite = value
+		return nil
+	}
+}
+
+// PartialWriteError is returned when a concurrent write fails after some of its chunks were sent.
+//
+// The count returned along with it is always Confirmed,
+// the length of the data from Offset that the server acknowledged without a gap.
+// As chunks are written concurrently, the server might also have acknowledged chunks past the failed one;
+// AckedEnd then lies past Offset+Confirmed.
+type PartialWriteError struct {
+	// Offset is where the write started.
+	Offset int64
+
+	// Confirmed is the length of the contiguous data from Offset that the server acknowledged.
+	Confirmed int64
+
+	// AckedEnd is the end of the furthest chunk the server acknowledged,
+	// or Offset if it acknowledged none.
+	AckedEnd int64
+
+	// Truncated reports whether the file was truncated to Offset+Confirmed, see UseTruncateOnPartialWrite.
+	Truncated bool
+
+	// Err is the error of the first chunk that failed.
+	Err error
+}
+
+func (e *PartialWriteError) Error() string {
+	msg := fmt.Sprintf("sftp: partial write: %d bytes confirmed at offset %d", e.Confirmed, e.Offset)
+
+	switch end := e.Offset + e.Confirmed; {
+	case e.Truncated:
+		msg += fmt.Sprintf(", truncated to %d", end)
+	case e.AckedEnd > end:
+		msg += fmt.Sprintf(", unconfirmed data up to offset %d", e.AckedEnd)
+	}
+
+	return msg + ": " + e.Err.Error()
+}
+
+func (e *PartialWriteError) Unwrap() error {
+	return e.Err
+}
+
+// ackTracker records the furthest offset acknowledged by concurrent writes.
+type ackTracker struct {
+	mu  sync.Mutex
+	end int64
+}
+
+func (t *ackTracker) ack(off int64, n int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if end := off + int64(n); end > t.end {
+		t.end = end
+	}
+}
+
+// partialWrite reports a concurrent write starting at off that failed with err,
+// after the server confirmed the first confirmed bytes of it, and acknowledged data up to ackedEnd.
+func (f *File) partialWrite(off, confirmed, ackedEnd int64, err error) *PartialWriteError {
+	pwErr := &PartialWriteError{
+		Offset:    off,
+		Confirmed: confirmed,
+		AckedEnd:  max(ackedEnd, off),
+		Err:       err,
+	}
+
+	if f.c.truncateOnPartialWrite {
+		// The failed chunk itself might have been written in part, so always truncate.
+		pwErr.Truncated = f.c.fsetstat(f.handle, sshFileXferAttrSize, uint64(off+confirmed)) == nil
+	}
+
+	return pwErr
+}
@@ -0,0 +1,111 @@
+package sftp
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConcurrentWriteAtPartial(t *testing.T) {
+	h := newInstrumentedHandlers()
+	h.failWrite = func(b []byte, off int64) bool { return off == 4096 }
+	p := instrumentedPair(t, h, MaxPacketUnchecked(1024))
+
+	f, err := p.cli.Create("/file")
+	require.NoError(t, err)
+	defer f.Close()
+
+	n, err := f.WriteAt(bytes.Repeat([]byte("x"), 10*1024), 0)
+	assert.Equal(t, 4096, n)
+
+	var pwErr *PartialWriteError
+	require.ErrorAs(t, err, &pwErr)
+	assert.EqualValues(t, 0, pwErr.Offset)
+	assert.EqualValues(t, 4096, pwErr.Confirmed)
+	assert.EqualValues(t, 10*1024, pwErr.AckedEnd, "chunks after the failed one were written")
+	assert.False(t, pwErr.Truncated)
+
+	var pathErr *os.PathError
+	require.ErrorAs(t, err, &pathErr)
+	assert.Equal(t, "write", pathErr.Op)
+
+	var status *StatusError
+	assert.ErrorAs(t, err, &status)
+}
+
+func TestConcurrentWriteAtPartialTruncate(t *testing.T) {
+	h := newInstrumentedHandlers()
+	h.failWrite = func(b []byte, off int64) bool { return off == 3*1024 }
+	p := instrumentedPair(t, h, UseTruncateOnPartialWrite(true), MaxPacketUnchecked(1024))
+
+	f, err := p.cli.Create("/file")
+	require.NoError(t, err)
+
+	n, err := f.WriteAt(bytes.Repeat([]byte("x"), 8*1024), 0)
+	assert.Equal(t, 3*1024, n)
+
+	var pwErr *PartialWriteError
+	require.ErrorAs(t, err, &pwErr)
+	assert.True(t, pwErr.Truncated)
+
+	require.NoError(t, f.Close())
+
+	// No write is left in flight to extend the file again after the truncate.
+	fi, err := p.cli.Stat("/file")
+	require.NoError(t, err)
+	assert.EqualValues(t, 3*1024, fi.Size())
+}
+
+func TestConcurrentReadFromPartialTruncate(t *testing.T) {
+	h := newInstrumentedHandlers()
+	h.failWrite = func(b []byte, off int64) bool { return off == 3*1024 }
+	p := instrumentedPair(t, h, UseTruncateOnPartialWrite(true), MaxPacketUnchecked(1024))
+
+	f, err := p.cli.Create("/file")
+	require.NoError(t, err)
+
+	n, err := f.ReadFromWithConcurrency(bytes.NewReader(bytes.Repeat([]byte("x"), 8*1024)), 4)
+	assert.EqualValues(t, 3*1024, n)
+
+	var pwErr *PartialWriteError
+	require.ErrorAs(t, err, &pwErr)
+	assert.EqualValues(t, 3*1024, pwErr.Confirmed)
+	assert.True(t, pwErr.Truncated)
+
+	off, err := f.Seek(0, io.SeekCurrent)
+	require.NoError(t, err)
+	assert.EqualValues(t, 3*1024, off, "the offset follows the confirmed data")
+
+	require.NoError(t, f.Close())
+
+	fi, err := p.cli.Stat("/file")
+	require.NoError(t, err)
+	assert.EqualValues(t, 3*1024, fi.Size())
+}
+
+func TestConcurrentWritesByDefault(t *testing.T) {
+	p := instrumentedPair(t, newInstrumentedHandlers(), MaxPacketUnchecked(1024))
+
+	assert.True(t, p.cli.useConcurrentWrites)
+
+	f, err := p.cli.Create("/file")
+	require.NoError(t, err)
+
+	want := bytes.Repeat([]byte("0123456789"), 1000)
+	n, err := f.ReadFrom(bytes.NewReader(want))
+	require.NoError(t, err)
+	assert.EqualValues(t, len(want), n)
+	require.NoError(t, f.Close())
+
+	f, err = p.cli.Open("/file")
+	require.NoError(t, err)
+	defer f.Close()
+
+	got, err := io.ReadAll(f)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+}
@@ -0,0 +1,194 @@
+package sftp
+
+import (
+	"sync"
+)
+
+// A Quota limits what a client may store through a server, see WithQuota and WithRSQuota.
+// Fields left to zero are not limited.
+type Quota struct {
+	// MaxBytes limits the total of the bytes written to files.
+	// Bytes are counted as they are written, whether they extend a file or overwrite it,
+	// and are not given back when files are removed.
+	MaxBytes int64
+
+	// MaxFiles limits the number of files created, by opening them with the create flag.
+	MaxFiles int64
+
+	// MaxFileSize limits the size files may be extended to, by writes and by setting their size.
+	MaxFileSize int64
+}
+
+// QuotaUsage is the usage counted against a Quota.
+type QuotaUsage struct {
+	Bytes int64
+	Files int64
+}
+
+// A QuotaStore keeps the usage of quotas by key, see WithQuota and WithRSQuota.
+// Servers sharing a QuotaStore share the usage of a key, so that a quota holds across sessions;
+// a QuotaStore can also persist it.
+// Its methods are called concurrently by the workers of the servers.
+type QuotaStore interface {
+	// Usage returns the usage of key.
+	Usage(key string) (QuotaUsage, error)
+
+	// Add adds delta to the usage of key, and returns the resulting usage.
+	// delta is negative to give back the usage reserved for an operation that failed,
+	// or that would have exceeded the quota.
+	Add(key string, delta QuotaUsage) (QuotaUsage, error)
+}
+
+// NewMemQuotaStore returns a QuotaStore keeping the usage in memory.
+func NewMemQuotaStore() QuotaStore {
+	return &memQuotaStore{usage: make(map[string]QuotaUsage)}
+}
+
+type memQuotaStore struct {
+	mu    sync.Mutex
+	usage map[string]QuotaUsage
+}
+
+func (s *memQuotaStore) Usage(key string) (QuotaUsage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.usage[key], nil
+}
+
+func (s *memQuotaStore) Add(key string, delta QuotaUsage) (QuotaUsage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	usage := s.usage[key]
+	usage.Bytes += delta.Bytes
+	usage.Files += delta.Files
+	s.usage[key] = usage
+
+	return usage, nil
+}
+
+// quotaEnforcer enforces a Quota on the packets handled by a server.
+// A nil *quotaEnforcer enforces nothing.
+type quotaEnforcer struct {
+	quota Quota
+	store QuotaStore
+	key   string
+}
+
+func newQuotaEnforcer(quota Quota, store QuotaStore, key string) *quotaEnforcer {
+	if store == nil {
+		store = NewMemQuotaStore()
+	}
+	return &quotaEnforcer{quota: quota, store: store, key: key}
+}
+
+// reserve checks pkt against the quota, and reserves the usage it adds.
+// exists reports whether the file at a path sent by the client exists.
+func (q *quotaEnforcer) reserve(pkt requestPacket, exists func(p string) bool) (QuotaUsage, error) {
+	if q == nil {
+		return QuotaUsage{}, nil
+	}
+
+	var delta QuotaUsage
+	switch p := pkt.(type) {
+	case *sshFxpWritePacket:
+		if err := q.checkSize(p.Offset, uint64(len(p.Data))); err != nil {
+			return QuotaUsage{}, err
+		}
+		delta.Bytes = int64(len(p.Data))
+	case *sshFxpOpenPacket:
+		if p.hasPflags(sshFxfCreat) && !exists(p.Path) {
+			delta.Files = 1
+		}
+	case *sshFxpSetstatPacket:
+		if p.Flags&sshFileXferAttrSize != 0 {
+			if fs, err := p.unmarshalFileStat(p.Flags); err == nil {
+				return QuotaUsage{}, q.checkSize(fs.Size, 0)
+			}
+		}
+	case *sshFxpFsetstatPacket:
+		if p.Flags&sshFileXferAttrSize != 0 {
+			if fs, err := p.unmarshalFileStat(p.Flags); err == nil {
+				return QuotaUsage{}, q.checkSize(fs.Size, 0)
+			}
+		}
+	}
+
+	if delta == (QuotaUsage{}) {
+		return delta, nil
+	}
+
+	usage, err := q.store.Add(q.key, delta)
+	if err != nil {
+		return QuotaUsage{}, err
+	}
+
+	if (delta.Bytes > 0 && q.quota.MaxBytes > 0 && usage.Bytes > q.quota.MaxBytes) ||
+		(delta.Files > 0 && q.quota.MaxFiles > 0 && usage.Files > q.quota.MaxFiles) {
+		q.release(delta)
+		return QuotaUsage{}, ErrSSHFxQuotaExceeded
+	}
+
+	return delta, nil
+}
+
+// checkSize checks that a file extended to off+n bytes stays within the maximum file size.
+func (q *quotaEnforcer) checkSize(off, n uint64) error {
+	if q.quota.MaxFileSize <= 0 {
+		return nil
+	}
+
+	limit := uint64(q.quota.MaxFileSize)
+	if off > limit || n > limit-off {
+		return ErrSSHFxQuotaExceeded
+	}
+	return nil
+}
+
+// settle gives back the usage reserved for a packet, if it was answered by an error in rpkt.
+func (q *quotaEnforcer) settle(reserved QuotaUsage, rpkt responsePacket) {
+	if q == nil || reserved == (QuotaUsage{}) {
+		return
+	}
+
+	if status, ok := rpkt.(*sshFxpStatusPacket); ok && status.StatusError.Code != sshFxOk {
+		q.release(reserved)
+	}
+}
+
+func (q *quotaEnforcer) release(reserved QuotaUsage) {
+	q.store.Add(q.key, QuotaUsage{Bytes: -reserved.Bytes, Files: -reserved.Files})
+}
+
+// statVFS limits the space and the files reported by st to what is left of the quota.
+func (q *quotaEnforcer) statVFS(st *StatVFS) error {
+	if q == nil {
+		return nil
+	}
+
+	usage, err := q.store.Usage(q.key)
+	if err != nil {
+		return err
+	}
+
+	if q.quota.MaxBytes > 0 && st.Frsize > 0 {
+		blocks := uint64(q.quota.MaxBytes) / st.Frsize
+		free := uint64(max(q.quota.MaxBytes-usage.Bytes, 0)) / st.Frsize
+
+		st.Blocks = min(st.Blocks, blocks)
+		st.Bfree = min(st.Bfree, free)
+		st.Bavail = min(st.Bavail, free)
+	}
+
+	if q.quota.MaxFiles > 0 {
+		files := uint64(q.quota.MaxFiles)
+		free := uint64(max(q.quota.MaxFiles-usage.Files, 0))
+
+		st.Files = min(st.Files, files)
+		st.Ffree = min(st.Ffree, free)
+		st.Favail = min(st.Favail, free)
+	}
+
+	return nil
+}
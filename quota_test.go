@@ -0,0 +1,120 @@
+package sftp
+
+import (
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerQuota(t *testing.T) {
+	skipIfWindows(t)
+
+	dir := t.TempDir()
+	store := NewMemQuotaStore()
+	quota := Quota{MaxBytes: 10, MaxFiles: 2, MaxFileSize: 8}
+
+	client, server := clientServerPair(t, WithRoot(dir), WithQuota(quota, store, "acme"))
+	defer client.Close()
+	defer server.Close()
+
+	a, err := client.Create("/a")
+	require.NoError(t, err)
+	_, err = a.Write([]byte("hello"))
+	require.NoError(t, err)
+
+	_, err = a.WriteAt([]byte("abc"), 6)
+	assert.ErrorIs(t, err, ErrSSHFxQuotaExceeded, "beyond the maximum file size")
+	assert.ErrorIs(t, client.Truncate("/a", 9), ErrSSHFxQuotaExceeded)
+	assert.ErrorIs(t, a.Truncate(9), ErrSSHFxQuotaExceeded)
+	assert.NoError(t, client.Truncate("/a", 8))
+	require.NoError(t, a.Close())
+
+	b, err := client.Create("/b")
+	require.NoError(t, err)
+
+	_, err = client.Create("/c")
+	assert.ErrorIs(t, err, ErrSSHFxQuotaExceeded)
+	_, err = os.Stat(dir + "/c")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	a, err = client.OpenFile("/a", os.O_WRONLY|os.O_CREATE)
+	require.NoError(t, err, "existing files are not counted")
+	require.NoError(t, a.Close())
+
+	_, err = b.Write([]byte("123456"))
+	assert.ErrorIs(t, err, ErrSSHFxQuotaExceeded, "beyond the total of bytes")
+	_, err = b.Write([]byte("12345"))
+	require.NoError(t, err)
+	require.NoError(t, b.Close())
+
+	usage, err := store.Usage("acme")
+	require.NoError(t, err)
+	assert.Equal(t, QuotaUsage{Bytes: 10, Files: 2}, usage)
+
+	if runtime.GOOS == "linux" || runtime.GOOS == "darwin" {
+		st, err := client.StatVFS("/")
+		require.NoError(t, err)
+		assert.EqualValues(t, 2, st.Files)
+		assert.EqualValues(t, 0, st.Favail)
+		assert.EqualValues(t, 0, st.Bavail)
+	}
+
+	// The quota holds across the sessions sharing the store.
+	client2, server2 := clientServerPair(t, WithRoot(dir), WithQuota(quota, store, "acme"))
+	defer client2.Close()
+	defer server2.Close()
+
+	_, err = client2.Create("/d")
+	assert.ErrorIs(t, err, ErrSSHFxQuotaExceeded)
+}
+
+func TestRequestServerQuota(t *testing.T) {
+	store := NewMemQuotaStore()
+
+	p := clientRequestServerPair(t, WithRSQuota(Quota{MaxBytes: 8, MaxFiles: 1}, store, "acme"))
+	defer p.Close()
+
+	_, err := putTestFile(p.cli, "/foo", "hello")
+	require.NoError(t, err)
+
+	_, err = putTestFile(p.cli, "/foo", "abc")
+	require.NoError(t, err, "existing files are not counted")
+
+	_, err = putTestFile(p.cli, "/bar", "a")
+	assert.ErrorIs(t, err, ErrSSHFxQuotaExceeded)
+	_, err = p.testHandler().fetch("/bar")
+	assert.ErrorIs(t, err, os.ErrNotExist, "the Handlers are not called")
+
+	_, err = putTestFile(p.cli, "/foo", "abcdef")
+	assert.ErrorIs(t, err, ErrSSHFxQuotaExceeded)
+
+	usage, err := store.Usage("acme")
+	require.NoError(t, err)
+	assert.Equal(t, QuotaUsage{Bytes: 8, Files: 1}, usage)
+
+	if runtime.GOOS == "linux" || runtime.GOOS == "darwin" {
+		st, err := p.cli.StatVFS("/")
+		require.NoError(t, err)
+		assert.EqualValues(t, 1, st.Files)
+		assert.EqualValues(t, 0, st.Ffree)
+	}
+}
+
+func TestQuotaStatVFS(t *testing.T) {
+	store := NewMemQuotaStore()
+	_, err := store.Add("acme", QuotaUsage{Bytes: 4096, Files: 3})
+	require.NoError(t, err)
+
+	q := newQuotaEnforcer(Quota{MaxBytes: 4 * 4096, MaxFiles: 10}, store, "acme")
+
+	st := &StatVFS{Frsize: 4096, Blocks: 1000, Bfree: 500, Bavail: 400, Files: 100, Ffree: 50, Favail: 50}
+	require.NoError(t, q.statVFS(st))
+	assert.Equal(t, &StatVFS{Frsize: 4096, Blocks: 4, Bfree: 3, Bavail: 3, Files: 10, Ffree: 7, Favail: 7}, st)
+
+	st = &StatVFS{Frsize: 4096, Blocks: 2, Bfree: 1, Bavail: 1, Files: 5, Ffree: 1, Favail: 1}
+	require.NoError(t, q.statVFS(st))
+	assert.Equal(t, &StatVFS{Frsize: 4096, Blocks: 2, Bfree: 1, Bavail: 1, Files: 5, Ffree: 1, Favail: 1}, st, "the filesystem is smaller")
+}
@@ -0,0 +1,634 @@
+package sftp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrReopenUnsafe is returned by a ReconnectingFile that lost its connection,
+// when it cannot be reopened on the new connection without risking data loss.
+// This is the case for any file that was opened for writing,
+// as writes in flight at the time of the disconnect may or may not have reached the server.
+//
+// The error returned also matches the original connection-lost error with errors.Is.
+var ErrReopenUnsafe = errors.New("sftp: file cannot be safely reopened after reconnect")
+
+// connectionLost reports whether err was caused by the loss of the connection to the server,
+// rather than by the server rejecting the request.
+func (c *Client) connectionLost(err error) bool {
+	if errors.Is(err, ErrSSHFxConnectionLost) || errors.Is(err, ErrSSHFxNoConnection) {
+		return true
+	}
+
+	// Errors from writing to a dead conn are not normalized,
+	// but the conn has always shut down by the time they are returned.
+	return c.isClosed()
+}
+
+// A ReconnectOption is a function which applies configuration to a ReconnectingClient.
+type ReconnectOption func(*ReconnectingClient) error
+
+// ReconnectBackoff sets the delays between failed attempts to re-establish the session.
+// The delay starts at min, and doubles after every failed attempt up to max.
+//
+// The default backoff is from 100 milliseconds up to 30 seconds.
+func ReconnectBackoff(min, max time.Duration) ReconnectOption {
+	return func(rc *ReconnectingClient) error {
+		if min <= 0 || max < min {
+			return errors.New("backoff must satisfy 0 < min <= max")
+		}
+		rc.minBackoff = min
+		rc.maxBackoff = max
+		return nil
+	}
+}
+
+// ReconnectMaxAttempts sets how many times the session is dialed,
+// before giving up and returning the last dial error.
+// It also limits how many times an idempotent operation is retried.
+//
+// The default is 10 attempts.
+func ReconnectMaxAttempts(n int) ReconnectOption {
+	return func(rc *ReconnectingClient) error {
+		if n < 1 {
+			return errors.New("n must be greater or equal to 1")
+		}
+		rc.maxAttempts = n
+		return nil
+	}
+}
+
+// ReconnectingClient holds a Client, and transparently replaces it
+// with a newly dialed one, whenever the connection to the server is lost.
+//
+// Idempotent operations (Stat, Lstat, ReadDir, ReadLink, RealPath, Getwd and StatVFS)
+// are retried automatically on the new session.
+// All other operations return the connection-lost error as they received it,
+// and the session is re-established on the next call.
+//
+// It is safe to call a ReconnectingClient concurrently from multiple goroutines.
+type ReconnectingClient struct {
+	dial func(ctx context.Context) (*Client, error)
+
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+	maxAttempts int
+
+	mu      sync.Mutex
+	client  *Client
+	closed  bool
+	dialing *redialCall // the redial in progress, if any
+}
+
+// redialCall is a redial shared by every goroutine that needs a new session at the same time.
+type redialCall struct {
+	done chan struct{} // closed once c and err are set
+	c    *Client
+	err  error
+}
+
+// NewReconnectingClient dials a first session with the given dial function,
+// and returns a ReconnectingClient which will use the same function
+// to re-establish the session whenever the connection is lost.
+//
+// The dial function is typically a closure around ssh.Dial and NewClient.
+func NewReconnectingClient(ctx context.Context, dial func(ctx context.Context) (*Client, error), opts ...ReconnectOption) (*ReconnectingClient, error) {
+	rc := &ReconnectingClient{
+		dial: dial,
+
+		minBackoff:  100 * time.Millisecond,
+		maxBackoff:  30 * time.Second,
+		maxAttempts: 10,
+	}
+
+	for _, opt := range opts {
+		if err := opt(rc); err != nil {
+			return nil, err
+		}
+	}
+
+	c, err := rc.dialWithBackoff(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rc.client = c
+
+	return rc, nil
+}
+
+// Client returns the currently connected Client,
+// re-establishing the session first if the connection has been lost.
+//
+// The returned Client is not replaced on later reconnects,
+// so it should only be used for a short sequence of operations.
+func (rc *ReconnectingClient) Client(ctx context.Context) (*Client, error) {
+	rc.mu.Lock()
+
+	if rc.closed {
+		rc.mu.Unlock()
+		return nil, ErrSSHFxNoConnection
+	}
+
+	if c := rc.client; c != nil && !c.isClosed() {
+		rc.mu.Unlock()
+		return c, nil
+	}
+
+	return rc.redial(ctx)
+}
+
+// reconnect replaces the failed client with a newly dialed one,
+// unless another goroutine already did so.
+func (rc *ReconnectingClient) reconnect(ctx context.Context, failed *Client) (*Client, error) {
+	rc.mu.Lock()
+
+	if rc.closed {
+		rc.mu.Unlock()
+		return nil, ErrSSHFxNoConnection
+	}
+
+	if c := rc.client; c != failed && c != nil && !c.isClosed() {
+		rc.mu.Unlock()
+		return c, nil
+	}
+
+	return rc.redial(ctx)
+}
+
+// redial must be called while holding the lock, which it releases.
+//
+// Only one goroutine dials at a time, without holding the lock,
+// and the others wait for its result, or for their ctx to be done.
+func (rc *ReconnectingClient) redial(ctx context.Context) (*Client, error) {
+	if call := rc.dialing; call != nil {
+		rc.mu.Unlock()
+
+		select {
+		case <-call.done:
+			return call.c, call.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	call := &redialCall{done: make(chan struct{})}
+	rc.dialing = call
+
+	failed := rc.client
+	rc.client = nil
+	rc.mu.Unlock()
+
+	if failed != nil {
+		failed.Close()
+	}
+
+	c, err := rc.dialWithBackoff(ctx)
+
+	rc.mu.Lock()
+	rc.dialing = nil
+	closed := rc.closed
+	if err == nil && !closed {
+		rc.client = c
+	}
+	rc.mu.Unlock()
+
+	if err == nil && closed {
+		// Closed while dialing.
+		c.Close()
+		c, err = nil, ErrSSHFxNoConnection
+	}
+
+	call.c, call.err = c, err
+	close(call.done)
+
+	return c, err
+}
+
+func (rc *ReconnectingClient) dialWithBackoff(ctx context.Context) (*Client, error) {
+	backoff := rc.minBackoff
+
+	var err error
+	for attempt := 0; attempt < rc.maxAttempts; attempt++ {
+		if attempt > 0 {
+			timer := time.NewTimer(backoff)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, ctx.Err()
+			case <-timer.C:
+			}
+
+			if backoff *= 2; backoff > rc.maxBackoff {
+				backoff = rc.maxBackoff
+			}
+		}
+
+		var c *Client
+		if c, err = rc.dial(ctx); err == nil {
+			return c, nil
+		}
+	}
+
+	return nil, fmt.Errorf("sftp: reconnect failed after %d attempts: %w", rc.maxAttempts, err)
+}
+
+// Close closes the current session. No further sessions will be established,
+// and a session being dialed is closed as soon as it is established.
+func (rc *ReconnectingClient) Close() error {
+	rc.mu.Lock()
+	rc.closed = true
+	c := rc.client
+	rc.client = nil
+	rc.mu.Unlock()
+
+	if c == nil {
+		return nil
+	}
+
+	return c.Close()
+}
+
+// retry runs fn, re-running it on a new session as long as it fails from a lost connection.
+// It must only be used for idempotent operations.
+func (rc *ReconnectingClient) retry(ctx context.Context, fn func(c *Client) error) error {
+	c, err := rc.Client(ctx)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		err = fn(c)
+		if err == nil || !c.connectionLost(err) || attempt >= rc.maxAttempts {
+			return err
+		}
+
+		if c, err = rc.reconnect(ctx, c); err != nil {
+			return err
+		}
+	}
+}
+
+// once runs fn a single time. A lost connection is re-established on the next call.
+func (rc *ReconnectingClient) once(fn func(c *Client) error) error {
+	c, err := rc.Client(context.Background())
+	if err != nil {
+		return err
+	}
+
+	return fn(c)
+}
+
+// Stat returns a FileInfo structure describing the file specified by path 'p'.
+// See Client.Stat for details.
+func (rc *ReconnectingClient) Stat(p string) (fi os.FileInfo, err error) {
+	err = rc.retry(context.Background(), func(c *Client) (err error) {
+		fi, err = c.Stat(p)
+		return err
+	})
+	return fi, err
+}
+
+// Lstat returns a FileInfo structure describing the file specified by path 'p'.
+// See Client.Lstat for details.
+func (rc *ReconnectingClient) Lstat(p string) (fi os.FileInfo, err error) {
+	err = rc.retry(context.Background(), func(c *Client) (err error) {
+		fi, err = c.Lstat(p)
+		return err
+	})
+	return fi, err
+}
+
+// ReadDir reads the directory named by p and returns a list of directory entries.
+// See Client.ReadDir for details.
+func (rc *ReconnectingClient) ReadDir(p string) ([]os.FileInfo, error) {
+	return rc.ReadDirContext(context.Background(), p)
+}
+
+// ReadDirContext reads the directory named by p and returns a list of directory entries.
+// The passed context also bounds the time spent re-establishing the session.
+func (rc *ReconnectingClient) ReadDirContext(ctx context.Context, p string) (entries []os.FileInfo, err error) {
+	err = rc.retry(ctx, func(c *Client) (err error) {
+		entries, err = c.ReadDirContext(ctx, p)
+		return err
+	})
+	return entries, err
+}
+
+// ReadLink reads the target of a symbolic link.
+func (rc *ReconnectingClient) ReadLink(p string) (target string, err error) {
+	err = rc.retry(context.Background(), func(c *Client) (err error) {
+		target, err = c.ReadLink(p)
+		return err
+	})
+	return target, err
+}
+
+// RealPath has the server canonicalize any given path name to an absolute path.
+// See Client.RealPath for details.
+func (rc *ReconnectingClient) RealPath(p string) (real string, err error) {
+	err = rc.retry(context.Background(), func(c *Client) (err error) {
+		real, err = c.RealPath(p)
+		return err
+	})
+	return real, err
+}
+
+// Getwd returns the current working directory of the server.
+func (rc *ReconnectingClient) Getwd() (string, error) {
+	return rc.RealPath(".")
+}
+
+// StatVFS retrieves VFS statistics from a remote host.
+func (rc *ReconnectingClient) StatVFS(p string) (st *StatVFS, err error) {
+	err = rc.retry(context.Background(), func(c *Client) (err error) {
+		st, err = c.StatVFS(p)
+		return err
+	})
+	return st, err
+}
+
+// Mkdir creates the specified directory. See Client.Mkdir for details.
+func (rc *ReconnectingClient) Mkdir(p string) error {
+	return rc.once(func(c *Client) error { return c.Mkdir(p) })
+}
+
+// MkdirAll creates a directory named path, along with any necessary parents.
+// See Client.MkdirAll for details.
+func (rc *ReconnectingClient) MkdirAll(p string) error {
+	return rc.once(func(c *Client) error { return c.MkdirAll(p) })
+}
+
+// Remove removes the specified file or directory. See Client.Remove for details.
+func (rc *ReconnectingClient) Remove(p string) error {
+	return rc.once(func(c *Client) error { return c.Remove(p) })
+}
+
+// Rename renames a file. See Client.Rename for details.
+func (rc *ReconnectingClient) Rename(oldname, newname string) error {
+	return rc.once(func(c *Client) error { return c.Rename(oldname, newname) })
+}
+
+// PosixRename renames a file, replacing newname if it already exists.
+// See Client.PosixRename for details.
+func (rc *ReconnectingClient) PosixRename(oldname, newname string) error {
+	return rc.once(func(c *Client) error { return c.PosixRename(oldname, newname) })
+}
+
+// Symlink creates a symbolic link at 'newname', pointing at target 'oldname'.
+func (rc *ReconnectingClient) Symlink(oldname, newname string) error {
+	return rc.once(func(c *Client) error { return c.Symlink(oldname, newname) })
+}
+
+// Chmod changes the permissions of the named file. See Client.Chmod for details.
+func (rc *ReconnectingClient) Chmod(p string, mode os.FileMode) error {
+	return rc.once(func(c *Client) error { return c.Chmod(p, mode) })
+}
+
+// Chtimes changes the access and modification times of the named file.
+func (rc *ReconnectingClient) Chtimes(p string, atime, mtime time.Time) error {
+	return rc.once(func(c *Client) error { return c.Chtimes(p, atime, mtime) })
+}
+
+// Truncate sets the size of the named file. See Client.Truncate for details.
+func (rc *ReconnectingClient) Truncate(p string, size int64) error {
+	return rc.once(func(c *Client) error { return c.Truncate(p, size) })
+}
+
+// Open opens the named file for reading.
+// Files opened for reading only are reopened at their last offset after a reconnect.
+func (rc *ReconnectingClient) Open(p string) (*ReconnectingFile, error) {
+	return rc.OpenFile(p, os.O_RDONLY)
+}
+
+// Create creates or truncates the named file. See Client.Create for details.
+func (rc *ReconnectingClient) Create(p string) (*ReconnectingFile, error) {
+	return rc.OpenFile(p, os.O_RDWR|os.O_CREATE|os.O_TRUNC)
+}
+
+// OpenFile opens the named file with the specified flags. See Client.OpenFile for details.
+//
+// Opening a file is retried after a reconnect only for read-only files.
+func (rc *ReconnectingClient) OpenFile(p string, flag int) (*ReconnectingFile, error) {
+	rf := &ReconnectingFile{
+		rc:   rc,
+		path: p,
+		flag: flag,
+	}
+
+	open := rc.once
+	if rf.reopenable() {
+		open = func(fn func(c *Client) error) error {
+			return rc.retry(context.Background(), fn)
+		}
+	}
+
+	err := open(func(c *Client) (err error) {
+		rf.f, err = c.OpenFile(p, flag)
+		rf.c = c
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return rf, nil
+}
+
+// ReconnectingFile is a remote file opened through a ReconnectingClient.
+//
+// If the connection is lost, a file opened for reading only is reopened on the new session,
+// and continues from the offset it was at. Any other file fails with ErrReopenUnsafe.
+type ReconnectingFile struct {
+	rc   *ReconnectingClient
+	path string
+	flag int
+
+	mu     sync.Mutex
+	c      *Client
+	f      *File
+	offset int64 // last known offset, for reopening
+	err    error // sticky error, set when the file cannot be reopened
+}
+
+func (rf *ReconnectingFile) reopenable() bool {
+	return rf.flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) == 0
+}
+
+// Name returns the name of the file as presented to Open or OpenFile.
+func (rf *ReconnectingFile) Name() string {
+	return rf.path
+}
+
+// do runs fn against the current handle, reopening the file and retrying as long as fn fails from a lost connection.
+// done reports whether fn made progress that must not be repeated, in which case its result is returned as is.
+// It must be called while holding the lock.
+func (rf *ReconnectingFile) do(fn func(f *File) (done bool, err error)) error {
+	for attempt := 1; ; attempt++ {
+		if rf.err != nil {
+			return rf.err
+		}
+
+		done, err := fn(rf.f)
+		rf.offset = rf.f.offset
+
+		if err == nil || done || !rf.c.connectionLost(err) {
+			return err
+		}
+
+		if !rf.reopenable() {
+			rf.err = fmt.Errorf("%w: %w", ErrReopenUnsafe, err)
+			return rf.err
+		}
+
+		if attempt >= rf.rc.maxAttempts {
+			return err
+		}
+
+		if err := rf.reopen(); err != nil {
+			return err
+		}
+	}
+}
+
+// reopen must be called while holding the lock.
+func (rf *ReconnectingFile) reopen() error {
+	c, err := rf.rc.reconnect(context.Background(), rf.c)
+	if err != nil {
+		return err
+	}
+
+	f, err := c.OpenFile(rf.path, rf.flag)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Seek(rf.offset, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+
+	rf.c, rf.f = c, f
+	return nil
+}
+
+// Read reads up to len(b) bytes from the file. See File.Read for details.
+func (rf *ReconnectingFile) Read(b []byte) (n int, err error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	err = rf.do(func(f *File) (bool, error) {
+		n, err = f.Read(b)
+		return n > 0, err
+	})
+	if n > 0 && err != nil && rf.c.connectionLost(err) && rf.reopenable() {
+		// Return what we have, the next call will reopen the file.
+		err = nil
+	}
+	return n, err
+}
+
+// ReadAt reads up to len(b) bytes from the file at the given offset. See File.ReadAt for details.
+func (rf *ReconnectingFile) ReadAt(b []byte, off int64) (n int, err error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	err = rf.do(func(f *File) (bool, error) {
+		n, err = f.ReadAt(b, off)
+		return false, err
+	})
+	return n, err
+}
+
+// WriteTo writes the rest of the file to w. See File.WriteTo for details.
+//
+// If the connection is lost part way through, the file is reopened,
+// and the transfer continues from the last byte written to w.
+func (rf *ReconnectingFile) WriteTo(w io.Writer) (written int64, err error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	err = rf.do(func(f *File) (bool, error) {
+		n, err := f.WriteTo(w)
+		written += n
+		return false, err
+	})
+	return written, err
+}
+
+// Write writes len(b) bytes to the file. See File.Write for details.
+func (rf *ReconnectingFile) Write(b []byte) (n int, err error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	err = rf.do(func(f *File) (bool, error) {
+		n, err = f.Write(b)
+		return false, err
+	})
+	return n, err
+}
+
+// WriteAt writes len(b) bytes to the file at the given offset. See File.WriteAt for details.
+func (rf *ReconnectingFile) WriteAt(b []byte, off int64) (n int, err error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	err = rf.do(func(f *File) (bool, error) {
+		n, err = f.WriteAt(b, off)
+		return false, err
+	})
+	return n, err
+}
+
+// ReadFrom reads data from r until EOF and writes it to the file. See File.ReadFrom for details.
+func (rf *ReconnectingFile) ReadFrom(r io.Reader) (read int64, err error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	err = rf.do(func(f *File) (bool, error) {
+		read, err = f.ReadFrom(r)
+		return false, err
+	})
+	return read, err
+}
+
+// Seek sets the offset for the next Read or Write. See File.Seek for details.
+func (rf *ReconnectingFile) Seek(offset int64, whence int) (ret int64, err error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	err = rf.do(func(f *File) (bool, error) {
+		ret, err = f.Seek(offset, whence)
+		return false, err
+	})
+	return ret, err
+}
+
+// Stat returns the FileInfo structure describing the file.
+func (rf *ReconnectingFile) Stat() (fi os.FileInfo, err error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	err = rf.do(func(f *File) (bool, error) {
+		fi, err = f.Stat()
+		return false, err
+	})
+	return fi, err
+}
+
+// Close closes the file. A file whose session was lost is considered closed.
+func (rf *ReconnectingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	err := rf.f.Close()
+	if err != nil && rf.c.connectionLost(err) && rf.err == nil {
+		// The server discards all handles of a lost session.
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,246 @@
+package sftp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// reconnectPair returns a ReconnectingClient dialing sessions to in-process Servers,
+// and a function to kill the currently connected server.
+func reconnectPair(t *testing.T) (*ReconnectingClient, func(), *int) {
+	var mu sync.Mutex
+	var server *Server
+	var client *Client
+	dials := new(int)
+
+	dial := func(ctx context.Context) (*Client, error) {
+		cr, sw := io.Pipe()
+		sr, cw := io.Pipe()
+
+		s, err := NewServer(struct {
+			io.Reader
+			io.WriteCloser
+		}{sr, sw})
+		if err != nil {
+			return nil, err
+		}
+		go s.Serve()
+
+		c, err := NewClientPipe(cr, cw)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+
+		mu.Lock()
+		server, client = s, c
+		*dials++
+		mu.Unlock()
+
+		return c, nil
+	}
+
+	kill := func() {
+		mu.Lock()
+		s, c := server, client
+		mu.Unlock()
+
+		s.Close()
+		c.Wait()
+	}
+
+	rc, err := NewReconnectingClient(context.Background(), dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		// Over pipes, the server must hang up first.
+		kill()
+		rc.Close()
+	})
+
+	return rc, kill, dials
+}
+
+func TestReconnectingClientRetriesIdempotent(t *testing.T) {
+	rc, kill, dials := reconnectPair(t)
+
+	dir := t.TempDir()
+
+	if _, err := rc.Stat(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	kill()
+
+	fi, err := rc.Stat(dir)
+	if err != nil {
+		t.Fatalf("Stat() after server loss = %v", err)
+	}
+	if !fi.IsDir() {
+		t.Errorf("Stat(%q).IsDir() = false", dir)
+	}
+	if *dials != 2 {
+		t.Errorf("dials = %d, want 2", *dials)
+	}
+}
+
+func TestReconnectingFileReopensReadOnly(t *testing.T) {
+	rc, kill, _ := reconnectPair(t)
+
+	name := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(name, []byte("0123456789"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := rc.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	b := make([]byte, 4)
+	if _, err := io.ReadFull(f, b); err != nil {
+		t.Fatal(err)
+	}
+
+	kill()
+
+	rest, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("ReadAll() after server loss = %v", err)
+	}
+	if got := string(b) + string(rest); got != "0123456789" {
+		t.Errorf("read %q, want %q", got, "0123456789")
+	}
+}
+
+func TestReconnectingFileWriteUnsafe(t *testing.T) {
+	rc, kill, _ := reconnectPair(t)
+
+	name := filepath.Join(t.TempDir(), "file")
+
+	f, err := rc.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	kill()
+
+	_, err = f.Write([]byte("world"))
+	if !errors.Is(err, ErrReopenUnsafe) {
+		t.Fatalf("Write() after server loss = %v, want ErrReopenUnsafe", err)
+	}
+	if !errors.Is(err, ErrSSHFxConnectionLost) {
+		t.Errorf("errors.Is(%v, ErrSSHFxConnectionLost) = false, want true", err)
+	}
+
+	// the client itself is still usable.
+	if _, err := rc.Stat(name); err != nil {
+		t.Errorf("Stat() after reconnect = %v", err)
+	}
+}
+
+func TestReconnectingClientClosed(t *testing.T) {
+	rc, kill, _ := reconnectPair(t)
+
+	kill()
+	if err := rc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := rc.Stat("/"); !errors.Is(err, ErrSSHFxNoConnection) {
+		t.Errorf("Stat() after Close() = %v, want ErrSSHFxNoConnection", err)
+	}
+}
+
+func TestReconnectingClientDialDoesNotBlock(t *testing.T) {
+	var server *Server
+	release := make(chan struct{})
+	dialing := make(chan struct{}, 1)
+	errRefused := errors.New("connection refused")
+
+	dial := func(ctx context.Context) (*Client, error) {
+		if server != nil {
+			// Redials stall until released, and then fail.
+			dialing <- struct{}{}
+			<-release
+			return nil, errRefused
+		}
+
+		cr, sw := io.Pipe()
+		sr, cw := io.Pipe()
+
+		s, err := NewServer(struct {
+			io.Reader
+			io.WriteCloser
+		}{sr, sw})
+		if err != nil {
+			return nil, err
+		}
+		go s.Serve()
+
+		server = s
+		return NewClientPipe(cr, cw)
+	}
+
+	rc, err := NewReconnectingClient(context.Background(), dial, ReconnectMaxAttempts(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := rc.Client(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+	c.Wait()
+
+	statErr := make(chan error, 1)
+	go func() {
+		_, err := rc.Stat("/")
+		statErr <- err
+	}()
+	<-dialing
+
+	// Other callers are not held up by the stalled dial.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := rc.ReadDirContext(ctx, "/"); !errors.Is(err, context.Canceled) {
+		t.Errorf("ReadDirContext() during dial = %v, want context.Canceled", err)
+	}
+
+	closed := make(chan error, 1)
+	go func() {
+		closed <- rc.Close()
+	}()
+
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Errorf("Close() during dial = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Close() waited for the stalled dial")
+	}
+
+	close(release)
+	if err := <-statErr; !errors.Is(err, errRefused) {
+		t.Errorf("Stat() = %v, want %v", err, errRefused)
+	}
+
+	if _, err := rc.Stat("/"); !errors.Is(err, ErrSSHFxNoConnection) {
+		t.Errorf("Stat() after Close() = %v, want ErrSSHFxNoConnection", err)
+	}
+}
@@ -29,7 +29,7 @@ func (r *Request) Pflags() FileOpenFlags {
 
 // FileAttrFlags that indicate whether SFTP file attributes were passed. When a flag is
 // true the corresponding attribute should be available from the FileStat
-// object returned by Attributes method. Used with SetStat.
+// object returned by Attributes method. Used with SetStat and Mkdir.
 type FileAttrFlags struct {
 	Size, UidGid, Permissions, Acmodtime bool
 }
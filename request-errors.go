@@ -15,6 +15,10 @@ const (
 	ErrSSHFxNoConnection     = fxerr(sshFxNoConnection)
 	ErrSSHFxConnectionLost   = fxerr(sshFxConnectionLost)
 	ErrSSHFxOpUnsupported    = fxerr(sshFxOPUnsupported)
+
+	// ErrSSHFxQuotaExceeded is not part of version 3 of the protocol,
+	// but is sent by servers enforcing a Quota, as in later versions.
+	ErrSSHFxQuotaExceeded = fxerr(sshFxQuotaExceeded)
 )
 
 // Deprecated error types, these are aliases for the new ones, please use the new ones directly
@@ -48,6 +52,8 @@ func (e fxerr) Error() string {
 		return "connection lost"
 	case ErrSSHFxOpUnsupported:
 		return "operation unsupported"
+	case ErrSSHFxQuotaExceeded:
+		return "quota exceeded"
 	default:
 		return "failure"
 	}
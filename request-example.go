@@ -639,6 +639,14 @@ func (f *memFile) Truncate(size int64) error {
 	return nil
 }
 
+// Sync has nothing to flush, the content is only kept in memory.
+func (f *memFile) Sync() error {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.err
+}
+
 func (f *memFile) TransferError(err error) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
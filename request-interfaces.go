@@ -157,3 +157,12 @@ type ListerAt interface {
 type TransferError interface {
 	TransferError(err error)
 }
+
+// SyncWriterAt is an optional interface that the io.WriterAt returned by a FileWriter,
+// or the WriterAtReaderAt returned by an OpenFileWriter, can implement
+// to handle fsync@openssh.com requests on its handle.
+// Sync should only return once the data written so far is on stable storage.
+// Without it, such requests fail with SSH_FX_OP_UNSUPPORTED.
+type SyncWriterAt interface {
+	Sync() error
+}
@@ -4,10 +4,14 @@ import (
 	"context"
 	"errors"
 	"io"
+	"os"
 	"path"
 	"path/filepath"
 	"strconv"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
 const defaultMaxTxPacket uint32 = 1 << 15
@@ -29,6 +33,15 @@ type RequestServer struct {
 
 	startDirectory string
 	maxTxPacket    uint32
+	authorizer     Authorizer
+	identity       string
+	auditSink      AuditSink
+	sessionID      string
+	maxHandles     int
+	idleTimeout    time.Duration
+	quota          *quotaEnforcer
+	onClose        CloseHook
+	shuttingDown   atomic.Bool
 
 	mu           sync.RWMutex
 	handleCount  int
@@ -50,6 +63,33 @@ func WithRSAllocator() RequestServerOption {
 	}
 }
 
+// WithRSWorkerCount sets the number of workers handling reads and writes in parallel,
+// or, with WithRSHandleOrdering, handles. Values below 1 are ignored.
+// Other requests are handled one after the other by a worker of their own.
+//
+// The default number of workers is SftpServerWorkerCount.
+func WithRSWorkerCount(n int) RequestServerOption {
+	return func(rs *RequestServer) {
+		if n < 1 {
+			return
+		}
+
+		rs.pktMgr.workers = n
+	}
+}
+
+// WithRSHandleOrdering makes a RequestServer handle the requests on a handle one after the other,
+// in the order they are received, while the requests on different handles run in parallel.
+// By default, the reads and writes on a file run in parallel.
+//
+// This lets Handlers whose files cannot be written concurrently, such as an append-only object store,
+// serve several files at once.
+func WithRSHandleOrdering() RequestServerOption {
+	return func(rs *RequestServer) {
+		rs.pktMgr.perHandle = true
+	}
+}
+
 // WithStartDirectory sets a start directory to use as base for relative paths.
 // If unset the default is "/"
 func WithStartDirectory(startDirectory string) RequestServerOption {
@@ -74,6 +114,80 @@ func WithRSMaxTxPacket(size uint32) RequestServerOption {
 	}
 }
 
+// WithRSAuthorizer configures a RequestServer to ask the Authorizer about every request on a path,
+// and to deny the requests for which it returns an error, before calling the Handlers.
+// See Authorizer.
+func WithRSAuthorizer(a Authorizer) RequestServerOption {
+	return func(rs *RequestServer) {
+		rs.authorizer = a
+	}
+}
+
+// WithRSSessionIdentity sets the identity of the client the RequestServer serves,
+// such as its SSH user name, passed to the Authorizer and recorded by the AuditSink.
+func WithRSSessionIdentity(identity string) RequestServerOption {
+	return func(rs *RequestServer) {
+		rs.identity = identity
+	}
+}
+
+// WithRSAuditSink configures a RequestServer to record every operation to sink, see AuditSink.
+func WithRSAuditSink(sink AuditSink) RequestServerOption {
+	return func(rs *RequestServer) {
+		rs.auditSink = sink
+	}
+}
+
+// WithRSSessionID sets the identifier of the RequestServer in audit records,
+// for example to match the logs of the SSH server.
+// By default, every RequestServer gets a random one.
+func WithRSSessionID(id string) RequestServerOption {
+	return func(rs *RequestServer) {
+		rs.sessionID = id
+	}
+}
+
+// WithRSMaxOpenHandles limits the files and directories a client may have open at once to n.
+// Opening more fails with ErrTooManyHandles, without calling the Handlers.
+// By default, or if n is 0, the number of handles is not limited.
+func WithRSMaxOpenHandles(n int) RequestServerOption {
+	return func(rs *RequestServer) {
+		if n < 0 {
+			return
+		}
+
+		rs.maxHandles = n
+	}
+}
+
+// WithRSHandleIdleTimeout closes the files and directories left open by a client
+// without a request for longer than d, passing ErrHandleIdle to TransferError,
+// counting from the end of the last request: a handle is never closed while a request on it is in progress.
+// Handles are checked every d/2, so that they may stay open for up to 1.5 times d.
+// By default, or if d is 0, handles stay open until the client closes them.
+func WithRSHandleIdleTimeout(d time.Duration) RequestServerOption {
+	return func(rs *RequestServer) {
+		if d < 0 {
+			return
+		}
+
+		rs.idleTimeout = d
+	}
+}
+
+// WithRSQuota enforces quota on a RequestServer, counting the usage in store under key, such as a user name.
+// Servers sharing store share the usage of key, for a quota that holds across sessions;
+// if store is nil, the usage is counted for the RequestServer alone.
+//
+// Writes, creations of files and changes of size beyond the quota fail with ErrSSHFxQuotaExceeded,
+// without calling the Handlers, and StatVFS reports no more space or files than what is left of the quota.
+// Whether a file opened with the create flag exists is asked to the FileLister, with a Stat Request.
+func WithRSQuota(quota Quota, store QuotaStore, key string) RequestServerOption {
+	return func(rs *RequestServer) {
+		rs.quota = newQuotaEnforcer(quota, store, key)
+	}
+}
+
 // NewRequestServer creates/allocates/returns new RequestServer.
 // Normally there will be one server per user-session.
 func NewRequestServer(rwc io.ReadWriteCloser, h Handlers, options ...RequestServerOption) *RequestServer {
@@ -91,6 +205,7 @@ func NewRequestServer(rwc io.ReadWriteCloser, h Handlers, options ...RequestServ
 
 		startDirectory: "/",
 		maxTxPacket:    defaultMaxTxPacket,
+		sessionID:      newSessionID(),
 
 		openRequests: make(map[string]*Request),
 	}
@@ -102,16 +217,25 @@ func NewRequestServer(rwc io.ReadWriteCloser, h Handlers, options ...RequestServ
 }
 
 // New Open packet/Request
-func (rs *RequestServer) nextRequest(r *Request) string {
+func (rs *RequestServer) nextRequest(r *Request) (string, error) {
 	rs.mu.Lock()
 	defer rs.mu.Unlock()
 
+	if rs.maxHandles > 0 && len(rs.openRequests) >= rs.maxHandles {
+		return "", ErrTooManyHandles
+	}
+
 	rs.handleCount++
 
+	if r.stats == nil {
+		r.stats = &handleStats{opened: time.Now()}
+	}
+	r.stats.touch(r.stats.opened)
+
 	r.handle = strconv.Itoa(rs.handleCount)
 	rs.openRequests[r.handle] = r
 
-	return r.handle
+	return r.handle, nil
 }
 
 // Returns Request from openRequests, bool is false if it is missing.
@@ -124,20 +248,80 @@ func (rs *RequestServer) getRequest(handle string) (*Request, bool) {
 	defer rs.mu.RUnlock()
 
 	r, ok := rs.openRequests[handle]
+	if ok {
+		r.stats.touch(time.Now())
+	}
 	return r, ok
 }
 
+// beginHandle records the start of pkt on the Request of the handle it applies to, if any,
+// so that the Request is not closed as idle while pkt is in progress.
+// It returns the stats of the Request, to call end on once pkt is handled.
+func (rs *RequestServer) beginHandle(pkt requestPacket) *handleStats {
+	handle, ok := packetHandle(pkt)
+	if !ok {
+		return nil
+	}
+
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+
+	r, ok := rs.openRequests[handle]
+	if !ok {
+		return nil
+	}
+	r.stats.begin(time.Now())
+	return r.stats
+}
+
 // Close the Request and clear from openRequests map
 func (rs *RequestServer) closeRequest(handle string) error {
+	rs.mu.Lock()
+	r, ok := rs.openRequests[handle]
+	if !ok {
+		rs.mu.Unlock()
+		return EBADF
+	}
+	delete(rs.openRequests, handle)
+	err := r.close()
+	rs.mu.Unlock()
+
+	if err == nil && rs.onClose != nil && writable(r.Method) {
+		err = rs.runOnClose(r)
+	}
+	rs.auditRequest(r, err)
+	return err
+}
+
+// discardRequest closes the Request of a handle that failed to open, and clears it from openRequests,
+// leaving the failure to be recorded as the response to the open.
+func (rs *RequestServer) discardRequest(handle string) {
 	rs.mu.Lock()
 	defer rs.mu.Unlock()
 
 	if r, ok := rs.openRequests[handle]; ok {
 		delete(rs.openRequests, handle)
-		return r.close()
+		r.close()
 	}
+}
 
-	return EBADF
+// reapIdleRequests closes the Requests idle for longer than the idle timeout at now.
+func (rs *RequestServer) reapIdleRequests(now time.Time) {
+	var idle []*Request
+	rs.mu.Lock()
+	for handle, r := range rs.openRequests {
+		if r.stats.idle(now, rs.idleTimeout) {
+			idle = append(idle, r)
+			delete(rs.openRequests, handle)
+		}
+	}
+	rs.mu.Unlock()
+
+	for _, r := range idle {
+		r.transferError(ErrHandleIdle)
+		r.close()
+		rs.auditRequest(r, ErrHandleIdle)
+	}
 }
 
 // Close the read/write/closer to trigger exiting the main server loop
@@ -197,9 +381,16 @@ func (rs *RequestServer) Serve() error {
 	}
 	pktChan := rs.pktMgr.workerChan(runWorker)
 
+	stopReaper := startReaper(rs.idleTimeout, rs.reapIdleRequests)
+
 	err := rs.serveLoop(pktChan)
 
 	wg.Wait() // wait for all workers to exit
+	stopReaper()
+
+	if rs.shuttingDown.Load() {
+		err = ErrServerShutdown
+	}
 
 	rs.mu.Lock()
 	defer rs.mu.Unlock()
@@ -214,6 +405,12 @@ func (rs *RequestServer) Serve() error {
 
 		delete(rs.openRequests, handle)
 		req.close()
+
+		lostErr := err
+		if lostErr == nil {
+			lostErr = io.ErrUnexpectedEOF
+		}
+		rs.auditRequest(req, lostErr)
 	}
 
 	return err
@@ -221,6 +418,7 @@ func (rs *RequestServer) Serve() error {
 
 func (rs *RequestServer) packetWorker(ctx context.Context, pktChan chan orderedRequest) error {
 	for pkt := range pktChan {
+		start := time.Now()
 		orderID := pkt.orderID()
 		if epkt, ok := pkt.requestPacket.(*sshFxpExtendedPacket); ok {
 			if epkt.SpecificPacket != nil {
@@ -229,6 +427,33 @@ func (rs *RequestServer) packetWorker(ctx context.Context, pktChan chan orderedR
 		}
 
 		var rpkt responsePacket
+		if rs.shuttingDown.Load() && opensHandle(pkt.requestPacket) {
+			rpkt = statusFromError(pkt.id(), ErrServerShutdown)
+			rs.auditPacket(pkt.requestPacket, rpkt, start)
+			rs.pktMgr.readyPacket(
+				rs.pktMgr.newOrderedResponse(rpkt, orderID))
+			continue
+		}
+
+		if err := rs.authorize(pkt.requestPacket); err != nil {
+			rpkt = statusFromError(pkt.id(), err)
+			rs.auditPacket(pkt.requestPacket, rpkt, start)
+			rs.pktMgr.readyPacket(
+				rs.pktMgr.newOrderedResponse(rpkt, orderID))
+			continue
+		}
+
+		reserved, err := rs.quota.reserve(pkt.requestPacket, rs.exists)
+		if err != nil {
+			rpkt = statusFromError(pkt.id(), err)
+			rs.auditPacket(pkt.requestPacket, rpkt, start)
+			rs.pktMgr.readyPacket(
+				rs.pktMgr.newOrderedResponse(rpkt, orderID))
+			continue
+		}
+
+		st := rs.beginHandle(pkt.requestPacket)
+
 		switch pkt := pkt.requestPacket.(type) {
 		case *sshFxInitPacket:
 			rpkt = &sshFxVersionPacket{Version: sftpProtocolVersion, Extensions: sftpExtensions}
@@ -254,19 +479,31 @@ func (rs *RequestServer) packetWorker(ctx context.Context, pktChan chan orderedR
 			}
 		case *sshFxpOpendirPacket:
 			request := requestFromPacket(ctx, pkt, rs.startDirectory)
-			handle := rs.nextRequest(request)
+			request.stats = &handleStats{opened: start}
+			handle, err := rs.nextRequest(request)
+			if err != nil {
+				request.close()
+				rpkt = statusFromError(pkt.ID, err)
+				break
+			}
 			rpkt = request.opendir(rs.Handlers, pkt)
 			if _, ok := rpkt.(*sshFxpHandlePacket); !ok {
 				// if we return an error we have to remove the handle from the active ones
-				rs.closeRequest(handle)
+				rs.discardRequest(handle)
 			}
 		case *sshFxpOpenPacket:
 			request := requestFromPacket(ctx, pkt, rs.startDirectory)
-			handle := rs.nextRequest(request)
+			request.stats = &handleStats{opened: start}
+			handle, err := rs.nextRequest(request)
+			if err != nil {
+				request.close()
+				rpkt = statusFromError(pkt.ID, err)
+				break
+			}
 			rpkt = request.open(rs.Handlers, pkt)
 			if _, ok := rpkt.(*sshFxpHandlePacket); !ok {
 				// if we return an error we have to remove the handle from the active ones
-				rs.closeRequest(handle)
+				rs.discardRequest(handle)
 			}
 		case *sshFxpFstatPacket:
 			handle := pkt.getHandle()
@@ -289,7 +526,9 @@ func (rs *RequestServer) packetWorker(ctx context.Context, pktChan chan orderedR
 				request = &Request{
 					Method:   "Setstat",
 					Filepath: cleanPathWithBase(rs.startDirectory, request.Filepath),
+					Flags:    pkt.Flags,
 				}
+				request.Attrs, _ = pkt.Attrs.([]byte)
 				rpkt = request.call(rs.Handlers, pkt, rs.pktMgr.alloc, orderID, rs.maxTxPacket)
 			}
 		case *sshFxpExtendedPacketPosixRename:
@@ -305,6 +544,18 @@ func (rs *RequestServer) packetWorker(ctx context.Context, pktChan chan orderedR
 				Filepath: cleanPathWithBase(rs.startDirectory, pkt.Path),
 			}
 			rpkt = request.call(rs.Handlers, pkt, rs.pktMgr.alloc, orderID, rs.maxTxPacket)
+			if stat, ok := rpkt.(*StatVFS); ok {
+				if err := rs.quota.statVFS(stat); err != nil {
+					rpkt = statusFromError(pkt.ID, err)
+				}
+			}
+		case *sshFxpExtendedPacketFsync:
+			request, ok := rs.getRequest(pkt.Handle)
+			if !ok {
+				rpkt = statusFromError(pkt.ID, EBADF)
+			} else {
+				rpkt = statusFromError(pkt.ID, request.sync())
+			}
 		case hasHandle:
 			handle := pkt.getHandle()
 			request, ok := rs.getRequest(handle)
@@ -321,6 +572,12 @@ func (rs *RequestServer) packetWorker(ctx context.Context, pktChan chan orderedR
 			rpkt = statusFromError(pkt.id(), ErrSSHFxOpUnsupported)
 		}
 
+		if st != nil {
+			st.end(time.Now())
+		}
+
+		rs.quota.settle(reserved, rpkt)
+		rs.auditPacket(pkt.requestPacket, rpkt, start)
 		rs.pktMgr.readyPacket(
 			rs.pktMgr.newOrderedResponse(rpkt, orderID))
 	}
@@ -346,6 +603,101 @@ func cleanPath(p string) string {
 	return cleanPathWithBase("/", p)
 }
 
+// exists reports whether the file at the path p sent by the client exists, asking the FileLister.
+func (rs *RequestServer) exists(p string) bool {
+	_, err := rs.stat(cleanPathWithBase(rs.startDirectory, p))
+	return err == nil
+}
+
+// stat returns the FileInfo of the file at the absolute path p, asking the FileLister.
+func (rs *RequestServer) stat(p string) (os.FileInfo, error) {
+	lister, err := rs.Handlers.FileList.Filelist(&Request{
+		Method:   "Stat",
+		Filepath: p,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if c, ok := lister.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	finfo := make([]os.FileInfo, 1)
+	n, err := lister.ListAt(finfo, 0)
+	if n == 0 {
+		if err == nil || err == io.EOF {
+			err = &os.PathError{Op: "stat", Path: p, Err: syscall.ENOENT}
+		}
+		return nil, err
+	}
+	return finfo[0], nil
+}
+
+// authClean returns the absolute path the Authorizer sees for the path p sent by the client.
+func (rs *RequestServer) authClean(p string) string {
+	return cleanPathWithBase(rs.startDirectory, p)
+}
+
+// authHandlePath returns the path handle was opened with.
+func (rs *RequestServer) authHandlePath(handle string) (string, bool) {
+	r, ok := rs.getRequest(handle)
+	if !ok {
+		return "", false
+	}
+	return r.Filepath, true
+}
+
+// authorize asks the Authorizer, if any, whether pkt may be handled.
+func (rs *RequestServer) authorize(pkt requestPacket) error {
+	if rs.authorizer == nil {
+		return nil
+	}
+
+	req := newAuthRequest(pkt, rs.authClean, rs.authHandlePath)
+	if req == nil {
+		return nil
+	}
+	req.Identity = rs.identity
+
+	return rs.authorizer.Authorize(req)
+}
+
+// auditPacket records pkt, answered with rpkt, to the AuditSink, if any.
+func (rs *RequestServer) auditPacket(pkt requestPacket, rpkt responsePacket, start time.Time) {
+	if rs.auditSink == nil {
+		return
+	}
+
+	req := newAuthRequest(pkt, rs.authClean, rs.authHandlePath)
+	if req == nil {
+		return
+	}
+
+	rs.audit(newAuditRecord(req, rpkt, start))
+}
+
+// auditRequest records the handle r, closed with err, to the AuditSink, if any.
+func (rs *RequestServer) auditRequest(r *Request, err error) {
+	if rs.auditSink == nil || r.stats == nil {
+		return
+	}
+
+	r.stats.method = r.Method
+	r.stats.path = r.Filepath
+	rs.audit(newHandleAuditRecord(r.stats, err))
+}
+
+// audit records rec to the AuditSink, if any.
+func (rs *RequestServer) audit(rec *AuditRecord) {
+	if rs.auditSink == nil || rec == nil {
+		return
+	}
+
+	rec.Session = rs.sessionID
+	rec.Identity = rs.identity
+	rs.auditSink.Audit(rec)
+}
+
 func cleanPathWithBase(base, p string) string {
 	p = filepath.ToSlash(filepath.Clean(p))
 	if !path.IsAbs(p) {
@@ -2,6 +2,7 @@ package sftp
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -9,6 +10,8 @@ import (
 	"os"
 	"path"
 	"runtime"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -93,6 +96,149 @@ func clientRequestServerPair(t *testing.T, options ...RequestServerOption) *csPa
 	return clientRequestServerPairWithHandlers(t, InMemHandler(), options...)
 }
 
+var errInjected = errors.New("injected failure")
+
+// instrumentedHandlers wraps InMemHandler, counting the reads and writes that reach it,
+// and failing the writes and commands selected by the test.
+type instrumentedHandlers struct {
+	Handlers
+
+	reads, writes atomic.Int64
+
+	// failWrite, if set, fails every write it returns true for.
+	failWrite func(b []byte, off int64) bool
+
+	mu       sync.Mutex
+	calls    map[string]int
+	flaky    map[string]bool
+	failures int
+}
+
+func newInstrumentedHandlers() *instrumentedHandlers {
+	return &instrumentedHandlers{
+		Handlers: InMemHandler(),
+		calls:    make(map[string]int),
+		flaky:    make(map[string]bool),
+	}
+}
+
+// failFirst fails the first failures calls of the given methods with SSH_FX_FAILURE.
+// Commands are carried out before failing, as if the response had been lost.
+func (h *instrumentedHandlers) failFirst(failures int, methods ...string) *instrumentedHandlers {
+	h.failures = failures
+	for _, method := range methods {
+		h.flaky[method] = true
+	}
+	return h
+}
+
+func (h *instrumentedHandlers) fail(method string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.calls[method]++
+	return h.flaky[method] && h.calls[method] <= h.failures
+}
+
+// called returns the number of calls of the given list or command method.
+func (h *instrumentedHandlers) called(method string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.calls[method]
+}
+
+func (h *instrumentedHandlers) handlers() Handlers {
+	return Handlers{
+		FileGet:  h,
+		FilePut:  h,
+		FileCmd:  h,
+		FileList: h,
+	}
+}
+
+func (h *instrumentedHandlers) Fileread(r *Request) (io.ReaderAt, error) {
+	ra, err := h.Handlers.FileGet.Fileread(r)
+	if err != nil {
+		return nil, err
+	}
+	return instrumentedReaderAt{ra, h}, nil
+}
+
+func (h *instrumentedHandlers) Filewrite(r *Request) (io.WriterAt, error) {
+	wa, err := h.Handlers.FilePut.Filewrite(r)
+	if err != nil {
+		return nil, err
+	}
+	return instrumentedWriterAt{wa, h}, nil
+}
+
+func (h *instrumentedHandlers) OpenFile(r *Request) (WriterAtReaderAt, error) {
+	rw, err := h.Handlers.FilePut.(OpenFileWriter).OpenFile(r)
+	if err != nil {
+		return nil, err
+	}
+	return instrumentedReadWriterAt{instrumentedReaderAt{rw, h}, instrumentedWriterAt{rw, h}}, nil
+}
+
+func (h *instrumentedHandlers) Filelist(r *Request) (ListerAt, error) {
+	if h.fail(r.Method) {
+		return nil, errInjected
+	}
+	return h.Handlers.FileList.Filelist(r)
+}
+
+func (h *instrumentedHandlers) Filecmd(r *Request) error {
+	if err := h.Handlers.FileCmd.Filecmd(r); err != nil {
+		return err
+	}
+	if h.fail(r.Method) {
+		return errInjected
+	}
+	return nil
+}
+
+type instrumentedReaderAt struct {
+	io.ReaderAt
+	h *instrumentedHandlers
+}
+
+func (r instrumentedReaderAt) ReadAt(b []byte, off int64) (int, error) {
+	r.h.reads.Add(1)
+	return r.ReaderAt.ReadAt(b, off)
+}
+
+type instrumentedWriterAt struct {
+	io.WriterAt
+	h *instrumentedHandlers
+}
+
+func (w instrumentedWriterAt) WriteAt(b []byte, off int64) (int, error) {
+	w.h.writes.Add(1)
+	if w.h.failWrite != nil && w.h.failWrite(b, off) {
+		return 0, errInjected
+	}
+	return w.WriterAt.WriteAt(b, off)
+}
+
+type instrumentedReadWriterAt struct {
+	instrumentedReaderAt
+	instrumentedWriterAt
+}
+
+// instrumentedPair connects a Client to a RequestServer serving h,
+// and applies opts to the Client.
+func instrumentedPair(t *testing.T, h *instrumentedHandlers, opts ...ClientOption) *csPair {
+	p := clientRequestServerPairWithHandlers(t, h.handlers())
+	t.Cleanup(p.Close)
+
+	for _, opt := range opts {
+		require.NoError(t, opt(p.cli))
+	}
+
+	return p
+}
+
 func checkRequestServerAllocator(t *testing.T, p *csPair) {
 	if p.svr.pktMgr.alloc == nil {
 		return
@@ -126,8 +272,10 @@ func TestRequestCache(t *testing.T) {
 	foo := NewRequest("", "foo")
 	foo.ctx, foo.cancelCtx = context.WithCancel(context.Background())
 	bar := NewRequest("", "bar")
-	fh := p.svr.nextRequest(foo)
-	bh := p.svr.nextRequest(bar)
+	fh, err := p.svr.nextRequest(foo)
+	require.NoError(t, err)
+	bh, err := p.svr.nextRequest(bar)
+	require.NoError(t, err)
 	assert.Len(t, p.svr.openRequests, 2)
 	_foo, ok := p.svr.getRequest(fh)
 	assert.Equal(t, foo.Method, _foo.Method)
@@ -252,7 +400,7 @@ func TestRequestOpenFail(t *testing.T) {
 	p := clientRequestServerPair(t)
 	defer p.Close()
 	rf, err := p.cli.Open("/foo")
-	assert.Exactly(t, os.ErrNotExist, err)
+	assert.True(t, os.IsNotExist(err))
 	assert.Nil(t, rf)
 	// if we return an error the sftp client will not close the handle
 	// ensure that we close it ourself
@@ -431,7 +579,9 @@ func TestRequestRenameFail(t *testing.T) {
 	_, err = putTestFile(p.cli, "/bar", "goodbye")
 	require.NoError(t, err)
 	err = p.cli.Rename("/foo", "/bar")
-	assert.IsType(t, &StatusError{}, err)
+	var status *StatusError
+	assert.ErrorAs(t, err, &status)
+	assert.IsType(t, &os.LinkError{}, err)
 	checkRequestServerAllocator(t, p)
 }
 
@@ -780,15 +930,17 @@ func TestRequestReaddir(t *testing.T) {
 		}
 	}
 	_, err := p.cli.ReadDir("/foo_01")
+	var status *StatusError
+	require.ErrorAs(t, err, &status)
 	if runtime.GOOS == "zos" {
 		assert.Equal(t, &StatusError{Code: sshFxFailure,
-			msg: " /foo_01: EDC5135I Not a directory."}, err)
+			msg: " /foo_01: EDC5135I Not a directory."}, status)
 	} else {
 		assert.Equal(t, &StatusError{Code: sshFxFailure,
-			msg: " /foo_01: not a directory"}, err)
+			msg: " /foo_01: not a directory"}, status)
 	}
 	_, err = p.cli.ReadDir("/does_not_exist")
-	assert.Equal(t, os.ErrNotExist, err)
+	assert.True(t, os.IsNotExist(err))
 	di, err := p.cli.ReadDir("/")
 	require.NoError(t, err)
 	require.Len(t, di, 100)
@@ -867,6 +1019,50 @@ func TestRequestStatVFSError(t *testing.T) {
 	checkRequestServerAllocator(t, p)
 }
 
+// noSyncFileWriter hides the Sync method of the files it opens for writing.
+type noSyncFileWriter struct {
+	FileWriter
+}
+
+func (w noSyncFileWriter) Filewrite(r *Request) (io.WriterAt, error) {
+	wr, err := w.FileWriter.Filewrite(r)
+	return struct{ io.WriterAt }{wr}, err
+}
+
+func TestRequestFsync(t *testing.T) {
+	p := clientRequestServerPair(t)
+	defer p.Close()
+
+	_, ok := p.cli.HasExtension("fsync@openssh.com")
+	require.True(t, ok, "request server doesn't list fsync extension")
+
+	f, err := p.cli.Create("/foo")
+	require.NoError(t, err)
+	defer f.Close()
+
+	_, err = f.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, f.Sync())
+
+	checkRequestServerAllocator(t, p)
+}
+
+func TestRequestFsyncUnsupported(t *testing.T) {
+	handlers := InMemHandler()
+	handlers.FilePut = noSyncFileWriter{handlers.FilePut}
+
+	p := clientRequestServerPairWithHandlers(t, handlers)
+	defer p.Close()
+
+	f, err := p.cli.Create("/foo")
+	require.NoError(t, err)
+	defer f.Close()
+
+	var statusErr *StatusError
+	require.ErrorAs(t, f.Sync(), &statusErr)
+	assert.EqualValues(t, sshFxOPUnsupported, statusErr.Code)
+}
+
 func TestRequestStartDirOption(t *testing.T) {
 	startDir := "/start/dir"
 	p := clientRequestServerPair(t, WithStartDirectory(startDir))
@@ -916,8 +1112,9 @@ func TestUncleanDisconnect(t *testing.T) {
 	defer p.Close()
 
 	foo := NewRequest("", "foo")
-	p.svr.nextRequest(foo)
-	err := p.cli.conn.Close()
+	_, err := p.svr.nextRequest(foo)
+	require.NoError(t, err)
+	err = p.cli.conn.Close()
 	require.NoError(t, err)
 	// the foo request above is still open after the client disconnects
 	// so the server will convert io.EOF to io.ErrUnexpectedEOF
@@ -147,6 +147,7 @@ type Request struct {
 	Attrs    []byte // convert to sub-struct
 	Target   string // for renames and sym-links
 	handle   string
+	stats    *handleStats // of an open handle, for the audit sink.
 
 	// reader/writer/readdir from handlers
 	state
@@ -175,6 +176,7 @@ func (r *Request) copy() *Request {
 		Attrs:    r.Attrs,
 		Target:   r.Target,
 		handle:   r.handle,
+		stats:    r.stats,
 
 		state: r.state.copy(),
 
@@ -198,6 +200,9 @@ func requestFromPacket(ctx context.Context, pkt hasPath, baseDir string) *Reques
 	case *sshFxpSetstatPacket:
 		request.Flags = p.Flags
 		request.Attrs = p.Attrs.([]byte)
+	case *sshFxpMkdirPacket:
+		request.Flags = p.Flags
+		request.Attrs = p.Attrs.([]byte)
 	case *sshFxpRenamePacket:
 		request.Target = cleanPathWithBase(baseDir, p.Newpath)
 	case *sshFxpSymlinkPacket:
@@ -278,6 +283,30 @@ func (r *Request) close() error {
 	return err
 }
 
+// Flush the handle to stable storage, if the handler supports it
+func (r *Request) sync() error {
+	_, wr, rw := r.getAllReaderWriters()
+
+	if s, ok := rw.(SyncWriterAt); ok {
+		return s.Sync()
+	}
+
+	if s, ok := wr.(SyncWriterAt); ok {
+		return s.Sync()
+	}
+
+	return ErrSSHFxOpUnsupported
+}
+
+// Count the bytes transferred through the handle, for the audit sink
+func (r *Request) countTransfer(read, written int) {
+	if r.stats == nil {
+		return
+	}
+	r.stats.read.Add(int64(read))
+	r.stats.written.Add(int64(written))
+}
+
 // Notify transfer error if any
 func (r *Request) transferError(err error) {
 	if err == nil {
@@ -401,6 +430,7 @@ func fileget(h FileReader, r *Request, pkt requestPacket, alloc *allocator, orde
 	data, offset, _ := packetData(pkt, alloc, orderID, maxTxPacket)
 
 	n, err := rd.ReadAt(data, offset)
+	r.countTransfer(n, 0)
 	// only return EOF error if no data left to read
 	if err != nil && (err != io.EOF || n == 0) {
 		return statusFromError(pkt.id(), err)
@@ -422,7 +452,8 @@ func fileput(h FileWriter, r *Request, pkt requestPacket, alloc *allocator, orde
 
 	data, offset, _ := packetData(pkt, alloc, orderID, maxTxPacket)
 
-	_, err := wr.WriteAt(data, offset)
+	n, err := wr.WriteAt(data, offset)
+	r.countTransfer(0, n)
 	return statusFromError(pkt.id(), err)
 }
 
@@ -438,6 +469,7 @@ func fileputget(h FileWriter, r *Request, pkt requestPacket, alloc *allocator, o
 		data, offset := p.getDataSlice(alloc, orderID, maxTxPacket), int64(p.Offset)
 
 		n, err := rw.ReadAt(data, offset)
+		r.countTransfer(n, 0)
 		// only return EOF error if no data left to read
 		if err != nil && (err != io.EOF || n == 0) {
 			return statusFromError(pkt.id(), err)
@@ -452,7 +484,8 @@ func fileputget(h FileWriter, r *Request, pkt requestPacket, alloc *allocator, o
 	case *sshFxpWritePacket:
 		data, offset := p.Data, int64(p.Offset)
 
-		_, err := rw.WriteAt(data, offset)
+		n, err := rw.WriteAt(data, offset)
+		r.countTransfer(0, n)
 		return statusFromError(pkt.id(), err)
 
 	default:
@@ -0,0 +1,121 @@
+package sftp
+
+import (
+	"errors"
+	"math/rand/v2"
+	"time"
+)
+
+// RetryPolicy configures how a Client retries requests that failed transiently.
+//
+// Only requests that are safe to repeat are retried:
+// Stat, Lstat, File.Stat, ReadAt and reads, WriteAt and writes at a fixed offset,
+// and Mkdir, where an already existing directory counts as success on a retry.
+// Rename, PosixRename, Remove, Link, Symlink and writes to a file opened with O_APPEND
+// are never retried, as the server might already have carried out the failed request.
+//
+// A request is retried when the server answers with SSH_FX_FAILURE or SSH_FX_CONNECTION_LOST,
+// as long as the Client itself is still connected.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts made for a single request,
+	// including the first one. A value below 2 disables retries.
+	MaxAttempts int
+
+	// InitialBackoff is the delay before the first retry. The default is 100 milliseconds.
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the delay between two attempts. The default is 5 seconds.
+	MaxBackoff time.Duration
+
+	// Multiplier is the factor by which the delay grows after each retry. The default is 2.
+	Multiplier float64
+
+	// Jitter randomizes each delay by up to the given fraction of it, in either direction.
+	// It must be between 0 and 1.
+	Jitter float64
+
+	// OnRetry, if set, is called before every retry,
+	// with the name of the operation, the number of the attempt about to be made,
+	// and the error that caused the retry.
+	OnRetry func(op string, attempt int, err error)
+}
+
+// WithRetryPolicy enables retrying transiently failed idempotent requests,
+// according to the given policy.
+//
+// By default, no request is retried.
+func WithRetryPolicy(p RetryPolicy) ClientOption {
+	return func(c *Client) error {
+		if p.Jitter < 0 || p.Jitter > 1 {
+			return errors.New("jitter must be between 0 and 1")
+		}
+		if p.InitialBackoff <= 0 {
+			p.InitialBackoff = 100 * time.Millisecond
+		}
+		if p.MaxBackoff <= 0 {
+			p.MaxBackoff = 5 * time.Second
+		}
+		if p.Multiplier < 1 {
+			p.Multiplier = 2
+		}
+		c.retryPolicy = &p
+		return nil
+	}
+}
+
+// retryable reports whether err is a transient failure worth retrying the request for.
+func (c *Client) retryable(err error) bool {
+	var status *StatusError
+	if !errors.As(err, &status) {
+		return false
+	}
+
+	switch status.Code {
+	case sshFxFailure, sshFxConnectionLost:
+		// A server cannot report a lost connection over the connection itself,
+		// but proxies and multiplexers can report the loss of their upstream.
+		return !c.isClosed()
+	}
+
+	return false
+}
+
+// retry calls fn until it succeeds, fails with a non-transient error, or the retry policy is exhausted.
+// The attempt passed to fn starts at 1.
+// It must only be used for idempotent requests.
+func (c *Client) retry(op string, fn func(attempt int) error) error {
+	p := c.retryPolicy
+	if p == nil {
+		return fn(1)
+	}
+
+	backoff := p.InitialBackoff
+
+	for attempt := 1; ; attempt++ {
+		err := fn(attempt)
+		if err == nil || attempt >= p.MaxAttempts || !c.retryable(err) {
+			return err
+		}
+
+		if p.OnRetry != nil {
+			p.OnRetry(op, attempt+1, err)
+		}
+
+		delay := backoff
+		if p.Jitter > 0 {
+			delay += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(backoff))
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-c.closed:
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+
+		if backoff = time.Duration(float64(backoff) * p.Multiplier); backoff > p.MaxBackoff {
+			backoff = p.MaxBackoff
+		}
+	}
+}
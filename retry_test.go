@@ -0,0 +1,84 @@
+package sftp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordRetries returns a retry policy option, and the attempts it retried.
+func recordRetries(maxAttempts int) (ClientOption, *[]int) {
+	var attempts []int
+	opt := WithRetryPolicy(RetryPolicy{
+		MaxAttempts:    maxAttempts,
+		InitialBackoff: time.Millisecond,
+		Jitter:         0.5,
+		OnRetry: func(op string, attempt int, err error) {
+			attempts = append(attempts, attempt)
+		},
+	})
+
+	return opt, &attempts
+}
+
+func TestClientRetryStat(t *testing.T) {
+	h := newInstrumentedHandlers().failFirst(2, "Stat")
+	retry, attempts := recordRetries(3)
+	p := instrumentedPair(t, h, retry)
+
+	_, err := p.cli.Stat("/")
+	require.NoError(t, err)
+	assert.Equal(t, 3, h.called("Stat"))
+	assert.Equal(t, []int{2, 3}, *attempts)
+}
+
+func TestClientRetryExhausted(t *testing.T) {
+	h := newInstrumentedHandlers().failFirst(5, "Stat")
+	retry, _ := recordRetries(3)
+	p := instrumentedPair(t, h, retry)
+
+	_, err := p.cli.Stat("/")
+	var status *StatusError
+	require.ErrorAs(t, err, &status)
+	assert.Equal(t, uint32(sshFxFailure), status.Code)
+	assert.Equal(t, 3, h.called("Stat"))
+}
+
+func TestClientRetryMkdirExists(t *testing.T) {
+	h := newInstrumentedHandlers().failFirst(1, "Mkdir")
+	retry, attempts := recordRetries(3)
+	p := instrumentedPair(t, h, retry)
+
+	// the first attempt creates the directory, but reports a failure.
+	require.NoError(t, p.cli.Mkdir("/dir"))
+	assert.Equal(t, []int{2}, *attempts)
+
+	fi, err := p.cli.Stat("/dir")
+	require.NoError(t, err)
+	assert.True(t, fi.IsDir())
+}
+
+func TestClientRetryNotIdempotent(t *testing.T) {
+	h := newInstrumentedHandlers().failFirst(1, "Rename")
+	retry, attempts := recordRetries(3)
+	p := instrumentedPair(t, h, retry)
+
+	f, err := p.cli.Create("/foo")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	assert.Error(t, p.cli.Rename("/foo", "/bar"))
+	assert.Equal(t, 1, h.called("Rename"))
+	assert.Empty(t, *attempts)
+}
+
+func TestClientNoRetryPolicy(t *testing.T) {
+	h := newInstrumentedHandlers().failFirst(1, "Stat")
+	p := instrumentedPair(t, h)
+
+	_, err := p.cli.Stat("/")
+	assert.Error(t, err)
+	assert.Equal(t, 1, h.called("Stat"))
+}
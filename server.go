@@ -7,33 +7,21 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"io/fs"
 	"io/ioutil"
 	"os"
-	"path/filepath"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
 
 const (
-	// SftpServerWorkerCount defines the number of workers for the SFTP server
+	// SftpServerWorkerCount defines the default number of workers for the SFTP server,
+	// see WithWorkerCount and WithRSWorkerCount.
 	SftpServerWorkerCount = 8
 )
 
-type file interface {
-	Stat() (os.FileInfo, error)
-	ReadAt(b []byte, off int64) (int, error)
-	WriteAt(b []byte, off int64) (int, error)
-	Readdir(int) ([]os.FileInfo, error)
-	Name() string
-	Truncate(int64) error
-	Chmod(mode fs.FileMode) error
-	Chown(uid, gid int) error
-	Close() error
-}
-
 // Server is an SSH File Transfer Protocol (sftp) server.
 // This is intended to provide the sftp subsystem to an ssh server daemon.
 // This implementation currently supports most of sftp server protocol version 3,
@@ -43,41 +31,216 @@ type Server struct {
 	debugStream   io.Writer
 	readOnly      bool
 	pktMgr        *packetManager
-	openFiles     map[string]file
+	openFiles     map[string]ServerFile
+	openStats     map[string]*handleStats
 	openFilesLock sync.RWMutex
 	handleCount   int
 	workDir       string
 	winRoot       bool
 	maxTxPacket   uint32
+	fsys          ServerFS
+	root          bool // fsys is set by WithFS or WithRoot, and takes root-relative paths.
+	authorizer    Authorizer
+	identity      string
+	auditSink     AuditSink
+	sessionID     string
+	maxHandles    int
+	reserved      int // handles reserved by reserveHandle, and not yet used by nextHandle.
+	idleTimeout   time.Duration
+	quota         *quotaEnforcer
+	onClose       CloseHook
+	shuttingDown  atomic.Bool
+}
+
+// reserveHandle reserves one of the handles allowed by WithMaxOpenHandles,
+// so that a file is only opened if it can be given a handle.
+// The reservation is used by nextHandle, or given back with releaseHandle.
+func (svr *Server) reserveHandle() error {
+	svr.openFilesLock.Lock()
+	defer svr.openFilesLock.Unlock()
+	if svr.maxHandles > 0 && len(svr.openFiles)+svr.reserved >= svr.maxHandles {
+		return ErrTooManyHandles
+	}
+	svr.reserved++
+	return nil
+}
+
+func (svr *Server) releaseHandle() {
+	svr.openFilesLock.Lock()
+	defer svr.openFilesLock.Unlock()
+	svr.reserved--
 }
 
-func (svr *Server) nextHandle(f file) string {
+// nextHandle records f under a new handle, in place of a handle reserved by reserveHandle.
+func (svr *Server) nextHandle(f ServerFile, st *handleStats) string {
 	svr.openFilesLock.Lock()
 	defer svr.openFilesLock.Unlock()
+	svr.reserved--
 	svr.handleCount++
 	handle := strconv.Itoa(svr.handleCount)
+	st.touch(st.opened)
 	svr.openFiles[handle] = f
+	svr.openStats[handle] = st
 	return handle
 }
 
 func (svr *Server) closeHandle(handle string) error {
 	svr.openFilesLock.Lock()
-	defer svr.openFilesLock.Unlock()
-	if f, ok := svr.openFiles[handle]; ok {
-		delete(svr.openFiles, handle)
-		return f.Close()
+	f, ok := svr.openFiles[handle]
+	st := svr.openStats[handle]
+	delete(svr.openFiles, handle)
+	delete(svr.openStats, handle)
+	svr.openFilesLock.Unlock()
+
+	if !ok {
+		return EBADF
 	}
 
-	return EBADF
+	err := f.Close()
+	if err == nil && svr.onClose != nil && writable(st.method) {
+		err = svr.runOnClose(st)
+	}
+	svr.audit(newHandleAuditRecord(st, err))
+	return err
 }
 
-func (svr *Server) getHandle(handle string) (file, bool) {
+func (svr *Server) getHandle(handle string) (ServerFile, bool) {
 	svr.openFilesLock.RLock()
 	defer svr.openFilesLock.RUnlock()
 	f, ok := svr.openFiles[handle]
+	if ok {
+		svr.openStats[handle].touch(time.Now())
+	}
 	return f, ok
 }
 
+// reapIdleHandles closes the handles idle for longer than the idle timeout at now.
+func (svr *Server) reapIdleHandles(now time.Time) {
+	type idleHandle struct {
+		f  ServerFile
+		st *handleStats
+	}
+
+	var idle []idleHandle
+	svr.openFilesLock.Lock()
+	for handle, st := range svr.openStats {
+		if st.idle(now, svr.idleTimeout) {
+			idle = append(idle, idleHandle{f: svr.openFiles[handle], st: st})
+			delete(svr.openFiles, handle)
+			delete(svr.openStats, handle)
+		}
+	}
+	svr.openFilesLock.Unlock()
+
+	for _, h := range idle {
+		fmt.Fprintf(svr.debugStream, "sftp server file %v closed after being idle\n", h.f.Name())
+		h.f.Close()
+		svr.audit(newHandleAuditRecord(h.st, ErrHandleIdle))
+	}
+}
+
+// beginHandle records the start of pkt on the handle it applies to, if any,
+// so that the handle is not closed as idle while pkt is in progress.
+// It returns the stats of the handle, to call end on once pkt is handled.
+func (svr *Server) beginHandle(pkt requestPacket) *handleStats {
+	handle, ok := packetHandle(pkt)
+	if !ok {
+		return nil
+	}
+
+	svr.openFilesLock.RLock()
+	defer svr.openFilesLock.RUnlock()
+
+	st, ok := svr.openStats[handle]
+	if !ok {
+		return nil
+	}
+	st.begin(time.Now())
+	return st
+}
+
+func (svr *Server) getHandleStats(handle string) (*handleStats, bool) {
+	svr.openFilesLock.RLock()
+	defer svr.openFilesLock.RUnlock()
+	st, ok := svr.openStats[handle]
+	return st, ok
+}
+
+func (svr *Server) getHandlePath(handle string) (string, bool) {
+	st, ok := svr.getHandleStats(handle)
+	if !ok {
+		return "", false
+	}
+	return st.path, true
+}
+
+// exists reports whether the file at the path p sent by the client exists.
+func (svr *Server) exists(p string) bool {
+	_, err := svr.fsys.Stat(svr.localPath(p))
+	return err == nil
+}
+
+// authPath returns the absolute path the Authorizer sees for the path p sent by the client.
+func (svr *Server) authPath(p string) string {
+	abs, err := svr.realPath(p)
+	if err != nil {
+		return cleanPath(p)
+	}
+	return abs
+}
+
+// authorize asks the Authorizer, if any, whether pkt may be handled.
+func (svr *Server) authorize(pkt requestPacket) error {
+	if svr.authorizer == nil {
+		return nil
+	}
+
+	req := newAuthRequest(pkt, svr.authPath, svr.getHandlePath)
+	if req == nil {
+		return nil
+	}
+	req.Identity = svr.identity
+
+	return svr.authorizer.Authorize(req)
+}
+
+// auditPacket records pkt, answered with rpkt, to the AuditSink, if any.
+func (svr *Server) auditPacket(pkt requestPacket, rpkt responsePacket, start time.Time) {
+	if svr.auditSink == nil {
+		return
+	}
+
+	req := newAuthRequest(pkt, svr.authPath, svr.getHandlePath)
+	if req == nil {
+		return
+	}
+
+	svr.audit(newAuditRecord(req, rpkt, start))
+}
+
+// countTransfer adds to the bytes read and written through handle, for the audit records.
+func (svr *Server) countTransfer(handle string, read, written int64) {
+	if svr.auditSink == nil {
+		return
+	}
+
+	if st, ok := svr.getHandleStats(handle); ok {
+		st.read.Add(read)
+		st.written.Add(written)
+	}
+}
+
+// audit records rec to the AuditSink, if any.
+func (svr *Server) audit(rec *AuditRecord) {
+	if svr.auditSink == nil || rec == nil {
+		return
+	}
+
+	rec.Session = svr.sessionID
+	rec.Identity = svr.identity
+	svr.auditSink.Audit(rec)
+}
+
 type serverRespondablePacket interface {
 	encoding.BinaryUnmarshaler
 	id() uint32
@@ -100,9 +263,12 @@ func NewServer(rwc io.ReadWriteCloser, options ...ServerOption) (*Server, error)
 		serverConn:  svrConn,
 		debugStream: ioutil.Discard,
 		pktMgr:      newPktMgr(svrConn),
-		openFiles:   make(map[string]file),
+		openFiles:   make(map[string]ServerFile),
+		openStats:   make(map[string]*handleStats),
+		sessionID:   newSessionID(),
 		maxTxPacket: defaultMaxTxPacket,
 	}
+	s.fsys = osServerFS{s}
 
 	for _, o := range options {
 		if err := o(s); err != nil {
@@ -132,6 +298,43 @@ func ReadOnly() ServerOption {
 	}
 }
 
+// WithAuthorizer configures a Server to ask the Authorizer about every request on a path,
+// and to deny the requests for which it returns an error, before touching the filesystem.
+// It applies on top of ReadOnly, see Authorizer.
+func WithAuthorizer(a Authorizer) ServerOption {
+	return func(s *Server) error {
+		s.authorizer = a
+		return nil
+	}
+}
+
+// WithSessionIdentity sets the identity of the client the Server serves,
+// such as its SSH user name, passed to the Authorizer and recorded by the AuditSink.
+func WithSessionIdentity(identity string) ServerOption {
+	return func(s *Server) error {
+		s.identity = identity
+		return nil
+	}
+}
+
+// WithAuditSink configures a Server to record every operation to sink, see AuditSink.
+func WithAuditSink(sink AuditSink) ServerOption {
+	return func(s *Server) error {
+		s.auditSink = sink
+		return nil
+	}
+}
+
+// WithSessionID sets the identifier of the Server in audit records,
+// for example to match the logs of the SSH server.
+// By default, every Server gets a random one.
+func WithSessionID(id string) ServerOption {
+	return func(s *Server) error {
+		s.sessionID = id
+		return nil
+	}
+}
+
 // WindowsRootEnumeratesDrives configures a Server to serve a virtual '/' for windows that lists all drives
 func WindowsRootEnumeratesDrives() ServerOption {
 	return func(s *Server) error {
@@ -153,6 +356,36 @@ func WithAllocator() ServerOption {
 	}
 }
 
+// WithWorkerCount sets the number of workers handling reads and writes in parallel,
+// or, with WithHandleOrdering, handles; it must be at least 1.
+// Other requests are handled one after the other by a worker of their own.
+//
+// The default number of workers is SftpServerWorkerCount.
+func WithWorkerCount(n int) ServerOption {
+	return func(s *Server) error {
+		if n < 1 {
+			return errors.New("n must be greater or equal to 1")
+		}
+
+		s.pktMgr.workers = n
+
+		return nil
+	}
+}
+
+// WithHandleOrdering makes a Server handle the requests on a handle one after the other,
+// in the order they are received, while the requests on different handles run in parallel.
+// By default, the reads and writes on a file run in parallel.
+//
+// This lets a ServerFS whose files cannot be written concurrently, such as an append-only object store,
+// serve several files at once.
+func WithHandleOrdering() ServerOption {
+	return func(s *Server) error {
+		s.pktMgr.perHandle = true
+		return nil
+	}
+}
+
 // WithServerWorkingDirectory sets a working directory to use as base
 // for relative paths.
 // If unset the default is current working directory (os.Getwd).
@@ -181,6 +414,51 @@ func WithMaxTxPacket(size uint32) ServerOption {
 	}
 }
 
+// WithMaxOpenHandles limits the files and directories a client may have open at once to n.
+// Opening more fails with ErrTooManyHandles, without creating or truncating the file.
+// By default, or if n is 0, the number of handles is not limited.
+func WithMaxOpenHandles(n int) ServerOption {
+	return func(s *Server) error {
+		if n < 0 {
+			return errors.New("n must be greater or equal to 0")
+		}
+
+		s.maxHandles = n
+
+		return nil
+	}
+}
+
+// WithHandleIdleTimeout closes the files and directories left open by a client
+// without a request for longer than d, with ErrHandleIdle,
+// counting from the end of the last request: a handle is never closed while a request on it is in progress.
+// Handles are checked every d/2, so that they may stay open for up to 1.5 times d.
+// By default, or if d is 0, handles stay open until the client closes them.
+func WithHandleIdleTimeout(d time.Duration) ServerOption {
+	return func(s *Server) error {
+		if d < 0 {
+			return errors.New("duration must not be negative")
+		}
+
+		s.idleTimeout = d
+
+		return nil
+	}
+}
+
+// WithQuota enforces quota on a Server, counting the usage in store under key, such as a user name.
+// Servers sharing store share the usage of key, for a quota that holds across sessions;
+// if store is nil, the usage is counted for the Server alone.
+//
+// Writes, creations of files and changes of size beyond the quota fail with ErrSSHFxQuotaExceeded,
+// and StatVFS reports no more space or files than what is left of the quota.
+func WithQuota(quota Quota, store QuotaStore, key string) ServerOption {
+	return func(s *Server) error {
+		s.quota = newQuotaEnforcer(quota, store, key)
+		return nil
+	}
+}
+
 type rxPacket struct {
 	pktType  fxp
 	pktBytes []byte
@@ -189,6 +467,8 @@ type rxPacket struct {
 // Up to N parallel servers
 func (svr *Server) sftpServerWorker(pktChan chan orderedRequest) error {
 	for pkt := range pktChan {
+		start := time.Now()
+
 		// readonly checks
 		readonly := true
 		switch pkt := pkt.requestPacket.(type) {
@@ -203,20 +483,47 @@ func (svr *Server) sftpServerWorker(pktChan chan orderedRequest) error {
 		// If server is operating read-only and a write operation is requested,
 		// return permission denied
 		if !readonly && svr.readOnly {
-			svr.pktMgr.readyPacket(
-				svr.pktMgr.newOrderedResponse(statusFromError(pkt.id(), syscall.EPERM), pkt.orderID()),
-			)
+			rpkt := statusFromError(pkt.id(), syscall.EPERM)
+			svr.auditPacket(pkt.requestPacket, rpkt, start)
+			svr.pktMgr.readyPacket(svr.pktMgr.newOrderedResponse(rpkt, pkt.orderID()))
 			continue
 		}
 
-		if err := handlePacket(svr, pkt); err != nil {
+		if svr.shuttingDown.Load() && opensHandle(pkt.requestPacket) {
+			rpkt := statusFromError(pkt.id(), ErrServerShutdown)
+			svr.auditPacket(pkt.requestPacket, rpkt, start)
+			svr.pktMgr.readyPacket(svr.pktMgr.newOrderedResponse(rpkt, pkt.orderID()))
+			continue
+		}
+
+		if err := svr.authorize(pkt.requestPacket); err != nil {
+			rpkt := statusFromError(pkt.id(), err)
+			svr.auditPacket(pkt.requestPacket, rpkt, start)
+			svr.pktMgr.readyPacket(svr.pktMgr.newOrderedResponse(rpkt, pkt.orderID()))
+			continue
+		}
+
+		reserved, err := svr.quota.reserve(pkt.requestPacket, svr.exists)
+		if err != nil {
+			rpkt := statusFromError(pkt.id(), err)
+			svr.auditPacket(pkt.requestPacket, rpkt, start)
+			svr.pktMgr.readyPacket(svr.pktMgr.newOrderedResponse(rpkt, pkt.orderID()))
+			continue
+		}
+
+		st := svr.beginHandle(pkt.requestPacket)
+		err = handlePacket(svr, pkt, start, reserved)
+		if st != nil {
+			st.end(time.Now())
+		}
+		if err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func handlePacket(s *Server, p orderedRequest) error {
+func handlePacket(s *Server, p orderedRequest, start time.Time, reserved QuotaUsage) error {
 	var rpkt responsePacket
 	orderID := p.orderID()
 	switch p := p.requestPacket.(type) {
@@ -227,7 +534,7 @@ func handlePacket(s *Server, p orderedRequest) error {
 		}
 	case *sshFxpStatPacket:
 		// stat the requested file
-		info, err := os.Stat(s.toLocalPath(p.Path))
+		info, err := s.fsys.Stat(s.localPath(p.Path))
 		rpkt = &sshFxpStatResponse{
 			ID:   p.ID,
 			info: info,
@@ -237,7 +544,7 @@ func handlePacket(s *Server, p orderedRequest) error {
 		}
 	case *sshFxpLstatPacket:
 		// stat the requested file
-		info, err := s.lstat(s.toLocalPath(p.Path))
+		info, err := s.fsys.Lstat(s.localPath(p.Path))
 		rpkt = &sshFxpStatResponse{
 			ID:   p.ID,
 			info: info,
@@ -260,25 +567,37 @@ func handlePacket(s *Server, p orderedRequest) error {
 			rpkt = statusFromError(p.ID, err)
 		}
 	case *sshFxpMkdirPacket:
-		// TODO FIXME: ignore flags field
-		err := os.Mkdir(s.toLocalPath(p.Path), 0o755)
+		mode := os.FileMode(0o755)
+		// Like OpenSSH, we only handle permissions here. Other attributes are ignored.
+		fs, err := p.unmarshalFileStat(p.Flags)
+		if err == nil {
+			if p.Flags&sshFileXferAttrPermissions != 0 {
+				mode = fs.FileMode() & os.ModePerm
+			}
+			err = s.fsys.Mkdir(s.localPath(p.Path), mode)
+		}
 		rpkt = statusFromError(p.ID, err)
 	case *sshFxpRmdirPacket:
-		err := os.Remove(s.toLocalPath(p.Path))
+		err := s.fsys.Remove(s.localPath(p.Path))
 		rpkt = statusFromError(p.ID, err)
 	case *sshFxpRemovePacket:
-		err := os.Remove(s.toLocalPath(p.Filename))
+		err := s.fsys.Remove(s.localPath(p.Filename))
 		rpkt = statusFromError(p.ID, err)
 	case *sshFxpRenamePacket:
-		err := os.Rename(s.toLocalPath(p.Oldpath), s.toLocalPath(p.Newpath))
+		err := s.fsys.Rename(s.localPath(p.Oldpath), s.localPath(p.Newpath))
 		rpkt = statusFromError(p.ID, err)
 	case *sshFxpSymlinkPacket:
-		err := os.Symlink(s.toLocalPath(p.Targetpath), s.toLocalPath(p.Linkpath))
+		target := p.Targetpath
+		if !s.root {
+			target = s.toLocalPath(target)
+		}
+		// Like in a chroot, the target is stored as it is, and resolved within the root.
+		err := s.fsys.Symlink(target, s.localPath(p.Linkpath))
 		rpkt = statusFromError(p.ID, err)
 	case *sshFxpClosePacket:
 		rpkt = statusFromError(p.ID, s.closeHandle(p.Handle))
 	case *sshFxpReadlinkPacket:
-		f, err := os.Readlink(s.toLocalPath(p.Path))
+		f, err := s.fsys.Readlink(s.localPath(p.Path))
 		rpkt = &sshFxpNamePacket{
 			ID: p.ID,
 			NameAttrs: []*sshFxpNameAttr{
@@ -293,8 +612,7 @@ func handlePacket(s *Server, p orderedRequest) error {
 			rpkt = statusFromError(p.ID, err)
 		}
 	case *sshFxpRealpathPacket:
-		f, err := filepath.Abs(s.toLocalPath(p.Path))
-		f = cleanPath(f)
+		f, err := s.realPath(p.Path)
 		rpkt = &sshFxpNamePacket{
 			ID: p.ID,
 			NameAttrs: []*sshFxpNameAttr{
@@ -309,9 +627,9 @@ func handlePacket(s *Server, p orderedRequest) error {
 			rpkt = statusFromError(p.ID, err)
 		}
 	case *sshFxpOpendirPacket:
-		lp := s.toLocalPath(p.Path)
+		lp := s.localPath(p.Path)
 
-		if stat, err := s.stat(lp); err != nil {
+		if stat, err := s.fsys.Stat(lp); err != nil {
 			rpkt = statusFromError(p.ID, err)
 		} else if !stat.IsDir() {
 			rpkt = statusFromError(p.ID, &os.PathError{
@@ -322,7 +640,7 @@ func handlePacket(s *Server, p orderedRequest) error {
 				ID:     p.ID,
 				Path:   p.Path,
 				Pflags: sshFxfRead,
-			}).respond(s)
+			}).open(s, "List")
 		}
 	case *sshFxpReadPacket:
 		var err error = EBADF
@@ -334,6 +652,7 @@ func handlePacket(s *Server, p orderedRequest) error {
 			if _err != nil && (_err != io.EOF || n == 0) {
 				err = _err
 			}
+			s.countTransfer(p.Handle, int64(n), 0)
 			rpkt = &sshFxpDataPacket{
 				ID:     p.ID,
 				Length: uint32(n),
@@ -349,7 +668,9 @@ func handlePacket(s *Server, p orderedRequest) error {
 		f, ok := s.getHandle(p.Handle)
 		var err error = EBADF
 		if ok {
-			_, err = f.WriteAt(p.Data, int64(p.Offset))
+			var n int
+			n, err = f.WriteAt(p.Data, int64(p.Offset))
+			s.countTransfer(p.Handle, 0, int64(n))
 		}
 		rpkt = statusFromError(p.ID, err)
 	case *sshFxpExtendedPacket:
@@ -364,6 +685,8 @@ func handlePacket(s *Server, p orderedRequest) error {
 		return fmt.Errorf("unexpected packet type %T", p)
 	}
 
+	s.quota.settle(reserved, rpkt)
+	s.auditPacket(p.requestPacket, rpkt, start)
 	s.pktMgr.readyPacket(s.pktMgr.newOrderedResponse(rpkt, orderID))
 	return nil
 }
@@ -388,6 +711,8 @@ func (svr *Server) Serve() error {
 	}
 	pktChan := svr.pktMgr.workerChan(runWorker)
 
+	stopReaper := startReaper(svr.idleTimeout, svr.reapIdleHandles)
+
 	var err error
 	var pkt requestPacket
 	var pktType uint8
@@ -424,11 +749,27 @@ func (svr *Server) Serve() error {
 
 	close(pktChan) // shuts down sftpServerWorkers
 	wg.Wait()      // wait for all workers to exit
+	stopReaper()
+
+	if svr.shuttingDown.Load() {
+		err = ErrServerShutdown
+	}
 
 	// close any still-open files
+	svr.openFilesLock.Lock()
+	defer svr.openFilesLock.Unlock()
 	for handle, file := range svr.openFiles {
 		fmt.Fprintf(svr.debugStream, "sftp server file with handle %q left open: %v\n", handle, file.Name())
 		file.Close()
+
+		lostErr := err
+		if lostErr == nil {
+			lostErr = io.ErrUnexpectedEOF
+		}
+		svr.audit(newHandleAuditRecord(svr.openStats[handle], lostErr))
+
+		delete(svr.openFiles, handle)
+		delete(svr.openStats, handle)
 	}
 	return err // error from recvPacket
 }
@@ -479,6 +820,13 @@ func (p *sshFxpOpenPacket) hasPflags(flags ...uint32) bool {
 }
 
 func (p *sshFxpOpenPacket) respond(svr *Server) responsePacket {
+	return p.open(svr, openMethod(p.Pflags))
+}
+
+// open opens the file, recording it in audit records as method.
+func (p *sshFxpOpenPacket) open(svr *Server, method string) responsePacket {
+	start := time.Now()
+
 	var osFlags int
 	if p.hasPflags(sshFxfRead, sshFxfWrite) {
 		osFlags |= os.O_RDWR
@@ -515,15 +863,38 @@ func (p *sshFxpOpenPacket) respond(svr *Server) responsePacket {
 		mode = fs.FileMode() & os.ModePerm
 	}
 
-	f, err := svr.openfile(svr.toLocalPath(p.Path), osFlags, mode)
+	// Check the limit on handles first, so that an open over it does not create or truncate the file.
+	if err := svr.reserveHandle(); err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	f, err := svr.fsys.OpenFile(svr.localPath(p.Path), osFlags, mode)
 	if err != nil {
+		svr.releaseHandle()
 		return statusFromError(p.ID, err)
 	}
 
-	handle := svr.nextHandle(f)
+	handle := svr.nextHandle(f, &handleStats{
+		method: method,
+		path:   svr.authPath(p.Path),
+		opened: start,
+	})
 	return &sshFxpHandlePacket{ID: p.ID, Handle: handle}
 }
 
+func (p *sshFxpExtendedPacketStatVFS) respond(svr *Server) responsePacket {
+	retPkt, err := svr.fsys.StatVFS(svr.localPath(p.Path))
+	if err == nil {
+		err = svr.quota.statVFS(retPkt)
+	}
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+	retPkt.ID = p.ID
+
+	return retPkt
+}
+
 func (p *sshFxpReaddirPacket) respond(svr *Server) responsePacket {
 	f, ok := svr.getHandle(p.Handle)
 	if !ok {
@@ -549,23 +920,23 @@ func (p *sshFxpReaddirPacket) respond(svr *Server) responsePacket {
 }
 
 func (p *sshFxpSetstatPacket) respond(svr *Server) responsePacket {
-	path := svr.toLocalPath(p.Path)
+	path := svr.localPath(p.Path)
 
 	debug("setstat name %q", path)
 
 	fs, err := p.unmarshalFileStat(p.Flags)
 
 	if err == nil && (p.Flags&sshFileXferAttrSize) != 0 {
-		err = os.Truncate(path, int64(fs.Size))
+		err = svr.fsys.Truncate(path, int64(fs.Size))
 	}
 	if err == nil && (p.Flags&sshFileXferAttrPermissions) != 0 {
-		err = os.Chmod(path, fs.FileMode())
+		err = svr.fsys.Chmod(path, fs.FileMode())
 	}
 	if err == nil && (p.Flags&sshFileXferAttrUIDGID) != 0 {
-		err = os.Chown(path, int(fs.UID), int(fs.GID))
+		err = svr.fsys.Chown(path, int(fs.UID), int(fs.GID))
 	}
 	if err == nil && (p.Flags&sshFileXferAttrACmodTime) != 0 {
-		err = os.Chtimes(path, fs.AccessTime(), fs.ModTime())
+		err = svr.fsys.Chtimes(path, fs.AccessTime(), fs.ModTime())
 	}
 
 	return statusFromError(p.ID, err)
@@ -602,7 +973,7 @@ func (p *sshFxpFsetstatPacket) respond(svr *Server) responsePacket {
 			// future-compatible, for when/if *os.File supports Chtimes.
 			err = f.Chtimes(fs.AccessTime(), fs.ModTime())
 		default:
-			err = os.Chtimes(path, fs.AccessTime(), fs.ModTime())
+			err = svr.fsys.Chtimes(path, fs.AccessTime(), fs.ModTime())
 		}
 	}
 
@@ -633,10 +1004,14 @@ func statusFromError(id uint32, err error) *sshFxpStatusPacket {
 	ret.StatusError.Code = sshFxFailure
 	ret.StatusError.msg = err.Error()
 
-	if os.IsNotExist(err) {
+	if errors.Is(err, os.ErrNotExist) {
 		ret.StatusError.Code = sshFxNoSuchFile
 		return ret
 	}
+	if errors.Is(err, os.ErrPermission) {
+		ret.StatusError.Code = sshFxPermissionDenied
+		return ret
+	}
 	if code, ok := translateSyscallError(err); ok {
 		ret.StatusError.Code = code
 		return ret
@@ -653,5 +1028,11 @@ func statusFromError(id uint32, err error) *sshFxpStatusPacket {
 		return ret
 	}
 
+	var status *StatusError
+	if errors.As(err, &status) {
+		ret.StatusError.Code = status.Code
+		return ret
+	}
+
 	return ret
 }
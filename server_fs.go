@@ -0,0 +1,128 @@
+package sftp
+
+import (
+	"io/fs"
+	"os"
+	"time"
+)
+
+// ServerFS is the filesystem a Server operates on, see WithFS.
+// By default, a Server operates on the filesystem of the host.
+//
+// Paths are passed to a ServerFS relative to its root, as in io/fs:
+// they are cleaned, slash-separated, unrooted, and "." names the root;
+// paths sent by the client cannot climb above it with "..".
+// The target of Symlink is passed as sent by the client, without interpretation.
+//
+// Errors are reported to the client by their kind:
+// errors matching fs.ErrNotExist and fs.ErrPermission,
+// a syscall.Errno, or a *StatusError such as ErrSSHFxOpUnsupported
+// for operations the ServerFS does not support.
+// Methods are called concurrently by the workers of the Server.
+type ServerFS interface {
+	OpenFile(name string, flag int, perm fs.FileMode) (ServerFile, error)
+	Stat(name string) (os.FileInfo, error)
+	Lstat(name string) (os.FileInfo, error)
+	Mkdir(name string, perm fs.FileMode) error
+	Remove(name string) error
+	Rename(oldname, newname string) error
+	Link(oldname, newname string) error
+	Symlink(target, name string) error
+	Readlink(name string) (string, error)
+	Truncate(name string, size int64) error
+	Chmod(name string, mode fs.FileMode) error
+	Chown(name string, uid, gid int) error
+	Chtimes(name string, atime, mtime time.Time) error
+	StatVFS(name string) (*StatVFS, error)
+}
+
+// ServerFile is a file or directory opened by ServerFS.OpenFile, as with an *os.File.
+// Name returns the name it was opened with.
+// ReadAt and WriteAt are called concurrently for the requests of the client.
+type ServerFile interface {
+	Stat() (os.FileInfo, error)
+	ReadAt(b []byte, off int64) (int, error)
+	WriteAt(b []byte, off int64) (int, error)
+	Readdir(n int) ([]os.FileInfo, error)
+	Name() string
+	Truncate(size int64) error
+	Chmod(mode fs.FileMode) error
+	Chown(uid, gid int) error
+	Sync() error
+	Close() error
+}
+
+// WithFS makes a Server operate on fsys rather than the filesystem of the host.
+// The root of fsys becomes the root "/" seen by the client,
+// and the working directory set by WithServerWorkingDirectory is taken within it.
+//
+// NewRootServerFS returns the tree beneath a directory of the host,
+// for a ServerFS that layers on it.
+func WithFS(fsys ServerFS) ServerOption {
+	return func(s *Server) error {
+		s.fsys = fsys
+		s.root = true
+		return nil
+	}
+}
+
+// osServerFS is the filesystem of the host.
+type osServerFS struct {
+	s *Server
+}
+
+func (fsys osServerFS) OpenFile(name string, flag int, perm fs.FileMode) (ServerFile, error) {
+	return fsys.s.openfile(name, flag, perm)
+}
+
+func (fsys osServerFS) Stat(name string) (os.FileInfo, error) {
+	return fsys.s.stat(name)
+}
+
+func (fsys osServerFS) Lstat(name string) (os.FileInfo, error) {
+	return fsys.s.lstat(name)
+}
+
+func (osServerFS) Mkdir(name string, perm fs.FileMode) error {
+	return os.Mkdir(name, perm)
+}
+
+func (osServerFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osServerFS) Rename(oldname, newname string) error {
+	return os.Rename(oldname, newname)
+}
+
+func (osServerFS) Link(oldname, newname string) error {
+	return os.Link(oldname, newname)
+}
+
+func (osServerFS) Symlink(target, name string) error {
+	return os.Symlink(target, name)
+}
+
+func (osServerFS) Readlink(name string) (string, error) {
+	return os.Readlink(name)
+}
+
+func (osServerFS) Truncate(name string, size int64) error {
+	return os.Truncate(name, size)
+}
+
+func (osServerFS) Chmod(name string, mode fs.FileMode) error {
+	return os.Chmod(name, mode)
+}
+
+func (osServerFS) Chown(name string, uid, gid int) error {
+	return os.Chown(name, uid, gid)
+}
+
+func (osServerFS) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+
+func (osServerFS) StatVFS(name string) (*StatVFS, error) {
+	return getStatVFSForPath(name)
+}
@@ -0,0 +1,96 @@
+package sftp
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tracingServerFS records the operations of a ServerFS, and does not support Remove.
+type tracingServerFS struct {
+	ServerFS
+
+	mu    sync.Mutex
+	calls []string
+}
+
+func (fsys *tracingServerFS) trace(op, name string) {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	fsys.calls = append(fsys.calls, op+" "+name)
+}
+
+func (fsys *tracingServerFS) OpenFile(name string, flag int, perm os.FileMode) (ServerFile, error) {
+	fsys.trace("open", name)
+	return fsys.ServerFS.OpenFile(name, flag, perm)
+}
+
+func (fsys *tracingServerFS) Stat(name string) (os.FileInfo, error) {
+	fsys.trace("stat", name)
+	return fsys.ServerFS.Stat(name)
+}
+
+func (fsys *tracingServerFS) Remove(name string) error {
+	fsys.trace("remove", name)
+	return ErrSSHFxOpUnsupported
+}
+
+func TestServerWithFS(t *testing.T) {
+	skipIfWindows(t)
+
+	dir := t.TempDir()
+	root, err := NewRootServerFS(dir)
+	require.NoError(t, err)
+
+	fsys := &tracingServerFS{ServerFS: root}
+
+	client, server := clientServerPair(t, WithFS(fsys), WithServerWorkingDirectory("/home"))
+	defer client.Close()
+	defer server.Close()
+
+	require.NoError(t, client.Mkdir("/home"))
+
+	f, err := client.Create("file")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	got, err := os.ReadFile(filepath.Join(dir, "home", "file"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(got))
+
+	f, err = client.Open("/../home/file")
+	require.NoError(t, err)
+	b, err := io.ReadAll(f)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+	require.NoError(t, f.Close())
+
+	fis, err := client.ReadDir("/")
+	require.NoError(t, err)
+	require.Len(t, fis, 1)
+	assert.Equal(t, "home", fis[0].Name())
+
+	err = client.Remove("/home/file")
+	var status *StatusError
+	require.ErrorAs(t, err, &status)
+	assert.Equal(t, ErrSSHFxOpUnsupported, status.FxCode())
+
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+	assert.Equal(t, []string{
+		"open home/file",
+		"open home/file",
+		"stat .",
+		"open .",
+		"remove home/file",
+		"remove home/file", // Client.Remove tries Rmdir as well.
+	}, fsys.calls)
+}
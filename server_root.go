@@ -0,0 +1,242 @@
+package sftp
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// WithRoot confines a Server beneath dir, like the chroot of an OpenSSH sftp-server.
+// dir becomes the root "/" seen by the client, ".." cannot climb above it,
+// and the working directory set by WithServerWorkingDirectory is taken within it.
+//
+// On Linux, every path is resolved by the kernel with openat2 and RESOLVE_IN_ROOT,
+// so that symbolic links, absolute ones included, are resolved within dir as in a chroot,
+// and concurrent changes to the tree cannot be raced to escape it.
+// Elsewhere, or on kernels older than 5.6, paths are resolved through an os.Root,
+// which rejects symbolic links leading out of dir instead,
+// and renames, links and changes of times, and of modes and owners other than on Linux,
+// are only checked before they are done.
+func WithRoot(dir string) ServerOption {
+	return func(s *Server) error {
+		fsys, err := NewRootServerFS(dir)
+		if err != nil {
+			return err
+		}
+		return WithFS(fsys)(s)
+	}
+}
+
+// NewRootServerFS returns the tree beneath dir, as confined by WithRoot,
+// for a ServerFS that wraps it, and is installed with WithFS.
+func NewRootServerFS(dir string) (ServerFS, error) {
+	return newServerRoot(dir)
+}
+
+// localPath returns the local path of the path p sent by the client.
+func (s *Server) localPath(p string) string {
+	if !s.root {
+		return s.toLocalPath(p)
+	}
+
+	// Like in a chroot, ".." at the root stays at the root.
+	if !path.IsAbs(p) {
+		p = path.Join(s.workDir, p)
+	}
+	p = path.Clean("/" + p)
+
+	if p == "/" {
+		return "."
+	}
+	return p[1:]
+}
+
+// realPath returns the canonical form of the path p sent by the client.
+func (s *Server) realPath(p string) (string, error) {
+	if s.root {
+		return path.Join("/", s.localPath(p)), nil
+	}
+
+	f, err := filepath.Abs(s.toLocalPath(p))
+	return cleanPath(f), err
+}
+
+// osRootFS is the tree beneath a directory, resolved through an os.Root.
+type osRootFS struct {
+	root *os.Root
+}
+
+func newOSRootFS(dir string) (*osRootFS, error) {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &osRootFS{root: root}, nil
+}
+
+// rootFile is a file opened beneath the root of a Server, named by its local path.
+type rootFile struct {
+	*os.File
+	name string
+}
+
+func (f rootFile) Name() string {
+	return f.name
+}
+
+func (r *osRootFS) OpenFile(name string, flag int, perm fs.FileMode) (ServerFile, error) {
+	f, err := r.root.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return rootFile{File: f, name: name}, nil
+}
+
+func (r *osRootFS) Stat(name string) (os.FileInfo, error) {
+	return r.root.Stat(name)
+}
+
+func (r *osRootFS) Lstat(name string) (os.FileInfo, error) {
+	return r.root.Lstat(name)
+}
+
+func (r *osRootFS) Mkdir(name string, perm fs.FileMode) error {
+	return r.root.Mkdir(name, perm)
+}
+
+func (r *osRootFS) Remove(name string) error {
+	return r.root.Remove(name)
+}
+
+// hostPath checks that the directory containing name resolves beneath the root,
+// and, if follow is set, that name itself does, and returns the path of name on the host.
+// Unlike the operations of the root, an operation on the path returned is subject to races.
+func (r *osRootFS) hostPath(name string, follow bool) (string, error) {
+	if _, err := r.root.Stat(path.Dir(name)); err != nil {
+		return "", err
+	}
+
+	if follow {
+		if _, err := r.root.Stat(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+	}
+
+	return filepath.Join(r.root.Name(), filepath.FromSlash(name)), nil
+}
+
+// pathErr names name, rather than its path on the host, in the error of an operation on a path returned by hostPath.
+func pathErr(op, name string, err error) error {
+	var pe *os.PathError
+	if errors.As(err, &pe) {
+		err = pe.Err
+	}
+	return &os.PathError{Op: op, Path: name, Err: err}
+}
+
+// linkErr names oldname and newname, rather than their paths on the host, in the error of an operation on paths returned by hostPath.
+func linkErr(op, oldname, newname string, err error) error {
+	var le *os.LinkError
+	if errors.As(err, &le) {
+		err = le.Err
+	}
+	return &os.LinkError{Op: op, Old: oldname, New: newname, Err: err}
+}
+
+func (r *osRootFS) Rename(oldname, newname string) error {
+	oldpath, err := r.hostPath(oldname, false)
+	if err != nil {
+		return err
+	}
+	newpath, err := r.hostPath(newname, false)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(oldpath, newpath); err != nil {
+		return linkErr("rename", oldname, newname, err)
+	}
+	return nil
+}
+
+func (r *osRootFS) Link(oldname, newname string) error {
+	oldpath, err := r.hostPath(oldname, false)
+	if err != nil {
+		return err
+	}
+	newpath, err := r.hostPath(newname, false)
+	if err != nil {
+		return err
+	}
+	if err := os.Link(oldpath, newpath); err != nil {
+		return linkErr("link", oldname, newname, err)
+	}
+	return nil
+}
+
+func (r *osRootFS) Symlink(target, name string) error {
+	p, err := r.hostPath(name, false)
+	if err != nil {
+		return err
+	}
+	if err := os.Symlink(target, p); err != nil {
+		return linkErr("symlink", target, name, err)
+	}
+	return nil
+}
+
+func (r *osRootFS) Readlink(name string) (string, error) {
+	p, err := r.hostPath(name, false)
+	if err != nil {
+		return "", err
+	}
+	target, err := os.Readlink(p)
+	if err != nil {
+		return "", pathErr("readlink", name, err)
+	}
+	return target, nil
+}
+
+func (r *osRootFS) Truncate(name string, size int64) error {
+	f, err := r.root.OpenFile(name, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Truncate(size)
+}
+
+// Chmod changes the mode of name, which, unlike a file opened through the root, need not be readable.
+func (r *osRootFS) Chmod(name string, mode fs.FileMode) error {
+	return r.withPath("chmod", name, func(p string) error { return os.Chmod(p, mode) })
+}
+
+// Chown changes the owner of name, which, unlike a file opened through the root, need not be readable.
+func (r *osRootFS) Chown(name string, uid, gid int) error {
+	return r.withPath("chown", name, func(p string) error { return os.Chown(p, uid, gid) })
+}
+
+func (r *osRootFS) Chtimes(name string, atime, mtime time.Time) error {
+	p, err := r.hostPath(name, true)
+	if err != nil {
+		return err
+	}
+	if err := os.Chtimes(p, atime, mtime); err != nil {
+		return pathErr("chtimes", name, err)
+	}
+	return nil
+}
+
+func (r *osRootFS) StatVFS(name string) (*StatVFS, error) {
+	p, err := r.hostPath(name, true)
+	if err != nil {
+		return nil, err
+	}
+	st, err := getStatVFSForPath(p)
+	if err != nil {
+		return nil, pathErr("statvfs", name, err)
+	}
+	return st, nil
+}
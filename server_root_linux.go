@@ -0,0 +1,338 @@
+//go:build linux
+// +build linux
+
+package sftp
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"strconv"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// newServerRoot returns the tree beneath dir, resolved with openat2 if the kernel supports it.
+func newServerRoot(dir string) (ServerFS, error) {
+	fd, err := unix.Open(dir, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: dir, Err: err}
+	}
+
+	r := &openat2RootFS{fd: fd}
+
+	probe, err := r.openat(".", unix.O_PATH, 0)
+	if err != nil {
+		unix.Close(fd)
+
+		if errors.Is(err, unix.ENOSYS) || errors.Is(err, unix.EPERM) {
+			// Kernels before 5.6, or a seccomp filter that does not know openat2.
+			return newOSRootFS(dir)
+		}
+		return nil, err
+	}
+	unix.Close(probe)
+
+	return r, nil
+}
+
+// openat2RootFS is the tree beneath a directory, with every path resolved by the kernel as if it were the root.
+// The file descriptor of the directory is kept open for the lifetime of the Server.
+type openat2RootFS struct {
+	fd int
+}
+
+// openat opens name beneath the root.
+func (r *openat2RootFS) openat(name string, flags int, mode uint32) (int, error) {
+	how := &unix.OpenHow{
+		Flags:   uint64(flags | unix.O_CLOEXEC),
+		Resolve: unix.RESOLVE_IN_ROOT | unix.RESOLVE_NO_MAGICLINKS,
+	}
+	if flags&(unix.O_CREAT|unix.O_TMPFILE) != 0 {
+		// openat2 rejects a mode it would not use.
+		how.Mode = uint64(mode)
+	}
+
+	for {
+		fd, err := unix.Openat2(r.fd, name, how)
+		switch err {
+		case nil:
+			return fd, nil
+		case unix.EINTR, unix.EAGAIN:
+			// EAGAIN is returned when a concurrent rename might have let the lookup escape.
+			continue
+		default:
+			return -1, &os.PathError{Op: "openat2", Path: name, Err: err}
+		}
+	}
+}
+
+// parent opens the directory containing name beneath the root, and returns it with the last element of name.
+func (r *openat2RootFS) parent(name string) (int, string, error) {
+	dir, base := path.Split(name)
+	if dir == "" {
+		dir = "."
+	}
+
+	fd, err := r.openat(dir, unix.O_PATH|unix.O_DIRECTORY, 0)
+	return fd, base, err
+}
+
+// withFile opens name beneath the root as a path, following symbolic links, and calls fn with it.
+func (r *openat2RootFS) withFile(name string, fn func(fd int) error) error {
+	fd, err := r.openat(name, unix.O_PATH, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	return fn(fd)
+}
+
+// procPath returns a path to the file open as fd, which refers to that very file,
+// for the operations that cannot be done on a file descriptor opened as a path.
+func procPath(fd int) string {
+	return "/proc/self/fd/" + strconv.Itoa(fd)
+}
+
+func (r *openat2RootFS) OpenFile(name string, flag int, perm fs.FileMode) (ServerFile, error) {
+	fd, err := r.openat(name, flag, uint32(perm.Perm()))
+	if err != nil {
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), name), nil
+}
+
+func (r *openat2RootFS) stat(name string, flags int) (os.FileInfo, error) {
+	fd, err := r.openat(name, unix.O_PATH|flags, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	f := os.NewFile(uintptr(fd), path.Base(name))
+	defer f.Close()
+
+	return f.Stat()
+}
+
+func (r *openat2RootFS) Stat(name string) (os.FileInfo, error) {
+	return r.stat(name, 0)
+}
+
+func (r *openat2RootFS) Lstat(name string) (os.FileInfo, error) {
+	return r.stat(name, unix.O_NOFOLLOW)
+}
+
+func (r *openat2RootFS) Mkdir(name string, perm fs.FileMode) error {
+	dirfd, base, err := r.parent(name)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(dirfd)
+
+	if err := unix.Mkdirat(dirfd, base, uint32(perm.Perm())); err != nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	return nil
+}
+
+// Remove removes the file or empty directory name, like os.Remove.
+func (r *openat2RootFS) Remove(name string) error {
+	dirfd, base, err := r.parent(name)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(dirfd)
+
+	err = unix.Unlinkat(dirfd, base, 0)
+	if err == nil {
+		return nil
+	}
+
+	err1 := unix.Unlinkat(dirfd, base, unix.AT_REMOVEDIR)
+	if err1 == nil {
+		return nil
+	}
+
+	// Report the error of rmdir, unless name is not a directory.
+	if err1 != unix.ENOTDIR {
+		err = err1
+	}
+	return &os.PathError{Op: "remove", Path: name, Err: err}
+}
+
+// linkat calls fn with the parent directories and last elements of oldname and newname.
+func (r *openat2RootFS) linkat(op, oldname, newname string, fn func(olddirfd int, oldbase string, newdirfd int, newbase string) error) error {
+	olddirfd, oldbase, err := r.parent(oldname)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(olddirfd)
+
+	newdirfd, newbase, err := r.parent(newname)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(newdirfd)
+
+	if err := fn(olddirfd, oldbase, newdirfd, newbase); err != nil {
+		return &os.LinkError{Op: op, Old: oldname, New: newname, Err: err}
+	}
+	return nil
+}
+
+func (r *openat2RootFS) Rename(oldname, newname string) error {
+	return r.linkat("rename", oldname, newname, func(olddirfd int, oldbase string, newdirfd int, newbase string) error {
+		return unix.Renameat(olddirfd, oldbase, newdirfd, newbase)
+	})
+}
+
+func (r *openat2RootFS) Link(oldname, newname string) error {
+	return r.linkat("link", oldname, newname, func(olddirfd int, oldbase string, newdirfd int, newbase string) error {
+		return unix.Linkat(olddirfd, oldbase, newdirfd, newbase, 0)
+	})
+}
+
+func (r *openat2RootFS) Symlink(target, name string) error {
+	dirfd, base, err := r.parent(name)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(dirfd)
+
+	if err := unix.Symlinkat(target, dirfd, base); err != nil {
+		return &os.LinkError{Op: "symlink", Old: target, New: name, Err: err}
+	}
+	return nil
+}
+
+func (r *openat2RootFS) Readlink(name string) (string, error) {
+	dirfd, base, err := r.parent(name)
+	if err != nil {
+		return "", err
+	}
+	defer unix.Close(dirfd)
+
+	for size := 128; ; size *= 2 {
+		b := make([]byte, size)
+		n, err := unix.Readlinkat(dirfd, base, b)
+		if err != nil {
+			return "", &os.PathError{Op: "readlink", Path: name, Err: err}
+		}
+		if n < size {
+			return string(b[:n]), nil
+		}
+	}
+}
+
+func (r *openat2RootFS) Truncate(name string, size int64) error {
+	fd, err := r.openat(name, unix.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	if err := unix.Ftruncate(fd, size); err != nil {
+		return &os.PathError{Op: "truncate", Path: name, Err: err}
+	}
+	return nil
+}
+
+func (r *openat2RootFS) Chmod(name string, mode fs.FileMode) error {
+	return r.withFile(name, func(fd int) error {
+		if err := os.Chmod(procPath(fd), mode); err != nil {
+			return &os.PathError{Op: "chmod", Path: name, Err: errors.Unwrap(err)}
+		}
+		return nil
+	})
+}
+
+func (r *openat2RootFS) Chown(name string, uid, gid int) error {
+	return r.withFile(name, func(fd int) error {
+		if err := unix.Fchownat(fd, "", uid, gid, unix.AT_EMPTY_PATH); err != nil {
+			return &os.PathError{Op: "chown", Path: name, Err: err}
+		}
+		return nil
+	})
+}
+
+func (r *openat2RootFS) Chtimes(name string, atime, mtime time.Time) error {
+	return r.withFile(name, func(fd int) error {
+		if err := os.Chtimes(procPath(fd), atime, mtime); err != nil {
+			return &os.PathError{Op: "chtimes", Path: name, Err: errors.Unwrap(err)}
+		}
+		return nil
+	})
+}
+
+func (r *openat2RootFS) StatVFS(name string) (*StatVFS, error) {
+	var stat syscall.Statfs_t
+	err := r.withFile(name, func(fd int) error {
+		if err := syscall.Fstatfs(fd, &stat); err != nil {
+			return &os.PathError{Op: "statvfs", Path: name, Err: err}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return statvfsFromStatfst(&stat)
+}
+
+// maxRootSymlinks is the number of symbolic links withPath follows, as many as the kernel does.
+const maxRootSymlinks = 40
+
+// withPath opens name beneath the root as a path, following symbolic links as the root does,
+// and calls fn with a path that refers to that very file, so that it need not be readable.
+func (r *osRootFS) withPath(op, name string, fn func(p string) error) error {
+	for range maxRootSymlinks {
+		f, err := r.root.OpenFile(name, unix.O_PATH, 0)
+		if err != nil {
+			return err
+		}
+
+		// The root opens a final symbolic link itself, rather than following it.
+		target, isLink, err := fdSymlinkTarget(f)
+		if err == nil && !isLink {
+			err = fn(procPath(int(f.Fd())))
+		}
+		f.Close()
+
+		if err != nil {
+			return pathErr(op, name, err)
+		}
+		if !isLink {
+			return nil
+		}
+
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir(name), target)
+		}
+		name = target
+	}
+
+	return &os.PathError{Op: op, Path: name, Err: syscall.ELOOP}
+}
+
+// fdSymlinkTarget returns the target of f, and true, if f is a symbolic link opened as a path.
+func fdSymlinkTarget(f *os.File) (string, bool, error) {
+	fi, err := f.Stat()
+	if err != nil || fi.Mode()&fs.ModeSymlink == 0 {
+		return "", false, err
+	}
+
+	for size := 128; ; size *= 2 {
+		b := make([]byte, size)
+		n, err := unix.Readlinkat(int(f.Fd()), "", b)
+		if err != nil {
+			return "", false, err
+		}
+		if n < size {
+			return string(b[:n]), true, nil
+		}
+	}
+}
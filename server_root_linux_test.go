@@ -0,0 +1,39 @@
+package sftp
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerWithRootChroot(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "etc"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "etc", "motd"), []byte("inside"), 0o644))
+
+	// Absolute targets resolve within the root, as in a chroot.
+	require.NoError(t, os.Symlink("/etc/motd", filepath.Join(dir, "motd")))
+
+	client, server := clientServerPair(t, WithRoot(dir))
+	defer client.Close()
+	defer server.Close()
+
+	if _, ok := server.fsys.(*openat2RootFS); !ok {
+		t.Skip("openat2 is not available")
+	}
+
+	f, err := client.Open("/motd")
+	require.NoError(t, err)
+	defer f.Close()
+
+	b, err := io.ReadAll(f)
+	require.NoError(t, err)
+	assert.Equal(t, "inside", string(b))
+
+	_, err = client.StatVFS("/")
+	assert.NoError(t, err)
+}
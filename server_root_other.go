@@ -0,0 +1,22 @@
+//go:build !linux
+// +build !linux
+
+package sftp
+
+// newServerRoot returns the tree beneath dir, resolved through an os.Root.
+func newServerRoot(dir string) (ServerFS, error) {
+	return newOSRootFS(dir)
+}
+
+// withPath calls fn with the path of name on the host, once it was checked to resolve beneath the root.
+// Like a change of times, the change made by fn is subject to races.
+func (r *osRootFS) withPath(op, name string, fn func(p string) error) error {
+	p, err := r.hostPath(name, true)
+	if err != nil {
+		return err
+	}
+	if err := fn(p); err != nil {
+		return pathErr(op, name, err)
+	}
+	return nil
+}
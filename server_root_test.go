@@ -0,0 +1,174 @@
+package sftp
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withOSRoot confines a Server like WithRoot does where openat2 is not available.
+func withOSRoot(dir string) ServerOption {
+	return func(s *Server) error {
+		root, err := newOSRootFS(dir)
+		if err != nil {
+			return err
+		}
+		return WithFS(root)(s)
+	}
+}
+
+func TestServerWithRoot(t *testing.T) {
+	skipIfWindows(t)
+
+	for name, withRoot := range map[string]func(string) ServerOption{
+		"WithRoot": WithRoot,
+		"os.Root":  withOSRoot,
+	} {
+		t.Run(name, func(t *testing.T) {
+			base := t.TempDir()
+			dir := filepath.Join(base, "root")
+			require.NoError(t, os.Mkdir(dir, 0o755))
+			require.NoError(t, os.WriteFile(filepath.Join(base, "secret"), []byte("secret"), 0o600))
+
+			// Ways out of the root planted on the host.
+			require.NoError(t, os.Symlink(base, filepath.Join(dir, "abs")))
+			require.NoError(t, os.Symlink("..", filepath.Join(dir, "rel")))
+
+			client, server := clientServerPair(t, withRoot(dir))
+			defer client.Close()
+			defer server.Close()
+
+			f, err := client.Create("/file")
+			require.NoError(t, err)
+			_, err = f.Write([]byte("hello"))
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+
+			got, err := os.ReadFile(filepath.Join(dir, "file"))
+			require.NoError(t, err)
+			assert.Equal(t, "hello", string(got))
+
+			for _, p := range []string{"../secret", "/../secret", "/../../secret", "rel/secret", "abs/secret", "/abs/../secret"} {
+				_, err := client.Stat(p)
+				assert.Error(t, err, p)
+
+				f, err := client.Open(p)
+				if assert.Error(t, err, p) {
+					continue
+				}
+				b, _ := io.ReadAll(f)
+				f.Close()
+				assert.NotEqual(t, "secret", string(b), p)
+			}
+
+			// Like in a chroot, ".." at the root stays at the root.
+			real, err := client.RealPath("../../file")
+			require.NoError(t, err)
+			assert.Equal(t, "/file", real)
+
+			fi, err := client.Stat("/../file")
+			require.NoError(t, err)
+			assert.EqualValues(t, 5, fi.Size())
+
+			_, err = client.Stat("/missing")
+			assert.ErrorIs(t, err, os.ErrNotExist)
+
+			fis, err := client.ReadDir("/")
+			require.NoError(t, err)
+			var names []string
+			for _, fi := range fis {
+				names = append(names, fi.Name())
+			}
+			assert.ElementsMatch(t, []string{"abs", "file", "rel"}, names)
+
+			require.NoError(t, client.Mkdir("/sub"))
+			require.NoError(t, client.Rename("/file", "/sub/file"))
+			require.NoError(t, client.Link("/sub/file", "/hardlink"))
+			require.NoError(t, client.Chmod("/sub/file", 0o640))
+			require.NoError(t, client.Truncate("/sub/file", 2))
+			mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+			require.NoError(t, client.Chtimes("/sub/file", mtime, mtime))
+
+			fi, err = os.Stat(filepath.Join(dir, "sub", "file"))
+			require.NoError(t, err)
+			assert.Equal(t, os.FileMode(0o640), fi.Mode())
+			assert.EqualValues(t, 2, fi.Size())
+			assert.True(t, mtime.Equal(fi.ModTime()))
+
+			// Symbolic link targets are stored as they are.
+			require.NoError(t, client.Symlink("/sub/file", "/link"))
+			target, err := os.Readlink(filepath.Join(dir, "link"))
+			require.NoError(t, err)
+			assert.Equal(t, "/sub/file", target)
+
+			target, err = client.ReadLink("/link")
+			require.NoError(t, err)
+			assert.Equal(t, "/sub/file", target)
+
+			// Modes can be changed on files that cannot be read, and through links within the root.
+			require.NoError(t, client.Symlink("sub/file", "/rellink"))
+			require.NoError(t, client.Chmod("/sub/file", 0o200))
+			require.NoError(t, client.Chmod("/rellink", 0))
+			fi, err = os.Stat(filepath.Join(dir, "sub", "file"))
+			require.NoError(t, err)
+			assert.Equal(t, os.FileMode(0), fi.Mode())
+			require.NoError(t, client.Chmod("/sub/file", 0o640))
+
+			// Changing the files behind the links planted on the host must fail.
+			assert.Error(t, client.Chmod("/abs/secret", 0o666))
+			assert.Error(t, client.Chtimes("/rel/secret", mtime, mtime))
+			assert.Error(t, client.Truncate("/abs/secret", 0))
+			assert.Error(t, client.Remove("/abs/secret"))
+
+			fi, err = os.Stat(filepath.Join(base, "secret"))
+			require.NoError(t, err)
+			assert.Equal(t, os.FileMode(0o600), fi.Mode())
+			assert.EqualValues(t, 6, fi.Size())
+
+			require.NoError(t, client.Remove("/hardlink"))
+			require.NoError(t, client.Remove("/link"))
+			require.NoError(t, client.Remove("/rellink"))
+			assert.Error(t, client.RemoveDirectory("/"))
+		})
+	}
+}
+
+func TestOSRootFSErrors(t *testing.T) {
+	skipIfWindows(t)
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "file"), nil, 0o644))
+
+	root, err := newOSRootFS(dir)
+	require.NoError(t, err)
+
+	_, readlinkErr := root.Readlink("file")
+	_, statvfsErr := root.StatVFS("missing")
+
+	for name, err := range map[string]error{
+		"rename":   root.Rename("missing", "other"),
+		"link":     root.Link("missing", "other"),
+		"symlink":  root.Symlink("target", "file"),
+		"readlink": readlinkErr,
+		"chtimes":  root.Chtimes("missing", time.Now(), time.Now()),
+		"statvfs":  statvfsErr,
+		"chmod":    root.Chmod("missing", 0o600),
+	} {
+		if assert.Error(t, err, name) {
+			assert.NotContains(t, err.Error(), dir, "%s names the path on the host", name)
+		}
+	}
+}
+
+func TestWithRootMissing(t *testing.T) {
+	_, err := NewServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{}, WithRoot(filepath.Join(t.TempDir(), "missing")))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -7,10 +7,6 @@ import (
 	"syscall"
 )
 
-func (p *sshFxpExtendedPacketStatVFS) respond(svr *Server) responsePacket {
-	return statusFromError(p.ID, syscall.ENOTSUP)
-}
-
 func getStatVFSForPath(name string) (*StatVFS, error) {
 	return nil, syscall.ENOTSUP
 }
@@ -16,10 +16,9 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
-func clientServerPair(t *testing.T) (*Client, *Server) {
+func clientServerPair(t *testing.T, options ...ServerOption) (*Client, *Server) {
 	cr, sw := io.Pipe()
 	sr, cw := io.Pipe()
-	var options []ServerOption
 	if *testAllocator {
 		options = append(options, WithAllocator())
 	}
@@ -291,6 +290,89 @@ func TestOpenWithPermissions(t *testing.T) {
 	checkServerAllocator(t, server)
 }
 
+func TestCreateWithMode(t *testing.T) {
+	skipIfWindows(t)
+
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	dir := t.TempDir()
+
+	checkPerm := func(name string, want os.FileMode) {
+		t.Helper()
+
+		fi, err := os.Stat(name)
+		require.NoError(t, err)
+		assert.Equal(t, want, fi.Mode()&os.ModePerm, name)
+	}
+
+	f, err := client.OpenFileMode(path.Join(dir, "secret"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	checkPerm(path.Join(dir, "secret"), 0o600)
+
+	require.NoError(t, client.MkdirMode(path.Join(dir, "private"), 0o700))
+	checkPerm(path.Join(dir, "private"), 0o700)
+
+	// without a mode, the server falls back to its default.
+	require.NoError(t, client.Mkdir(path.Join(dir, "public")))
+	checkPerm(path.Join(dir, "public"), 0o755&^umask(t))
+
+	require.NoError(t, client.MkdirAllMode(path.Join(dir, "a", "b"), 0o750))
+	checkPerm(path.Join(dir, "a"), 0o750)
+	checkPerm(path.Join(dir, "a", "b"), 0o750)
+
+	// existing directories keep their permissions.
+	require.NoError(t, client.MkdirAllMode(path.Join(dir, "private", "c"), 0o750))
+	checkPerm(path.Join(dir, "private"), 0o700)
+	checkPerm(path.Join(dir, "private", "c"), 0o750)
+}
+
+func TestServerFsync(t *testing.T) {
+	skipIfWindows(t)
+
+	dir := t.TempDir()
+
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	_, ok := client.HasExtension("fsync@openssh.com")
+	require.True(t, ok, "server doesn't list fsync extension")
+
+	f, err := client.Create(path.Join(dir, "file"))
+	require.NoError(t, err)
+
+	_, err = f.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, f.Sync())
+	require.NoError(t, f.Close())
+
+	// fsync counts as a write, as in OpenSSH.
+	roClient, roServer := clientServerPair(t, ReadOnly())
+	defer roClient.Close()
+	defer roServer.Close()
+
+	f, err = roClient.Open(path.Join(dir, "file"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	assert.ErrorIs(t, f.Sync(), os.ErrPermission)
+}
+
+// umask returns the umask of the process, which also applies to the server under test.
+func umask(t *testing.T) os.FileMode {
+	dir := t.TempDir()
+	name := path.Join(dir, "umask")
+
+	require.NoError(t, os.Mkdir(name, 0o777))
+	fi, err := os.Stat(name)
+	require.NoError(t, err)
+
+	return 0o777 &^ (fi.Mode() & os.ModePerm)
+}
+
 // Ensure that proper error codes are returned for non existent files, such
 // that they are mapped back to a 'not exists' error on the client side.
 func TestStatNonExistent(t *testing.T) {
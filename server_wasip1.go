@@ -8,7 +8,7 @@ import (
 	"os"
 )
 
-func (s *Server) openfile(path string, flag int, mode fs.FileMode) (file, error) {
+func (s *Server) openfile(path string, flag int, mode fs.FileMode) (ServerFile, error) {
 	return nil, nil
 }
 
@@ -148,12 +148,15 @@ func (f *winRoot) Chmod(mode fs.FileMode) error {
 func (f *winRoot) Chown(uid, gid int) error {
 	return os.ErrPermission
 }
+func (f *winRoot) Sync() error {
+	return nil
+}
 func (f *winRoot) Close() error {
 	f.drives = nil
 	return nil
 }
 
-func (s *Server) openfile(path string, flag int, mode fs.FileMode) (file, error) {
+func (s *Server) openfile(path string, flag int, mode fs.FileMode) (ServerFile, error) {
 	if path == `\\.\` && s.winRoot {
 		return newWinRoot()
 	}
@@ -4,6 +4,8 @@ package sftp
 
 import (
 	"fmt"
+	"io"
+	"io/fs"
 )
 
 const (
@@ -86,6 +88,7 @@ const (
 var (
 	// supportedSFTPExtensions defines the supported extensions
 	supportedSFTPExtensions = []sshExtensionPair{
+		{"fsync@openssh.com", "1"},
 		{"hardlink@openssh.com", "1"},
 		{"posix-rename@openssh.com", "1"},
 		{"statvfs@openssh.com", "2"},
@@ -178,6 +181,8 @@ func (f fx) String() string {
 		return "SSH_FX_CONNECTION_LOST"
 	case sshFxOPUnsupported:
 		return "SSH_FX_OP_UNSUPPORTED"
+	case sshFxQuotaExceeded:
+		return "SSH_FX_QUOTA_EXCEEDED"
 	default:
 		return "unknown"
 	}
@@ -217,6 +222,14 @@ func (u *unexpectedVersionErr) Error() string {
 
 // A StatusError is returned when an SFTP operation fails, and provides
 // additional information about the failure.
+//
+// Errors returned by Client and File methods wrap it in an *fs.PathError or *os.LinkError,
+// so it should be retrieved with errors.As.
+// It matches fs.ErrNotExist, fs.ErrPermission, fs.ErrExist and the exported ErrSSHFx codes with errors.Is.
+//
+// SSH_FX_NO_SUCH_FILE and SSH_FX_PERMISSION_DENIED are the exception:
+// the Client reports them as fs.ErrNotExist and fs.ErrPermission in the *fs.PathError,
+// as os.IsNotExist and os.IsPermission only recognize those.
 type StatusError struct {
 	Code      uint32
 	msg, lang string
@@ -231,6 +244,34 @@ func (s *StatusError) FxCode() fxerr {
 	return fxerr(s.Code)
 }
 
+// Message returns the error message sent by the server.
+func (s *StatusError) Message() string {
+	return s.msg
+}
+
+// Is reports whether the status code is the one target stands for.
+func (s *StatusError) Is(target error) bool {
+	switch target {
+	case fs.ErrNotExist:
+		return s.Code == sshFxNoSuchFile
+	case fs.ErrPermission:
+		return s.Code == sshFxPermissionDenied
+	case fs.ErrExist:
+		return s.Code == sshFxFileAlreadyExists
+	case io.EOF:
+		return s.Code == sshFxEOF
+	}
+
+	switch target := target.(type) {
+	case fxerr:
+		return s.Code == uint32(target)
+	case *StatusError:
+		return s.Code == target.Code
+	}
+
+	return false
+}
+
 func getSupportedExtensionByName(extensionName string) (sshExtensionPair, error) {
 	for _, supportedExtension := range supportedSFTPExtensions {
 		if supportedExtension.Name == extensionName {
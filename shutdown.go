@@ -0,0 +1,128 @@
+package sftp
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrServerShutdown is the error of the requests to open files and directories
+// once Shutdown has been called, and the error Serve returns after it.
+// It is also passed to TransferError for the handles closed by Shutdown.
+var ErrServerShutdown = errors.New("sftp: server shut down")
+
+// shutdownPollInterval is how often Shutdown checks whether the server is drained.
+const shutdownPollInterval = 10 * time.Millisecond
+
+// opensHandle reports whether pkt opens a file or a directory.
+func opensHandle(pkt requestPacket) bool {
+	switch pkt.(type) {
+	case *sshFxpOpenPacket, *sshFxpOpendirPacket:
+		return true
+	}
+	return false
+}
+
+// drain waits until drained reports true, or ctx is done.
+func drain(ctx context.Context, drained func() bool) error {
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	for !drained() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// Shutdown gracefully stops the Server.
+// Requests to open files and directories fail with ErrServerShutdown from then on,
+// while the requests in flight are answered, and the client gets to close the files it opened for writing,
+// until ctx is done.
+// Shutdown then closes the handles left open and the connection, and Serve returns ErrServerShutdown.
+//
+// Shutdown returns the error of ctx if it was done before the Server was drained.
+func (svr *Server) Shutdown(ctx context.Context) error {
+	svr.shuttingDown.Store(true)
+
+	err := drain(ctx, func() bool {
+		return svr.pktMgr.pending.Load() == 0 && !svr.hasWriteHandles()
+	})
+
+	svr.openFilesLock.Lock()
+	for handle, f := range svr.openFiles {
+		f.Close()
+		svr.audit(newHandleAuditRecord(svr.openStats[handle], ErrServerShutdown))
+
+		delete(svr.openFiles, handle)
+		delete(svr.openStats, handle)
+	}
+	svr.openFilesLock.Unlock()
+
+	svr.conn.Close()
+	return err
+}
+
+// hasWriteHandles reports whether files opened for writing are open.
+func (svr *Server) hasWriteHandles() bool {
+	svr.openFilesLock.RLock()
+	defer svr.openFilesLock.RUnlock()
+
+	for _, st := range svr.openStats {
+		if writable(st.method) {
+			return true
+		}
+	}
+	return false
+}
+
+// Shutdown gracefully stops the RequestServer.
+// Requests to open files and directories fail with ErrServerShutdown from then on, without calling the Handlers,
+// while the requests in flight are answered, and the client gets to close the files it opened for writing,
+// until ctx is done.
+// Shutdown then closes the handles left open, passing ErrServerShutdown to TransferError,
+// and the connection, and Serve returns ErrServerShutdown.
+//
+// Shutdown returns the error of ctx if it was done before the RequestServer was drained.
+func (rs *RequestServer) Shutdown(ctx context.Context) error {
+	rs.shuttingDown.Store(true)
+
+	err := drain(ctx, func() bool {
+		return rs.pktMgr.pending.Load() == 0 && !rs.hasWriteHandles()
+	})
+
+	rs.mu.Lock()
+	left := make([]*Request, 0, len(rs.openRequests))
+	for handle, r := range rs.openRequests {
+		left = append(left, r)
+		delete(rs.openRequests, handle)
+	}
+	rs.mu.Unlock()
+
+	for _, r := range left {
+		r.transferError(ErrServerShutdown)
+		r.close()
+		rs.auditRequest(r, ErrServerShutdown)
+	}
+
+	rs.conn.Close()
+	return err
+}
+
+// hasWriteHandles reports whether files opened for writing are open.
+func (rs *RequestServer) hasWriteHandles() bool {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+
+	for _, r := range rs.openRequests {
+		// The method of a Request is set as it is opened, its flags before.
+		flags := r.Pflags()
+		if flags.Write || flags.Append || flags.Creat || flags.Trunc {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,144 @@
+package sftp
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// waitShuttingDown waits for Shutdown to have been called on a server.
+func waitShuttingDown(t *testing.T, shuttingDown func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !shuttingDown() {
+		require.True(t, time.Now().Before(deadline), "Shutdown not called")
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	skipIfWindows(t)
+
+	dir := t.TempDir()
+
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	server, err := NewServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{sr, sw}, WithRoot(dir))
+	require.NoError(t, err)
+
+	served := make(chan error, 1)
+	go func() { served <- server.Serve() }()
+
+	client, err := NewClientPipe(cr, cw)
+	require.NoError(t, err)
+	defer client.Close()
+
+	f, err := client.Create("/upload")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("hello "))
+	require.NoError(t, err)
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- server.Shutdown(context.Background()) }()
+	waitShuttingDown(t, server.shuttingDown.Load)
+
+	_, err = client.Open("/upload")
+	assert.ErrorContains(t, err, ErrServerShutdown.Error())
+
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown returned %v with a file open for writing", err)
+	default:
+	}
+
+	// The upload in progress completes.
+	_, err = f.Write([]byte("world"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	require.NoError(t, <-shutdown)
+	assert.ErrorIs(t, <-served, ErrServerShutdown)
+
+	b, err := os.ReadFile(filepath.Join(dir, "upload"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(b))
+}
+
+func TestServerShutdownTimeout(t *testing.T) {
+	skipIfWindows(t)
+
+	sink := &recordingAuditSink{}
+
+	client, server := clientServerPair(t, WithRoot(t.TempDir()), WithAuditSink(sink))
+	defer client.Close()
+	defer server.Close()
+
+	_, err := client.Create("/upload")
+	require.NoError(t, err)
+	_, err = client.ReadDir("/")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	assert.ErrorIs(t, server.Shutdown(ctx), context.DeadlineExceeded)
+
+	recs := sink.find("Open", "/upload")
+	require.Len(t, recs, 1)
+	assert.ErrorIs(t, recs[0].Err, ErrServerShutdown)
+}
+
+func TestRequestServerShutdown(t *testing.T) {
+	p := clientRequestServerPair(t)
+	defer p.Close()
+
+	w, err := p.cli.Create("/foo")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("hello"))
+	require.NoError(t, err)
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- p.svr.Shutdown(context.Background()) }()
+	waitShuttingDown(t, p.svr.shuttingDown.Load)
+
+	_, err = p.cli.Create("/bar")
+	assert.ErrorContains(t, err, ErrServerShutdown.Error())
+	_, err = p.testHandler().fetch("/bar")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	require.NoError(t, w.Close())
+	require.NoError(t, <-shutdown)
+	assert.ErrorIs(t, <-p.svrResult, ErrServerShutdown)
+}
+
+func TestRequestServerShutdownTimeout(t *testing.T) {
+	p := clientRequestServerPair(t)
+	defer p.Close()
+
+	w, err := p.cli.Create("/foo")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("hello"))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	assert.ErrorIs(t, p.svr.Shutdown(ctx), context.DeadlineExceeded)
+	assert.ErrorIs(t, <-p.svrResult, ErrServerShutdown)
+
+	file, err := p.testHandler().fetch("/foo")
+	require.NoError(t, err)
+	file.mu.RLock()
+	assert.ErrorIs(t, file.err, ErrServerShutdown, "TransferError is called")
+	file.mu.RUnlock()
+}
@@ -0,0 +1,420 @@
+package sftp
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/kr/fs"
+)
+
+// Sub returns an FS corresponding to the subtree rooted at dir on the server, see SubFS.
+func (c *Client) Sub(dir string) (FS, error) {
+	return SubFS(NewClientFS(c), dir)
+}
+
+// SubFS returns an FS corresponding to the subtree rooted at dir of fsys, like fs.Sub.
+//
+// Every path given to the returned FS is resolved relative to dir,
+// whose working directory is its root "/", and paths that would climb above the root
+// with ".." are rejected with an error matching os.ErrPermission.
+// The paths it returns, from RealPath, Glob, Walk, ReadLink and in errors,
+// are translated to its namespace; absolute symbolic link targets are translated both ways.
+//
+// The confinement is only lexical: the server still follows symbolic links that point out of dir.
+// Closing the returned FS does not close fsys.
+func SubFS(fsys FS, dir string) (FS, error) {
+	root, err := fsys.RealPath(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := fsys.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, &os.PathError{Op: "sub", Path: dir, Err: syscall.ENOTDIR}
+	}
+
+	return &subFS{fsys: fsys, dir: root}, nil
+}
+
+type subFS struct {
+	fsys FS
+	dir  string
+}
+
+// full returns the path in the parent FS of the scoped path p,
+// or an error if p climbs above the root.
+func (s *subFS) full(op, p string) (string, error) {
+	abs := p
+	if !path.IsAbs(abs) {
+		abs = "/" + abs
+	}
+
+	if !withinRoot(abs) {
+		return "", &os.PathError{Op: op, Path: p, Err: os.ErrPermission}
+	}
+
+	return path.Join(s.dir, abs), nil
+}
+
+// withinRoot reports whether the absolute path p does not climb above "/" with "..".
+func withinRoot(p string) bool {
+	var depth int
+	for _, elem := range strings.Split(p, "/") {
+		switch elem {
+		case "", ".":
+		case "..":
+			if depth--; depth < 0 {
+				return false
+			}
+		default:
+			depth++
+		}
+	}
+	return true
+}
+
+// scoped returns the scoped path of the path p in the parent FS, or false if it lies outside the root.
+func (s *subFS) scoped(p string) (string, bool) {
+	p = path.Clean(p)
+
+	if s.dir == "/" {
+		return p, path.IsAbs(p)
+	}
+
+	if p == s.dir {
+		return "/", true
+	}
+
+	rest, ok := strings.CutPrefix(p, s.dir+"/")
+	return "/" + rest, ok
+}
+
+// wrapErr translates the paths of the parent FS found in err to the scoped namespace.
+func (s *subFS) wrapErr(err error) error {
+	switch e := err.(type) {
+	case *os.PathError:
+		if p, ok := s.scoped(e.Path); ok {
+			return &os.PathError{Op: e.Op, Path: p, Err: e.Err}
+		}
+	case *os.LinkError:
+		oldname, ok := s.scoped(e.Old)
+		newname, newOK := s.scoped(e.New)
+		if ok && newOK {
+			return &os.LinkError{Op: e.Op, Old: oldname, New: newname, Err: e.Err}
+		}
+	}
+	return err
+}
+
+func (s *subFS) Stat(p string) (os.FileInfo, error) {
+	full, err := s.full("stat", p)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := s.fsys.Stat(full)
+	return fi, s.wrapErr(err)
+}
+
+func (s *subFS) Lstat(p string) (os.FileInfo, error) {
+	full, err := s.full("lstat", p)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := s.fsys.Lstat(full)
+	return fi, s.wrapErr(err)
+}
+
+func (s *subFS) ReadDir(p string) ([]os.FileInfo, error) {
+	return s.ReadDirContext(context.Background(), p)
+}
+
+func (s *subFS) ReadDirContext(ctx context.Context, p string) ([]os.FileInfo, error) {
+	full, err := s.full("readdir", p)
+	if err != nil {
+		return nil, err
+	}
+	fis, err := s.fsys.ReadDirContext(ctx, full)
+	return fis, s.wrapErr(err)
+}
+
+// ReadLink returns the target of the symbolic link p.
+// An absolute target is translated to the scoped namespace,
+// and is an error matching os.ErrPermission if it lies outside the root.
+func (s *subFS) ReadLink(p string) (string, error) {
+	full, err := s.full("readlink", p)
+	if err != nil {
+		return "", err
+	}
+
+	target, err := s.fsys.ReadLink(full)
+	if err != nil {
+		return "", s.wrapErr(err)
+	}
+
+	if !path.IsAbs(target) {
+		return target, nil
+	}
+
+	scoped, ok := s.scoped(target)
+	if !ok {
+		return "", &os.PathError{Op: "readlink", Path: p, Err: os.ErrPermission}
+	}
+	return scoped, nil
+}
+
+// RealPath canonicalizes p on the server, and translates it to the scoped namespace.
+// It is an error matching os.ErrPermission if p resolves outside the root.
+func (s *subFS) RealPath(p string) (string, error) {
+	full, err := s.full("realpath", p)
+	if err != nil {
+		return "", err
+	}
+
+	resolved, err := s.fsys.RealPath(full)
+	if err != nil {
+		return "", s.wrapErr(err)
+	}
+
+	scoped, ok := s.scoped(resolved)
+	if !ok {
+		return "", &os.PathError{Op: "realpath", Path: p, Err: os.ErrPermission}
+	}
+	return scoped, nil
+}
+
+// Getwd returns the root, which is the working directory of the FS.
+func (s *subFS) Getwd() (string, error) {
+	return "/", nil
+}
+
+func (s *subFS) StatVFS(p string) (*StatVFS, error) {
+	full, err := s.full("statvfs", p)
+	if err != nil {
+		return nil, err
+	}
+	st, err := s.fsys.StatVFS(full)
+	return st, s.wrapErr(err)
+}
+
+func (s *subFS) Walk(root string) *fs.Walker {
+	return fs.WalkFS(root, s)
+}
+
+func (s *subFS) Glob(pattern string) ([]string, error) {
+	return glob(s, pattern)
+}
+
+func (s *subFS) Join(elem ...string) string { return path.Join(elem...) }
+
+func (s *subFS) Open(p string) (FileHandle, error) {
+	return s.open(p, s.fsys.Open)
+}
+
+func (s *subFS) Create(p string) (FileHandle, error) {
+	return s.open(p, s.fsys.Create)
+}
+
+func (s *subFS) OpenFile(p string, f int) (FileHandle, error) {
+	return s.open(p, func(full string) (FileHandle, error) { return s.fsys.OpenFile(full, f) })
+}
+
+func (s *subFS) OpenFileMode(p string, f int, perm os.FileMode) (FileHandle, error) {
+	return s.open(p, func(full string) (FileHandle, error) { return s.fsys.OpenFileMode(full, f, perm) })
+}
+
+func (s *subFS) open(p string, fn func(full string) (FileHandle, error)) (FileHandle, error) {
+	full, err := s.full("open", p)
+	if err != nil {
+		return nil, err
+	}
+
+	fh, err := fn(full)
+	if err != nil {
+		return nil, s.wrapErr(err)
+	}
+
+	return subFile{FileHandle: fh, sub: s, name: p}, nil
+}
+
+// subFile is a file opened through a subFS, named as it was opened.
+// The paths in its errors are translated to the scoped namespace.
+type subFile struct {
+	FileHandle
+	sub  *subFS
+	name string
+}
+
+func (f subFile) Name() string {
+	return f.name
+}
+
+func (f subFile) Read(b []byte) (int, error) {
+	n, err := f.FileHandle.Read(b)
+	return n, f.sub.wrapErr(err)
+}
+
+func (f subFile) ReadAt(b []byte, off int64) (int, error) {
+	n, err := f.FileHandle.ReadAt(b, off)
+	return n, f.sub.wrapErr(err)
+}
+
+func (f subFile) Write(b []byte) (int, error) {
+	n, err := f.FileHandle.Write(b)
+	return n, f.sub.wrapErr(err)
+}
+
+func (f subFile) WriteAt(b []byte, off int64) (int, error) {
+	n, err := f.FileHandle.WriteAt(b, off)
+	return n, f.sub.wrapErr(err)
+}
+
+func (f subFile) Seek(offset int64, whence int) (int64, error) {
+	off, err := f.FileHandle.Seek(offset, whence)
+	return off, f.sub.wrapErr(err)
+}
+
+func (f subFile) WriteTo(w io.Writer) (int64, error) {
+	n, err := f.FileHandle.WriteTo(w)
+	return n, f.sub.wrapErr(err)
+}
+
+func (f subFile) ReadFrom(r io.Reader) (int64, error) {
+	n, err := f.FileHandle.ReadFrom(r)
+	return n, f.sub.wrapErr(err)
+}
+
+func (f subFile) Stat() (os.FileInfo, error) {
+	fi, err := f.FileHandle.Stat()
+	return fi, f.sub.wrapErr(err)
+}
+
+func (f subFile) Chmod(mode os.FileMode) error {
+	return f.sub.wrapErr(f.FileHandle.Chmod(mode))
+}
+
+func (f subFile) Chown(uid, gid int) error {
+	return f.sub.wrapErr(f.FileHandle.Chown(uid, gid))
+}
+
+func (f subFile) Truncate(size int64) error {
+	return f.sub.wrapErr(f.FileHandle.Truncate(size))
+}
+
+func (f subFile) Sync() error {
+	return f.sub.wrapErr(f.FileHandle.Sync())
+}
+
+func (f subFile) Close() error {
+	return f.sub.wrapErr(f.FileHandle.Close())
+}
+
+func (s *subFS) do(op, p string, fn func(full string) error) error {
+	full, err := s.full(op, p)
+	if err != nil {
+		return err
+	}
+	return s.wrapErr(fn(full))
+}
+
+func (s *subFS) doLink(op, oldname, newname string, fn func(oldFull, newFull string) error) error {
+	oldFull, err := s.full(op, oldname)
+	if err != nil {
+		return err
+	}
+	newFull, err := s.full(op, newname)
+	if err != nil {
+		return err
+	}
+	return s.wrapErr(fn(oldFull, newFull))
+}
+
+func (s *subFS) Mkdir(p string) error {
+	return s.do("mkdir", p, s.fsys.Mkdir)
+}
+
+func (s *subFS) MkdirMode(p string, perm os.FileMode) error {
+	return s.do("mkdir", p, func(full string) error { return s.fsys.MkdirMode(full, perm) })
+}
+
+func (s *subFS) MkdirAll(p string) error {
+	return s.do("mkdir", p, s.fsys.MkdirAll)
+}
+
+func (s *subFS) MkdirAllMode(p string, perm os.FileMode) error {
+	return s.do("mkdir", p, func(full string) error { return s.fsys.MkdirAllMode(full, perm) })
+}
+
+func (s *subFS) Link(oldname, newname string) error {
+	return s.doLink("link", oldname, newname, s.fsys.Link)
+}
+
+// Symlink creates newname as a symbolic link to oldname.
+// An absolute oldname is translated from the scoped namespace,
+// and a relative oldname must not climb above the root from the directory of newname.
+func (s *subFS) Symlink(oldname, newname string) error {
+	newFull, err := s.full("symlink", newname)
+	if err != nil {
+		return err
+	}
+
+	target := oldname
+	if path.IsAbs(oldname) {
+		target, err = s.full("symlink", oldname)
+		if err != nil {
+			return err
+		}
+	} else if !withinRoot(path.Dir(path.Join("/", newname)) + "/" + oldname) {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: os.ErrPermission}
+	}
+
+	return s.wrapErr(s.fsys.Symlink(target, newFull))
+}
+
+func (s *subFS) Rename(oldname, newname string) error {
+	return s.doLink("rename", oldname, newname, s.fsys.Rename)
+}
+
+func (s *subFS) PosixRename(oldname, newname string) error {
+	return s.doLink("rename", oldname, newname, s.fsys.PosixRename)
+}
+
+func (s *subFS) Remove(p string) error {
+	return s.do("remove", p, s.fsys.Remove)
+}
+
+func (s *subFS) RemoveDirectory(p string) error {
+	return s.do("remove", p, s.fsys.RemoveDirectory)
+}
+
+func (s *subFS) RemoveAll(p string) error {
+	return s.do("remove", p, s.fsys.RemoveAll)
+}
+
+func (s *subFS) Chtimes(p string, atime time.Time, mtime time.Time) error {
+	return s.do("chtimes", p, func(full string) error { return s.fsys.Chtimes(full, atime, mtime) })
+}
+
+func (s *subFS) Chown(p string, uid, gid int) error {
+	return s.do("chown", p, func(full string) error { return s.fsys.Chown(full, uid, gid) })
+}
+
+func (s *subFS) Chmod(p string, mode os.FileMode) error {
+	return s.do("chmod", p, func(full string) error { return s.fsys.Chmod(full, mode) })
+}
+
+func (s *subFS) Truncate(p string, size int64) error {
+	return s.do("truncate", p, func(full string) error { return s.fsys.Truncate(full, size) })
+}
+
+// Close does nothing, as the parent FS is shared.
+func (s *subFS) Close() error {
+	return nil
+}
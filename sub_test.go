@@ -0,0 +1,130 @@
+package sftp
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientSub(t *testing.T) {
+	skipIfWindows(t)
+
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	base, err := filepath.EvalSymlinks(t.TempDir())
+	require.NoError(t, err)
+
+	dir := filepath.Join(base, "tenant")
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(base, "secret"), []byte("secret"), 0o600))
+
+	sub, err := client.Sub(dir)
+	require.NoError(t, err)
+	defer sub.Close()
+
+	f, err := sub.Create("/sub/file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "/sub/file.txt", f.Name())
+	_, err = f.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	got, err := os.ReadFile(filepath.Join(dir, "sub", "file.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(got))
+
+	// Relative paths are relative to the root.
+	f, err = sub.Open("sub/../sub/file.txt")
+	require.NoError(t, err)
+	b, err := io.ReadAll(f)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+	require.NoError(t, f.Close())
+
+	// Errors of the file name it in the scoped namespace.
+	f, err = sub.Open("/sub/file.txt")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("read-only"))
+	var pathErr *os.PathError
+	require.ErrorAs(t, err, &pathErr)
+	assert.Equal(t, "/sub/file.txt", pathErr.Path)
+	require.NoError(t, f.Close())
+	_, err = f.Stat()
+	require.ErrorAs(t, err, &pathErr)
+	assert.Equal(t, "/sub/file.txt", pathErr.Path)
+
+	for _, p := range []string{"../secret", "/../secret", "sub/../../secret"} {
+		_, err := sub.Open(p)
+		assert.ErrorIs(t, err, os.ErrPermission, p)
+	}
+
+	_, err = sub.Stat("/missing")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.Equal(t, "/missing", err.(*os.PathError).Path)
+
+	wd, err := sub.Getwd()
+	require.NoError(t, err)
+	assert.Equal(t, "/", wd)
+
+	resolved, err := sub.RealPath("sub/./file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "/sub/file.txt", resolved)
+
+	matches, err := sub.Glob("/sub/*.txt")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/sub/file.txt"}, matches)
+
+	var walked []string
+	for w := sub.Walk("/"); w.Step(); {
+		require.NoError(t, w.Err())
+		walked = append(walked, w.Path())
+	}
+	assert.Equal(t, []string{"/", "/sub", "/sub/file.txt"}, walked)
+
+	// Absolute symbolic link targets are translated both ways.
+	require.NoError(t, sub.Symlink("/sub/file.txt", "/link"))
+
+	target, err := os.Readlink(filepath.Join(dir, "link"))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "sub", "file.txt"), target)
+
+	target, err = sub.ReadLink("/link")
+	require.NoError(t, err)
+	assert.Equal(t, "/sub/file.txt", target)
+
+	err = sub.Symlink("../../secret", "/sub/escape")
+	assert.ErrorIs(t, err, os.ErrPermission)
+
+	require.NoError(t, os.Symlink(filepath.Join(base, "secret"), filepath.Join(dir, "outside")))
+	_, err = sub.ReadLink("/outside")
+	assert.ErrorIs(t, err, os.ErrPermission)
+
+	_, err = client.Sub(filepath.Join(base, "secret"))
+	assert.Error(t, err, "not a directory")
+}
+
+func TestSubFSNested(t *testing.T) {
+	m := NewMemFS()
+	require.NoError(t, m.MkdirAll("/a/b/c"))
+
+	a, err := SubFS(m, "/a")
+	require.NoError(t, err)
+
+	b, err := SubFS(a, "b")
+	require.NoError(t, err)
+
+	require.NoError(t, b.Mkdir("/c/d"))
+
+	fi, err := m.Stat("/a/b/c/d")
+	require.NoError(t, err)
+	assert.True(t, fi.IsDir())
+
+	err = b.Rename("/c/d", "/../d")
+	assert.ErrorIs(t, err, os.ErrPermission)
+}
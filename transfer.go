@@ -0,0 +1,191 @@
+package sftp
+
+import (
+	"errors"
+	"os"
+	"path"
+)
+
+// A TransferOption is a function which applies configuration to a Transfer.
+type TransferOption func(*transfer) error
+
+// TransferConcurrency sets how many chunks of a file are transferred at the same time.
+// Each chunk is read from the source and written to the destination by its own request pair.
+//
+// The default is the lower of the two Clients' MaxConcurrentRequestsPerFile.
+func TransferConcurrency(n int) TransferOption {
+	return func(t *transfer) error {
+		if n < 1 {
+			return errors.New("n must be greater or equal to 1")
+		}
+		t.concurrency = n
+		return nil
+	}
+}
+
+// TransferPreserve sets whether the permissions, access and modification times
+// of the source are applied to the destination.
+// Ownership is never preserved, as user and group IDs are rarely shared between two servers.
+//
+// The default is to preserve them.
+func TransferPreserve(value bool) TransferOption {
+	return func(t *transfer) error {
+		t.preserve = value
+		return nil
+	}
+}
+
+type transfer struct {
+	src, dst *Client
+
+	concurrency int
+	chunkSize   int
+	preserve    bool
+}
+
+// Transfer copies srcPath on src to dstPath on dst, without passing the data through a local file.
+//
+// Chunks of a regular file are read concurrently from src,
+// and every chunk is written to dst at the same offset it was read from,
+// so both servers are kept busy at the same time.
+// Directories are copied recursively, and symbolic links are recreated as such.
+//
+// If an error occurs, the destination may be left partially written.
+func Transfer(src *Client, srcPath string, dst *Client, dstPath string, opts ...TransferOption) error {
+	t := &transfer{
+		src: src,
+		dst: dst,
+
+		concurrency: min(src.maxConcurrentRequests, dst.maxConcurrentRequests),
+		chunkSize:   min(src.maxPacket, dst.maxPacket),
+		preserve:    true,
+	}
+
+	for _, opt := range opts {
+		if err := opt(t); err != nil {
+			return err
+		}
+	}
+
+	fi, err := src.Lstat(srcPath)
+	if err != nil {
+		return err
+	}
+
+	return t.copy(srcPath, dstPath, fi)
+}
+
+func (t *transfer) copy(srcPath, dstPath string, fi os.FileInfo) error {
+	switch mode := fi.Mode(); {
+	case mode.IsDir():
+		return t.copyDir(srcPath, dstPath, fi)
+
+	case mode&os.ModeSymlink != 0:
+		target, err := t.src.ReadLink(srcPath)
+		if err != nil {
+			return err
+		}
+		return t.dst.Symlink(target, dstPath)
+
+	case mode.IsRegular():
+		return t.copyFile(srcPath, dstPath, fi)
+	}
+
+	return &os.PathError{Op: "transfer", Path: srcPath, Err: errors.New("unsupported file type")}
+}
+
+func (t *transfer) copyDir(srcPath, dstPath string, fi os.FileInfo) error {
+	var err error
+	if t.preserve {
+		// Keep the directory private to us, until its final permissions are set.
+		err = t.dst.MkdirMode(dstPath, fi.Mode().Perm()&0o700|0o700)
+	} else {
+		err = t.dst.Mkdir(dstPath)
+	}
+	if err != nil {
+		// Merge into an existing directory, like cp -r does.
+		dfi, serr := t.dst.Stat(dstPath)
+		if serr != nil || !dfi.IsDir() {
+			return err
+		}
+	}
+
+	entries, err := t.src.ReadDir(srcPath)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := t.copy(path.Join(srcPath, entry.Name()), path.Join(dstPath, entry.Name()), entry); err != nil {
+			return err
+		}
+	}
+
+	// Apply the metadata last, as copying the entries changes the modification time,
+	// and the permissions might not allow creating them.
+	return t.setMetadata(dstPath, fi)
+}
+
+func (t *transfer) copyFile(srcPath, dstPath string, fi os.FileInfo) error {
+	r, err := t.src.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+
+	var w *File
+	if t.preserve {
+		// Keep the file private to us, until its final permissions are set.
+		w, err = t.dst.OpenFileMode(dstPath, flags, fi.Mode().Perm()&0o700|0o600)
+	} else {
+		w, err = t.dst.OpenFile(dstPath, flags)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := t.copyData(r, w, fi.Size()); err != nil {
+		w.Close()
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return t.setMetadata(dstPath, fi)
+}
+
+// copyData pipelines concurrent reads from r into writes to w at the same offsets.
+// It stops at size, or at the end of r, whichever comes first.
+func (t *transfer) copyData(r, w *File, size int64) error {
+	c := &chunkCopy{
+		concurrency: t.concurrency,
+		chunkSize:   t.chunkSize,
+		end:         size,
+		readAt:      r.ReadAt,
+		writeAt:     w.WriteAt,
+	}
+
+	_, err := c.run()
+	return err
+}
+
+func (t *transfer) setMetadata(dstPath string, fi os.FileInfo) error {
+	if !t.preserve {
+		return nil
+	}
+
+	if err := t.dst.Chmod(dstPath, fi.Mode()); err != nil {
+		return err
+	}
+
+	atime := fi.ModTime()
+	if fs, ok := fi.Sys().(*FileStat); ok {
+		atime = fs.AccessTime()
+	}
+
+	return t.dst.Chtimes(dstPath, atime, fi.ModTime())
+}
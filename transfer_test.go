@@ -0,0 +1,86 @@
+package sftp
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransfer(t *testing.T) {
+	skipIfWindows(t)
+
+	src, srcServer := clientServerPair(t)
+	defer src.Close()
+	defer srcServer.Close()
+
+	dst, dstServer := clientServerPair(t)
+	defer dst.Close()
+	defer dstServer.Close()
+
+	// force many small chunks in flight.
+	src.maxPacket = 1024
+	dst.maxPacket = 512
+
+	srcDir := t.TempDir()
+	dstDir := filepath.Join(t.TempDir(), "copy")
+
+	big := make([]byte, 100*1024+17)
+	rand.New(rand.NewSource(1)).Read(big)
+
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(srcDir, "sub", "empty"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, "big"), big, 0o640))
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, "sub", "small"), []byte("hello"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, "sub", "zero"), nil, 0o644))
+	require.NoError(t, os.Symlink("small", filepath.Join(srcDir, "sub", "link")))
+	require.NoError(t, os.Chtimes(filepath.Join(srcDir, "big"), mtime, mtime))
+	require.NoError(t, os.Chmod(filepath.Join(srcDir, "sub"), 0o750))
+
+	require.NoError(t, Transfer(src, srcDir, dst, dstDir))
+
+	got, err := os.ReadFile(filepath.Join(dstDir, "big"))
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(big, got), "content of big differs")
+
+	got, err = os.ReadFile(filepath.Join(dstDir, "sub", "small"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(got))
+
+	target, err := os.Readlink(filepath.Join(dstDir, "sub", "link"))
+	require.NoError(t, err)
+	assert.Equal(t, "small", target)
+
+	for name, want := range map[string]os.FileMode{
+		"big":       0o640,
+		"sub":       0o750,
+		"sub/small": 0o600,
+		"sub/zero":  0o644,
+		"sub/empty": 0o755,
+	} {
+		fi, err := os.Stat(filepath.Join(dstDir, name))
+		require.NoError(t, err)
+		assert.Equal(t, want, fi.Mode().Perm(), name)
+	}
+
+	fi, err := os.Stat(filepath.Join(dstDir, "big"))
+	require.NoError(t, err)
+	assert.True(t, mtime.Equal(fi.ModTime()), "mtime = %v, want %v", fi.ModTime(), mtime)
+}
+
+func TestTransferMissingSource(t *testing.T) {
+	src, srcServer := clientServerPair(t)
+	defer src.Close()
+	defer srcServer.Close()
+
+	dir := t.TempDir()
+
+	err := Transfer(src, filepath.Join(dir, "missing"), src, filepath.Join(dir, "copy"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
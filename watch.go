@@ -0,0 +1,417 @@
+package sftp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A WatchOp is the kind of change reported by a WatchEvent.
+type WatchOp int
+
+// The kinds of change reported by Client.Watch.
+const (
+	// WatchCreate reports a file that did not exist in the previous snapshot.
+	WatchCreate WatchOp = iota + 1
+
+	// WatchModify reports a file whose size, modification time or mode changed.
+	WatchModify
+
+	// WatchDelete reports a file that no longer exists.
+	WatchDelete
+
+	// WatchRename reports a file that disappeared from OldPath as an identical one appeared at Path.
+	// This is only a candidate, as SFTP cannot tell a rename from a copy followed by a delete.
+	WatchRename
+
+	// WatchError reports an error that prevented a snapshot from being taken.
+	// The next snapshot is tried at the usual interval.
+	WatchError
+)
+
+func (op WatchOp) String() string {
+	switch op {
+	case WatchCreate:
+		return "create"
+	case WatchModify:
+		return "modify"
+	case WatchDelete:
+		return "delete"
+	case WatchRename:
+		return "rename"
+	case WatchError:
+		return "error"
+	default:
+		return fmt.Sprintf("WatchOp(%d)", int(op))
+	}
+}
+
+// A WatchEvent describes a change found by Client.Watch.
+type WatchEvent struct {
+	Op WatchOp
+
+	// Path is the path of the file, joined to the watched root.
+	Path string
+
+	// OldPath is the path the file was renamed from, for WatchRename.
+	OldPath string
+
+	// Info describes the file as last seen, or is nil for WatchDelete and WatchError.
+	Info os.FileInfo
+
+	// Err is the error, for WatchError.
+	Err error
+}
+
+// A WatchOption is a function which applies configuration to Client.Watch.
+type WatchOption func(*watcher) error
+
+// WatchInterval sets how long to wait between two snapshots.
+//
+// The default is 1 second.
+func WatchInterval(d time.Duration) WatchOption {
+	return func(w *watcher) error {
+		if d <= 0 {
+			return errors.New("interval must be greater than 0")
+		}
+		w.interval = d
+		return nil
+	}
+}
+
+// WatchStable sets how many snapshots in a row a created or modified file must be unchanged in,
+// before it is reported.
+// This holds back files that are still being written.
+//
+// The default is 0, to report changes as soon as they are seen.
+func WatchStable(polls int) WatchOption {
+	return func(w *watcher) error {
+		if polls < 0 {
+			return errors.New("polls must be greater or equal to 0")
+		}
+		w.stable = polls
+		return nil
+	}
+}
+
+// WatchConcurrency sets how many directories are listed at the same time while taking a snapshot.
+//
+// The default is 8.
+func WatchConcurrency(n int) WatchOption {
+	return func(w *watcher) error {
+		if n < 1 {
+			return errors.New("n must be greater or equal to 1")
+		}
+		w.concurrency = n
+		return nil
+	}
+}
+
+type watcher struct {
+	c    *Client
+	root string
+
+	interval    time.Duration
+	stable      int
+	concurrency int
+
+	reported  snapshot       // the state as of the events sent so far.
+	prev      snapshot       // the previous snapshot.
+	unchanged map[string]int // how many snapshots in a row each pending change was unchanged in.
+}
+
+// snapshot maps the paths of a tree to the state of their files.
+type snapshot map[string]watchEntry
+
+type watchEntry struct {
+	size  int64
+	mtime time.Time
+	mode  os.FileMode
+	info  os.FileInfo
+}
+
+func (e watchEntry) same(o watchEntry) bool {
+	return e.size == o.size && e.mtime.Equal(o.mtime) && e.mode == o.mode
+}
+
+func newWatchEntry(fi os.FileInfo) watchEntry {
+	e := watchEntry{
+		mode: fi.Mode(),
+		info: fi,
+	}
+
+	// The size and time of a directory change with its entries, which are reported on their own.
+	if !fi.IsDir() {
+		e.size = fi.Size()
+		e.mtime = fi.ModTime()
+	}
+
+	return e
+}
+
+// Watch reports the changes made below root, by comparing recursive snapshots of it taken periodically,
+// as SFTP has no change notifications.
+// Files are compared by size, modification time and mode; symbolic links are not followed.
+//
+// The first snapshot is taken before Watch returns, and the files it finds are not reported.
+// Events are sent on the returned channel, which is closed once ctx is done.
+// Changes made and undone between two snapshots are not seen.
+func (c *Client) Watch(ctx context.Context, root string, opts ...WatchOption) (<-chan WatchEvent, error) {
+	w := &watcher{
+		c:    c,
+		root: root,
+
+		interval:    time.Second,
+		concurrency: 8,
+
+		unchanged: make(map[string]int),
+	}
+
+	for _, opt := range opts {
+		if err := opt(w); err != nil {
+			return nil, err
+		}
+	}
+
+	snap, err := w.snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	w.reported, w.prev = maps.Clone(snap), snap
+
+	events := make(chan WatchEvent)
+	go w.run(ctx, events)
+
+	return events, nil
+}
+
+func (w *watcher) run(ctx context.Context, events chan<- WatchEvent) {
+	defer close(events)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		snap, err := w.snapshot(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			if !w.send(ctx, events, WatchEvent{Op: WatchError, Path: w.root, Err: err}) {
+				return
+			}
+			continue
+		}
+
+		for _, ev := range w.diff(snap) {
+			if !w.send(ctx, events, ev) {
+				return
+			}
+		}
+	}
+}
+
+func (w *watcher) send(ctx context.Context, events chan<- WatchEvent, ev WatchEvent) bool {
+	select {
+	case events <- ev:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// diff returns the events between the reported state and snap, deletions first, each sorted by path,
+// and makes snap the previous snapshot.
+func (w *watcher) diff(snap snapshot) []WatchEvent {
+	var ready, events []WatchEvent
+	pending := make(map[string]bool)
+
+	for p, e := range snap {
+		if r, ok := w.reported[p]; ok && r.same(e) {
+			delete(w.unchanged, p)
+			continue
+		}
+
+		if prev, ok := w.prev[p]; ok && prev.same(e) {
+			w.unchanged[p]++
+		} else {
+			w.unchanged[p] = 0
+		}
+
+		if w.unchanged[p] < w.stable {
+			pending[p] = true
+			continue
+		}
+
+		op := WatchModify
+		if _, ok := w.reported[p]; !ok {
+			op = WatchCreate
+		}
+		ready = append(ready, WatchEvent{Op: op, Path: p, Info: e.info})
+	}
+
+	for p := range w.unchanged {
+		if _, ok := snap[p]; !ok {
+			delete(w.unchanged, p)
+		}
+	}
+
+	for p, r := range w.reported {
+		if _, ok := snap[p]; ok {
+			continue
+		}
+
+		if i := matchRename(ready, r); i >= 0 {
+			ready[i].Op, ready[i].OldPath = WatchRename, p
+			delete(w.reported, p)
+			continue
+		}
+
+		if holdDelete(snap, pending, r) {
+			// It might have been renamed to a file that is not stable yet.
+			continue
+		}
+
+		events = append(events, WatchEvent{Op: WatchDelete, Path: p})
+		delete(w.reported, p)
+	}
+
+	for _, ev := range ready {
+		w.reported[ev.Path] = snap[ev.Path]
+		delete(w.unchanged, ev.Path)
+	}
+
+	w.prev = snap
+
+	byPath := func(a, b WatchEvent) int { return strings.Compare(a.Path, b.Path) }
+	slices.SortFunc(events, byPath)
+	slices.SortFunc(ready, byPath)
+
+	return append(events, ready...)
+}
+
+// matchRename returns the index of the created file in ready that is identical to the deleted file r, or -1.
+func matchRename(ready []WatchEvent, r watchEntry) int {
+	if !r.mode.IsRegular() {
+		return -1
+	}
+
+	for i, ev := range ready {
+		if ev.Op == WatchCreate && ev.OldPath == "" && newWatchEntry(ev.Info).same(r) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// holdDelete reports whether a pending file of snap is identical to the deleted file r.
+func holdDelete(snap snapshot, pending map[string]bool, r watchEntry) bool {
+	if !r.mode.IsRegular() {
+		return false
+	}
+
+	for p := range pending {
+		if snap[p].same(r) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// snapshot lists the tree below the root.
+func (w *watcher) snapshot(ctx context.Context) (snapshot, error) {
+	rootInfo, tree, err := w.c.listTree(ctx, w.root, w.concurrency)
+	if err != nil {
+		return nil, err
+	}
+
+	snap := make(snapshot)
+	if !rootInfo.IsDir() {
+		snap[w.root] = newWatchEntry(rootInfo)
+		return snap, nil
+	}
+
+	for p, fi := range tree {
+		snap[p] = newWatchEntry(fi)
+	}
+
+	return snap, nil
+}
+
+// listTree lists the tree below root recursively, with up to concurrency directories listed at the same time.
+// It returns the information of root, and that of everything below it, by path.
+// Directories removed while the tree is listed are skipped.
+func (c *Client) listTree(ctx context.Context, root string, concurrency int) (os.FileInfo, map[string]os.FileInfo, error) {
+	rootInfo, err := c.Lstat(root)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tree := make(map[string]os.FileInfo)
+	if !rootInfo.IsDir() {
+		return rootInfo, tree, nil
+	}
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+		sem      = make(chan struct{}, concurrency)
+	)
+
+	var list func(dir string)
+	list = func(dir string) {
+		defer wg.Done()
+
+		sem <- struct{}{}
+		fis, err := c.ReadDirContext(ctx, dir)
+		<-sem
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if err != nil {
+			if dir == root || !errors.Is(err, os.ErrNotExist) {
+				if firstErr == nil {
+					firstErr = err
+				}
+			}
+			return
+		}
+
+		for _, fi := range fis {
+			p := path.Join(dir, fi.Name())
+			tree[p] = fi
+
+			if fi.IsDir() {
+				wg.Add(1)
+				go list(p)
+			}
+		}
+	}
+
+	wg.Add(1)
+	list(root)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, nil, firstErr
+	}
+
+	return rootInfo, tree, nil
+}
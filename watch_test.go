@@ -0,0 +1,120 @@
+package sftp
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func watchSnapshot(files map[string]uint64) snapshot {
+	snap := make(snapshot)
+	for p, size := range files {
+		snap[p] = newWatchEntry(fileInfoFromStat(&FileStat{Size: size, Mode: 0o100644, Mtime: 1}, path.Base(p)))
+	}
+	return snap
+}
+
+type watchOps [][2]string
+
+func opsOf(events []WatchEvent) watchOps {
+	var ops watchOps
+	for _, ev := range events {
+		p := ev.Path
+		if ev.OldPath != "" {
+			p = ev.OldPath + " -> " + p
+		}
+		ops = append(ops, [2]string{ev.Op.String(), p})
+	}
+	return ops
+}
+
+func TestWatcherDiff(t *testing.T) {
+	w := &watcher{unchanged: make(map[string]int)}
+	w.reported = watchSnapshot(map[string]uint64{"/a": 1, "/b": 2, "/c": 3})
+	w.prev = w.reported
+
+	ops := opsOf(w.diff(watchSnapshot(map[string]uint64{"/a": 10, "/c": 3, "/d": 4, "/moved": 2})))
+	assert.Equal(t, watchOps{{"modify", "/a"}, {"create", "/d"}, {"rename", "/b -> /moved"}}, ops)
+
+	ops = opsOf(w.diff(watchSnapshot(map[string]uint64{"/a": 10, "/d": 4, "/moved": 2})))
+	assert.Equal(t, watchOps{{"delete", "/c"}}, ops)
+}
+
+func TestWatcherDiffStable(t *testing.T) {
+	w := &watcher{stable: 2, unchanged: make(map[string]int)}
+	w.reported = watchSnapshot(map[string]uint64{"/old": 5})
+	w.prev = w.reported
+
+	// Still being written.
+	assert.Empty(t, w.diff(watchSnapshot(map[string]uint64{"/old": 5, "/new": 1})))
+	assert.Empty(t, w.diff(watchSnapshot(map[string]uint64{"/old": 5, "/new": 2})))
+	assert.Empty(t, w.diff(watchSnapshot(map[string]uint64{"/old": 5, "/new": 2})))
+
+	ops := opsOf(w.diff(watchSnapshot(map[string]uint64{"/old": 5, "/new": 2})))
+	assert.Equal(t, watchOps{{"create", "/new"}}, ops)
+
+	// The delete is held back until the file it might have been renamed to is stable.
+	assert.Empty(t, w.diff(watchSnapshot(map[string]uint64{"/new": 2, "/renamed": 5})))
+	assert.Empty(t, w.diff(watchSnapshot(map[string]uint64{"/new": 2, "/renamed": 5})))
+
+	ops = opsOf(w.diff(watchSnapshot(map[string]uint64{"/new": 2, "/renamed": 5})))
+	assert.Equal(t, watchOps{{"rename", "/old -> /renamed"}}, ops)
+
+	assert.Empty(t, w.diff(watchSnapshot(map[string]uint64{"/new": 2, "/renamed": 5})))
+}
+
+func TestClientWatch(t *testing.T) {
+	skipIfWindows(t)
+
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "existing"), []byte("x"), 0o644))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, err := client.Watch(ctx, dir, WatchInterval(10*time.Millisecond), WatchConcurrency(2))
+	require.NoError(t, err)
+
+	next := func() WatchEvent {
+		t.Helper()
+		select {
+		case ev := <-events:
+			return ev
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for an event")
+			return WatchEvent{}
+		}
+	}
+
+	created := filepath.Join(dir, "sub", "created")
+	require.NoError(t, os.WriteFile(created, []byte("hello"), 0o644))
+
+	ev := next()
+	assert.Equal(t, WatchCreate, ev.Op)
+	assert.Equal(t, path.Join(dir, "sub", "created"), ev.Path)
+	assert.EqualValues(t, 5, ev.Info.Size())
+
+	require.NoError(t, os.Remove(filepath.Join(dir, "sub", "existing")))
+
+	ev = next()
+	assert.Equal(t, WatchDelete, ev.Op)
+	assert.Equal(t, path.Join(dir, "sub", "existing"), ev.Path)
+
+	cancel()
+	for range events {
+	}
+
+	_, err = client.Watch(context.Background(), filepath.Join(dir, "missing"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
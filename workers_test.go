@@ -0,0 +1,160 @@
+package sftp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// appendOnlyChecker fails the writes to a file that are concurrent, or not at its end,
+// like an append-only object store would.
+type appendOnlyChecker struct {
+	mu      sync.Mutex
+	writing bool
+	size    int64
+}
+
+func (c *appendOnlyChecker) write(b []byte, off int64, writeAt func([]byte, int64) (int, error)) (int, error) {
+	c.mu.Lock()
+	if c.writing {
+		c.mu.Unlock()
+		return 0, fmt.Errorf("concurrent write at %d", off)
+	}
+	if off != c.size {
+		c.mu.Unlock()
+		return 0, fmt.Errorf("write at %d, not at the end %d", off, c.size)
+	}
+	c.writing = true
+	c.mu.Unlock()
+
+	// Leave time to the next write to overlap.
+	time.Sleep(time.Millisecond)
+	n, err := writeAt(b, off)
+
+	c.mu.Lock()
+	c.writing = false
+	c.size += int64(n)
+	c.mu.Unlock()
+
+	return n, err
+}
+
+type appendOnlyServerFS struct {
+	ServerFS
+}
+
+type appendOnlyServerFile struct {
+	ServerFile
+	checker appendOnlyChecker
+}
+
+func (fsys appendOnlyServerFS) OpenFile(name string, flag int, perm os.FileMode) (ServerFile, error) {
+	f, err := fsys.ServerFS.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &appendOnlyServerFile{ServerFile: f}, nil
+}
+
+func (f *appendOnlyServerFile) WriteAt(b []byte, off int64) (int, error) {
+	return f.checker.write(b, off, f.ServerFile.WriteAt)
+}
+
+type appendOnlyFileWriter struct {
+	FileWriter
+}
+
+type appendOnlyWriterAt struct {
+	io.WriterAt
+	checker appendOnlyChecker
+}
+
+func (h appendOnlyFileWriter) Filewrite(r *Request) (io.WriterAt, error) {
+	w, err := h.FileWriter.Filewrite(r)
+	if err != nil {
+		return nil, err
+	}
+	return &appendOnlyWriterAt{WriterAt: w}, nil
+}
+
+func (w *appendOnlyWriterAt) WriteAt(b []byte, off int64) (int, error) {
+	return w.checker.write(b, off, w.WriterAt.WriteAt)
+}
+
+// writeFiles writes n files of size bytes in parallel with create.
+func writeFiles(t *testing.T, create func(name string) (*File, error), n, size int) {
+	content := bytes.Repeat([]byte("x"), size)
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			f, err := create(fmt.Sprintf("/file%d", i))
+			if !assert.NoError(t, err) {
+				return
+			}
+			_, err = f.Write(content)
+			assert.NoError(t, err)
+			assert.NoError(t, f.Close())
+		}()
+	}
+	wg.Wait()
+}
+
+func TestServerHandleOrdering(t *testing.T) {
+	skipIfWindows(t)
+
+	dir := t.TempDir()
+	root, err := NewRootServerFS(dir)
+	require.NoError(t, err)
+
+	client, server := clientServerPair(t, WithFS(appendOnlyServerFS{root}), WithHandleOrdering(), WithWorkerCount(3))
+	defer client.Close()
+	defer server.Close()
+
+	writeFiles(t, client.Create, 4, 1<<20)
+
+	fi, err := os.Stat(dir + "/file3")
+	require.NoError(t, err)
+	assert.EqualValues(t, 1<<20, fi.Size())
+}
+
+func TestRequestServerHandleOrdering(t *testing.T) {
+	h := InMemHandler()
+	h.FilePut = appendOnlyFileWriter{h.FilePut}
+
+	p := clientRequestServerPairWithHandlers(t, h, WithRSHandleOrdering(), WithRSWorkerCount(3))
+	defer p.Close()
+
+	writeFiles(t, p.cli.Create, 4, 256<<10)
+
+	f, err := p.testHandler().fetch("/file3")
+	require.NoError(t, err)
+	assert.Len(t, f.content, 256<<10)
+}
+
+func TestWithWorkerCount(t *testing.T) {
+	rw := struct {
+		io.Reader
+		io.WriteCloser
+	}{}
+
+	_, err := NewServer(rw, WithWorkerCount(0))
+	assert.Error(t, err)
+
+	server, err := NewServer(rw, WithWorkerCount(2))
+	require.NoError(t, err)
+	assert.Equal(t, 2, server.pktMgr.workers)
+
+	rs := NewRequestServer(rw, Handlers{}, WithRSWorkerCount(0))
+	assert.Equal(t, SftpServerWorkerCount, rs.pktMgr.workers)
+}
@@ -0,0 +1,204 @@
+package sftp
+
+import (
+	"errors"
+	"sync"
+)
+
+// UseWriteBehind enables buffering of File.Write calls.
+// Consecutive writes are gathered into chunks of the maximum packet size,
+// and up to inflight chunks are sent to the server without waiting for their status,
+// which makes many small writes as fast as a single large one.
+//
+// An error reported by the server for a buffered write is returned by the next call to Write, Sync or Close,
+// and every Write after it fails with the same error.
+// WriteAt, ReadFrom, UploadFrom and Truncate first wait for the buffered writes to complete,
+// and fail with that error as well.
+// Reads, Stat and seeking relative to the end of the File also wait for the buffered writes,
+// but do not report their error.
+// Files opened with O_APPEND are never buffered.
+//
+// By default, every Write waits for the server to acknowledge it.
+func UseWriteBehind(inflight int) ClientOption {
+	return func(c *Client) error {
+		if inflight < 1 {
+			return errors.New("inflight must be greater or equal to 1")
+		}
+		c.writeBehind = inflight
+		return nil
+	}
+}
+
+// newWriteBehind returns the write-behind buffer for a File opened by c with pflags,
+// or nil if its writes should not be buffered.
+func (c *Client) newWriteBehind(pflags uint32) *writeBehind {
+	if c.writeBehind == 0 || pflags&sshFxfAppend != 0 {
+		return nil
+	}
+
+	return &writeBehind{
+		maxInflight: c.writeBehind,
+	}
+}
+
+// writeBehind gathers the data of consecutive writes, and sends it without waiting for the status.
+type writeBehind struct {
+	maxInflight int
+
+	mu       sync.Mutex
+	buf      []byte // data not sent yet.
+	off      int64  // offset of buf.
+	inflight []*pendingWrite
+	free     [][]byte // buffers of completed writes, for reuse.
+	err      error    // the first error, reported by every later write.
+}
+
+type pendingWrite struct {
+	id  uint32
+	res chan result
+	b   []byte
+	off int64
+}
+
+// bufferedWrite implements writeAt for Write, when write-behind is enabled.
+func (f *File) bufferedWrite(b []byte, off int64) (int, error) {
+	wb := f.wb
+
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+
+	if wb.err != nil {
+		return 0, wb.err
+	}
+
+	if len(wb.buf) > 0 && off != wb.off+int64(len(wb.buf)) {
+		// Not contiguous with the buffered data.
+		f.sendBuffered()
+	}
+
+	if len(wb.buf) == 0 {
+		wb.off = off
+	}
+
+	for written := 0; written < len(b); {
+		if wb.buf == nil {
+			wb.buf = f.nextWriteBuffer()
+		}
+
+		n := copy(wb.buf[len(wb.buf):cap(wb.buf)], b[written:])
+		wb.buf = wb.buf[:len(wb.buf)+n]
+		written += n
+
+		if len(wb.buf) == cap(wb.buf) {
+			f.sendBuffered()
+			wb.off = off + int64(written)
+		}
+	}
+
+	return len(b), nil
+}
+
+// nextWriteBuffer returns an empty buffer of the maximum packet size.
+// It must be called while holding the write-behind lock.
+func (f *File) nextWriteBuffer() []byte {
+	wb := f.wb
+
+	if n := len(wb.free); n > 0 {
+		b := wb.free[n-1]
+		wb.free = wb.free[:n-1]
+		return b[:0]
+	}
+
+	return make([]byte, 0, f.c.maxPacket)
+}
+
+// sendBuffered sends the buffered data, waiting for the oldest write if too many are in flight.
+// It must be called while holding the write-behind lock.
+func (f *File) sendBuffered() {
+	wb := f.wb
+
+	if len(wb.buf) == 0 {
+		return
+	}
+
+	if len(wb.inflight) >= wb.maxInflight {
+		f.reapWrite()
+	}
+
+	b := wb.buf
+	wb.buf = nil
+
+	if wb.err != nil {
+		// Do not write anything after a failed write.
+		wb.free = append(wb.free, b)
+		return
+	}
+
+	p := &pendingWrite{
+		id:  f.c.nextID(),
+		res: make(chan result, 1),
+		b:   b,
+		off: wb.off,
+	}
+
+	f.c.dispatchRequest(p.res, &sshFxpWritePacket{
+		ID:     p.id,
+		Handle: f.handle,
+		Offset: uint64(p.off),
+		Length: uint32(len(p.b)),
+		Data:   p.b,
+	})
+
+	wb.inflight = append(wb.inflight, p)
+}
+
+// reapWrite waits for the oldest write in flight to complete, and records its error.
+// It must be called while holding the write-behind lock.
+func (f *File) reapWrite() {
+	wb := f.wb
+
+	p := wb.inflight[0]
+	wb.inflight = wb.inflight[1:]
+
+	s := <-p.res
+
+	err := s.err
+	if err == nil {
+		switch s.typ {
+		case sshFxpStatus:
+			err = normaliseError(unmarshalStatus(p.id, s.data))
+		default:
+			err = unimplementedPacketErr(s.typ)
+		}
+	}
+
+	if err != nil && f.c.retryPolicy != nil && f.c.retryable(err) {
+		// retry the failed chunk on its own.
+		_, err = f.writeChunkAt(p.res, p.b, p.off)
+	}
+
+	if err != nil && wb.err == nil {
+		wb.err = err
+	}
+
+	wb.free = append(wb.free, p.b)
+}
+
+// flushWrites sends the buffered data, and waits for every write in flight.
+// It returns the first error of a buffered write, if any.
+func (f *File) flushWrites() error {
+	wb := f.wb
+	if wb == nil {
+		return nil
+	}
+
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+
+	f.sendBuffered()
+	for len(wb.inflight) > 0 {
+		f.reapWrite()
+	}
+
+	return wb.err
+}
@@ -0,0 +1,107 @@
+package sftp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteBehind(t *testing.T) {
+	h := newInstrumentedHandlers()
+	p := instrumentedPair(t, h, UseWriteBehind(4), MaxPacketUnchecked(1024))
+
+	f, err := p.cli.OpenFile("/file", os.O_RDWR|os.O_CREATE)
+	require.NoError(t, err)
+
+	var want bytes.Buffer
+	for i := 0; i < 500; i++ {
+		row := fmt.Sprintf("%d,row %d\n", i, i)
+		want.WriteString(row)
+
+		n, err := f.Write([]byte(row))
+		require.NoError(t, err)
+		require.Equal(t, len(row), n)
+	}
+
+	// Reading waits for the buffered writes.
+	got := make([]byte, want.Len())
+	n, err := f.ReadAt(got, 0)
+	assert.True(t, err == nil || err == io.EOF)
+	assert.Equal(t, want.Bytes(), got[:n])
+
+	// Seeking back and writing sends the buffer, before the non-contiguous write.
+	_, err = f.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("X"))
+	require.NoError(t, err)
+
+	require.NoError(t, f.Close())
+
+	chunks := (want.Len() + 1023) / 1024
+	assert.EqualValues(t, chunks+1, h.writes.Load())
+
+	f, err = p.cli.Open("/file")
+	require.NoError(t, err)
+	defer f.Close()
+
+	b, err := io.ReadAll(f)
+	require.NoError(t, err)
+	want.Bytes()[0] = 'X'
+	assert.Equal(t, want.Bytes(), b)
+}
+
+func TestWriteBehindDeferredError(t *testing.T) {
+	h := newInstrumentedHandlers()
+	h.failWrite = func(b []byte, off int64) bool { return off+int64(len(b)) > 4096 }
+	p := instrumentedPair(t, h, UseWriteBehind(4), MaxPacketUnchecked(1024))
+
+	f, err := p.cli.Create("/file")
+	require.NoError(t, err)
+
+	chunk := bytes.Repeat([]byte("x"), 100)
+
+	var werr error
+	for i := 0; i < 100 && werr == nil; i++ {
+		_, werr = f.Write(chunk)
+	}
+	require.Error(t, werr, "a later Write reports the failed write")
+
+	var status *StatusError
+	assert.ErrorAs(t, werr, &status)
+
+	_, err = f.Write(chunk)
+	assert.Error(t, err, "the error is sticky")
+
+	_, err = f.WriteAt(chunk, 0)
+	assert.ErrorAs(t, err, &status)
+	_, err = f.ReadFrom(bytes.NewReader(chunk))
+	assert.ErrorAs(t, err, &status)
+	assert.ErrorAs(t, f.Truncate(0), &status)
+
+	assert.Error(t, f.Sync())
+	assert.Error(t, f.Close())
+}
+
+func TestWriteBehindCloseReportsError(t *testing.T) {
+	h := newInstrumentedHandlers()
+	h.failWrite = func(b []byte, off int64) bool { return off+int64(len(b)) > 10 }
+	p := instrumentedPair(t, h, UseWriteBehind(4), MaxPacketUnchecked(1024))
+
+	f, err := p.cli.Create("/file")
+	require.NoError(t, err)
+
+	_, err = f.Write([]byte("more than ten bytes"))
+	require.NoError(t, err, "the write is only buffered")
+
+	err = f.Close()
+	var pathErr *os.PathError
+	require.ErrorAs(t, err, &pathErr)
+	assert.Equal(t, "close", pathErr.Op)
+
+	assert.ErrorIs(t, f.Close(), os.ErrClosed, "the file is closed nonetheless")
+}